@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	healthsvc "google.golang.org/grpc/health"
+	healthz "google.golang.org/grpc/health/grpc_health_v1"
+	"log"
+	"runtime"
+	"time"
+)
+
+// 健康检查探针的执行间隔
+const HealthCheckInterval = time.Second * 5
+
+// 连续失败多少次后置为NOT_SERVING，连续成功多少次后恢复SERVING，避免状态来回抖动
+const (
+	FailureThreshold = 3
+	SuccessThreshold = 2
+)
+
+// 探针函数，返回非nil错误表示依赖不可用
+type probe func() error
+
+// healthChecker 定时执行服务的探针，根据结果更新服务和空字符串表示的整体服务的状态。
+// 功能完整的实现（多个服务、多个探针、探针超时）见根目录下server中的healthManager
+type healthChecker struct {
+	h         *healthsvc.Server
+	service   string
+	probe     probe
+	failures  int // 连续失败次数
+	successes int // 连续成功次数
+	serving   bool
+	checked   bool // 是否已执行过探针，第一次检查的结果直接生效
+}
+
+func newHealthChecker(h *healthsvc.Server, service string, p probe) *healthChecker {
+	return &healthChecker{h: h, service: service, probe: p}
+}
+
+// 每隔interval执行一次探针，不会返回
+func (c *healthChecker) run(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		c.check()
+	}
+}
+
+// 执行一次探针并更新服务状态
+func (c *healthChecker) check() {
+	err := c.probe()
+	if c.update(err) {
+		log.Printf("Health of %s changed to %s: %v", c.service, servingStatus(c.serving), err)
+	}
+	updateServiceHealth(c.h, c.service, servingStatus(c.serving))
+	updateServiceHealth(c.h, "", servingStatus(c.serving))
+}
+
+// 记录一次探针结果，连续失败或成功达到阈值后才切换状态，返回状态是否发生变化
+func (c *healthChecker) update(err error) bool {
+	ok := err == nil
+	if !c.checked {
+		c.checked = true
+		c.serving = ok
+		return true
+	}
+
+	if ok {
+		c.successes++
+		c.failures = 0
+	} else {
+		c.failures++
+		c.successes = 0
+	}
+
+	switch {
+	case c.serving && c.failures >= FailureThreshold:
+		c.serving = false
+		return true
+	case !c.serving && c.successes >= SuccessThreshold:
+		c.serving = true
+		return true
+	}
+	return false
+}
+
+func servingStatus(serving bool) healthz.HealthCheckResponse_ServingStatus {
+	if serving {
+		return healthz.HealthCheckResponse_SERVING
+	}
+	return healthz.HealthCheckResponse_NOT_SERVING
+}
+
+// goroutine数量探针：数量超过max时失败，通常意味着存在泄漏或请求堆积
+func goroutineProbe(max int) probe {
+	return func() error {
+		n := runtime.NumGoroutine()
+		if n > max {
+			return fmt.Errorf("%d goroutines running, want at most %d", n, max)
+		}
+		return nil
+	}
+}
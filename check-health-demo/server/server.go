@@ -24,10 +24,16 @@ func main() {
 	s := grpc.NewServer()
 	h := healthsvc.NewServer()
 	registerServices(s, h)
-	updateServiceHealth(h, svc.Users_ServiceDesc.ServiceName, healthz.HealthCheckResponse_SERVING)
+	// 健康状态由探针决定，探针结果变化时health-check-client的Watch会收到状态变化
+	hc := newHealthChecker(h, svc.Users_ServiceDesc.ServiceName, goroutineProbe(MaxGoroutines))
+	hc.check()
+	go hc.run(HealthCheckInterval)
 	log.Fatal(startServer(s, lis))
 }
 
+// 运行中的goroutine超过该数量时服务置为NOT_SERVING
+const MaxGoroutines = 10000
+
 type userService struct {
 	svc.UnimplementedUsersServer // 对于grpc中任何服务实现都是强制性的
 }
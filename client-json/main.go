@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/calmw/grpc-service/codec"
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails" // 注册错误详情的类型，以便编码为JSON
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		log.Fatal("Must specify a gRPC server address and search query")
+	}
+
+	serverAddr := os.Args[1]
+	query := []byte(os.Args[2])
+	if !json.Valid(query) {
+		log.Fatal("Search query must be a JSON object, e.g. {\"email\":\"jane@doe.com\"}")
+	}
+	// 获取TLS证书
+	tlsCertFile, ok := os.LookupEnv("TLS_CERT_FILE")
+	if !ok {
+		tlsCertFile = "./server.crt"
+	}
+	conn, err := setupGrpcConnection(serverAddr, tlsCertFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	result, err := getUser(conn, query)
+	s := status.Convert(err) // status.Convert函数分别访问错误代码、错误消息和错误详情
+	if s.Code() != codes.OK {
+		data, err := getStatusJson(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintln(os.Stdout, string(data))
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stdout, string(result))
+}
+
+func setupGrpcConnection(addr, tlsCertFile string) (*grpc.ClientConn, error) {
+	creds, err := credentials.NewClientTLSFromFile(tlsCertFile, "")
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return grpc.DialContext(
+		ctx,
+		addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithBlock(), // 确保在函数返回之前建立连接。这意味着如果在服务器启动并运行之前运行客户端，它将无限期等待。
+	)
+}
+
+// 请求和回复直接以JSON在gRPC上传输（content-type为application/grpc+json），由服务端的JSON编解码器与消息相互转换，
+// 客户端不需要生成的消息类型
+func getUser(conn *grpc.ClientConn, query []byte) (codec.RawJSON, error) {
+	ctx := context.Background()
+	// 按环境变量LANG（例如 zh_CN.UTF-8）设置元数据accept-language，服务端据此返回本地化的错误消息
+	if lang := strings.SplitN(os.Getenv("LANG"), ".", 2)[0]; len(lang) != 0 && lang != "C" && lang != "POSIX" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "accept-language", strings.Replace(lang, "_", "-", 1))
+	}
+	var reply codec.RawJSON
+	err := conn.Invoke(ctx, "/Users/GetUser", codec.RawJSON(query), &reply, grpc.CallContentSubtype(codec.JSON))
+	return reply, err
+}
+
+// 错误以JSON输出，错误码使用名称，每个错误详情以protojson编码，@type为详情的类型
+func getStatusJson(s *status.Status) ([]byte, error) {
+	var details []json.RawMessage
+	for _, d := range s.Proto().Details {
+		data, err := protojson.Marshal(d)
+		if err != nil {
+			return nil, err
+		}
+		details = append(details, data)
+	}
+	return json.Marshal(struct {
+		Code    string            `json:"code"`
+		Message string            `json:"message"`
+		Details []json.RawMessage `json:"details,omitempty"`
+	}{strings.ToUpper(codeName(s.Code())), s.Message(), details})
+}
+
+// 例如 InvalidArgument 转换为 INVALID_ARGUMENT，与google.rpc.Code的名称一致
+func codeName(c codes.Code) string {
+	var b strings.Builder
+	for i, r := range c.String() {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
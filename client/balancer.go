@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/balancer/roundrobin"
+	_ "google.golang.org/grpc/health" // 注册客户端健康检查函数，负载均衡器据此剔除状态为NOT_SERVING的后端
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// 最少请求负载均衡策略的名称
+const LeastRequestBalancerName = "least_request"
+
+// 静态后端列表使用的解析器scheme，例如 static:///localhost:50051,localhost:50052
+const StaticResolverScheme = "static"
+
+func init() {
+	balancer.Register(leastRequestBalancerBuilder{})
+}
+
+// 每个ClientConn的负载均衡器使用各自的leastRequestPickerBuilder，进行中的请求数只在同一个ClientConn的后端之间比较
+type leastRequestBalancerBuilder struct{}
+
+func (leastRequestBalancerBuilder) Name() string {
+	return LeastRequestBalancerName
+}
+
+func (leastRequestBalancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &leastRequestPickerBuilder{outstanding: make(map[balancer.SubConn]*int64)}
+	return base.NewBalancerBuilder(
+		LeastRequestBalancerName,
+		pb,
+		base.Config{HealthCheck: true}, // 只有健康检查通过的后端才会进入ReadySCs
+	).Build(cc, opts)
+}
+
+// 根据服务器地址生成拨号目标和负载均衡相关的拨号选项
+// addr可以是单个地址、逗号分隔的多个地址（例如 localhost:50051,localhost:50052）、DNS名称（例如 dns:///users.example.com:50051），
+// 也可以是static或file解析器的目标（例如 static:///localhost:50051,localhost:50052、file:///etc/grpc/users.json）
+// 负载均衡策略由环境变量LB_POLICY指定，可选round_robin（默认）和least_request
+func balancerDialOptions(addr string) (string, []grpc.DialOption, error) {
+	policy, ok := os.LookupEnv("LB_POLICY")
+	if !ok {
+		policy = roundrobin.Name
+	}
+	if policy != roundrobin.Name && policy != LeastRequestBalancerName {
+		return "", nil, fmt.Errorf("unsupported load balancing policy: %s", policy)
+	}
+	// healthCheckConfig开启客户端健康检查，通过grpc_health_v1服务Watch每个后端上Users服务的状态
+	serviceConfig := fmt.Sprintf(
+		`{"loadBalancingConfig": [{"%s": {}}], "healthCheckConfig": {"serviceName": "%s"}}`,
+		policy,
+		svc.Users_ServiceDesc.ServiceName,
+	)
+	opts := []grpc.DialOption{grpc.WithDefaultServiceConfig(serviceConfig)}
+
+	return dialTarget(addr), opts, nil
+}
+
+// 逗号分隔的多个地址转换为static解析器的目标，带scheme的目标（dns、static、file）保持不变
+func dialTarget(addr string) string {
+	if strings.Contains(addr, "://") || !strings.Contains(addr, ",") {
+		return addr
+	}
+	return fmt.Sprintf("%s:///%s", StaticResolverScheme, addr)
+}
+
+// 获取TLS校验使用的主机名，优先使用环境变量TLS_SERVER_NAME
+// static目标使用第一个地址的主机名，file目标在解析前无法得知后端地址，默认使用localhost（证书是为localhost生成的）
+func tlsServerName(target string) string {
+	if name, ok := os.LookupEnv("TLS_SERVER_NAME"); ok {
+		return name
+	}
+	switch {
+	case strings.HasPrefix(target, StaticResolverScheme+":///"):
+		first := strings.Split(strings.TrimPrefix(target, StaticResolverScheme+":///"), ",")[0]
+		host, _, err := net.SplitHostPort(strings.TrimSpace(first))
+		if err != nil {
+			return first
+		}
+		return host
+	case strings.HasPrefix(target, FileResolverScheme+"://"):
+		return "localhost"
+	}
+	return "" // 使用拨号目标中的主机名
+}
+
+// leastRequestPickerBuilder 后端状态每次变化都会重建picker，进行中的请求数保存在outstanding中，不随picker重建而清零。
+// Build由负载均衡器串行调用，outstanding本身不需要加锁
+type leastRequestPickerBuilder struct {
+	outstanding map[balancer.SubConn]*int64
+}
+
+func (b *leastRequestPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	// 不再可用且没有进行中的请求的后端不再需要计数，后端重新可用时从0开始
+	for sc, n := range b.outstanding {
+		if _, ok := info.ReadySCs[sc]; !ok && atomic.LoadInt64(n) == 0 {
+			delete(b.outstanding, sc)
+		}
+	}
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	scs := make([]*leastRequestSubConn, 0, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		n, ok := b.outstanding[sc]
+		if !ok {
+			n = new(int64)
+			b.outstanding[sc] = n
+		}
+		scs = append(scs, &leastRequestSubConn{
+			SubConn:     sc,
+			outstanding: n,
+			weight:      int64(backendFromAddress(sci.Address).Weight),
+		})
+	}
+	return &leastRequestPicker{subConns: scs}
+}
+
+type leastRequestSubConn struct {
+	balancer.SubConn
+	outstanding *int64 // 正在进行中的RPC数量，由同一个后端的所有picker共享
+	weight      int64  // 解析器设置的权重
+}
+
+// leastRequestPicker 随机选取两个后端，选择其中进行中的请求数与权重之比较小的一个（power of two choices）
+type leastRequestPicker struct {
+	subConns []*leastRequestSubConn
+}
+
+func (p *leastRequestPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	sc := p.subConns[rand.Intn(len(p.subConns))]
+	if len(p.subConns) > 1 {
+		other := p.subConns[rand.Intn(len(p.subConns))]
+		// 比较 other.outstanding/other.weight < sc.outstanding/sc.weight
+		if atomic.LoadInt64(other.outstanding)*sc.weight < atomic.LoadInt64(sc.outstanding)*other.weight {
+			sc = other
+		}
+	}
+	atomic.AddInt64(sc.outstanding, 1)
+
+	return balancer.PickResult{
+		SubConn: sc.SubConn,
+		Done: func(balancer.DoneInfo) {
+			atomic.AddInt64(sc.outstanding, -1)
+		},
+	}, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+	"sync/atomic"
+	"testing"
+)
+
+// 测试用的SubConn，picker只比较和返回SubConn，不会调用其方法
+type fakeSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+// 按后端名称和权重构造picker的输入，权重为0时不设置属性
+func readySubConns(weights map[string]uint32) (map[string]*fakeSubConn, base.PickerBuildInfo) {
+	scs := make(map[string]*fakeSubConn)
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	for name, w := range weights {
+		sc := &fakeSubConn{name: name}
+		addr := resolver.Address{Addr: name}
+		if w != 0 {
+			addr.BalancerAttributes = attributes.New(backendAttrKey{}, backend{Addr: name, Weight: w})
+		}
+		scs[name] = sc
+		info.ReadySCs[sc] = base.SubConnInfo{Address: addr}
+	}
+	return scs, info
+}
+
+func newLeastRequestPickerBuilder() *leastRequestPickerBuilder {
+	return &leastRequestPickerBuilder{outstanding: make(map[balancer.SubConn]*int64)}
+}
+
+// 执行n次Pick，hold为true时不调用Done，模拟请求一直在进行中，返回每个后端被选中的次数
+func pickN(t *testing.T, p balancer.Picker, n int, hold bool) map[string]int {
+	t.Helper()
+	picked := make(map[string]int)
+	for i := 0; i < n; i++ {
+		res, err := p.Pick(balancer.PickInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		picked[res.SubConn.(*fakeSubConn).name]++
+		if !hold {
+			res.Done(balancer.DoneInfo{})
+		}
+	}
+	return picked
+}
+
+func TestLeastRequestPickerNoSubConns(t *testing.T) {
+	p := newLeastRequestPickerBuilder().Build(base.PickerBuildInfo{})
+	_, err := p.Pick(balancer.PickInfo{})
+	if !errors.Is(err, balancer.ErrNoSubConnAvailable) {
+		t.Fatalf("got error %v, want ErrNoSubConnAvailable", err)
+	}
+}
+
+func TestLeastRequestPickerAvoidsBusyBackend(t *testing.T) {
+	b := newLeastRequestPickerBuilder()
+	scs, info := readySubConns(map[string]uint32{"a": 0, "b": 0})
+	p := b.Build(info)
+	// a有10个进行中的请求，只有两次随机都选中a时才会选a，概率为1/4
+	pickN(t, p, 10, true)
+	atomic.StoreInt64(b.outstanding[scs["a"]], 10)
+	atomic.StoreInt64(b.outstanding[scs["b"]], 0)
+
+	picked := pickN(t, p, 1000, false)
+	if picked["a"] > 400 {
+		t.Fatalf("busy backend picked %d of 1000 times, want about 250", picked["a"])
+	}
+	if n := atomic.LoadInt64(b.outstanding[scs["b"]]); n != 0 {
+		t.Fatalf("b has %d outstanding requests after Done, want 0", n)
+	}
+}
+
+func TestLeastRequestPickerWeights(t *testing.T) {
+	b := newLeastRequestPickerBuilder()
+	_, info := readySubConns(map[string]uint32{"a": 3, "b": 1})
+	picked := pickN(t, b.Build(info), 400, true)
+	// 请求都未结束时，进行中的请求数趋向于按权重3:1分配
+	if picked["a"] < 250 || picked["a"] > 350 {
+		t.Fatalf("got %v, want about 300 requests on a and 100 on b", picked)
+	}
+}
+
+func TestLeastRequestPickerKeepsCountsAcrossRebuilds(t *testing.T) {
+	b := newLeastRequestPickerBuilder()
+	scs, info := readySubConns(map[string]uint32{"a": 0})
+	res, err := b.Build(info).Pick(balancer.PickInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 后端状态变化重建picker后，进行中的请求数保持不变
+	b.Build(info)
+	if n := atomic.LoadInt64(b.outstanding[scs["a"]]); n != 1 {
+		t.Fatalf("a has %d outstanding requests after rebuild, want 1", n)
+	}
+	// 不再可用的后端在请求结束前仍然计数，结束后被删除
+	b.Build(base.PickerBuildInfo{})
+	if _, ok := b.outstanding[scs["a"]]; !ok {
+		t.Fatal("counter of a was dropped while a request was outstanding")
+	}
+	res.Done(balancer.DoneInfo{})
+	b.Build(base.PickerBuildInfo{})
+	if _, ok := b.outstanding[scs["a"]]; ok {
+		t.Fatal("counter of a was kept after it became unavailable")
+	}
+}
+
+func TestDialTarget(t *testing.T) {
+	for addr, want := range map[string]string{
+		"localhost:50051":                   "localhost:50051",
+		"localhost:50051,localhost:50052":   "static:///localhost:50051,localhost:50052",
+		"dns:///users.example.com:50051":    "dns:///users.example.com:50051",
+		"file:///etc/grpc/users.json":       "file:///etc/grpc/users.json",
+		"static:///localhost:50051,a:50052": "static:///localhost:50051,a:50052",
+	} {
+		if got := dialTarget(addr); got != want {
+			t.Errorf("dialTarget(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
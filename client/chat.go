@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const chatUsage = `Type a message and press Enter to send it. Commands:
+  /reconnect        recreate the stream, unacknowledged messages are replayed
+  /close            wait for all replies, close the stream and exit
+  /meta key=value   add metadata to the stream and reconnect to apply it
+  /history          show sent and received messages
+  /help             show this help`
+
+// 流重建后连续重放但始终没有收到确认的最大次数，避免服务端持续失败时无限重试
+const MaxReplays = 3
+
+func createHelpStream(ctx context.Context, c svc.UsersClient) (svc.Users_GetHelpClient, error) {
+
+	return c.GetHelp(ctx, grpc.WaitForReady(true))
+}
+
+// 交互式GetHelp会话：标准输入的每一行作为一条UserHelpRequest发送，回复带时间戳异步输出，以/开头的行为命令
+// 输入结束（EOF）等同于/close
+func setupChat(r io.Reader, w io.Writer, c svc.UsersClient) error {
+	session, err := newHelpSession()
+	if err != nil {
+		return err
+	}
+	chat := &helpChat{client: c, session: session, md: metadata.MD{}}
+	err = chat.connect()
+	if err != nil {
+		return err
+	}
+	out := &chatOutput{w: w}
+	acked := make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go chat.receive(out, acked, done)
+
+	fmt.Fprintln(w, chatUsage)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		select {
+		case err := <-done: // 接收失败，无法继续会话
+			return err
+		default:
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(line) == 0:
+		case line == "/close":
+			return chat.close(acked, done)
+		case line == "/reconnect":
+			err = chat.reconnect(chat.current())
+			if err != nil {
+				return err
+			}
+			out.notice("Stream recreated")
+		case strings.HasPrefix(line, "/meta "):
+			kv := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, "/meta ")), "=", 2)
+			if len(kv) != 2 || len(kv[0]) == 0 {
+				out.notice("Usage: /meta key=value")
+				continue
+			}
+			chat.setMetadata(kv[0], kv[1])
+			err = chat.reconnect(chat.current())
+			if err != nil {
+				return err
+			}
+			out.notice(fmt.Sprintf("Metadata %s=%s applied", kv[0], kv[1]))
+		case line == "/history":
+			out.history()
+		case line == "/help":
+			fmt.Fprintln(w, chatUsage)
+		case strings.HasPrefix(line, "/"):
+			out.notice(fmt.Sprintf("Unknown command %s, type /help for help", line))
+		default:
+			err = chat.sendMessage(session.next(line))
+			if err != nil {
+				return err
+			}
+			out.record(">", line)
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return err
+	}
+	return chat.close(acked, done)
+}
+
+// helpChat 管理GetHelp当前使用的流，流出错时重建流并重放未确认的消息
+type helpChat struct {
+	client  svc.UsersClient
+	session *helpSession
+
+	mu      sync.Mutex // 保护以下字段及流的发送
+	stream  svc.Users_GetHelpClient
+	cancel  context.CancelFunc // 取消当前的流
+	md      metadata.MD        // 创建流时发送的元数据
+	replays int
+	closed  bool          // 已调用CloseSend，重建的流重放后也要关闭
+	wake    chan struct{} // 流因空闲超时结束后不为nil，下次发送时重新打开流并关闭该channel
+}
+
+func (c *helpChat) connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.newStream()
+}
+
+// 使用当前的元数据创建新的流，并取消旧的流，调用时需持有c.mu
+func (c *helpChat) newStream() error {
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(context.Background(), c.md.Copy()))
+	stream, err := createHelpStream(ctx, c.client)
+	if err != nil {
+		cancel()
+		return err
+	}
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.stream, c.cancel = stream, cancel
+	if c.wake != nil {
+		close(c.wake)
+		c.wake = nil
+	}
+	return nil
+}
+
+// 服务端在RecvMsgTimeout内没有收到消息时以DeadlineExceeded结束流，没有待确认的消息时这是正常的结束，
+// 不立即重建流，返回的channel在下次发送或关闭会话时被关闭。流已被重建时返回nil
+func (c *helpChat) idle(stream svc.Users_GetHelpClient) <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stream != c.stream {
+		return nil
+	}
+	if c.wake == nil {
+		c.wake = make(chan struct{})
+	}
+	return c.wake
+}
+
+// 返回用于发送的流，流已因空闲结束时重新打开
+func (c *helpChat) active() (svc.Users_GetHelpClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.wake != nil {
+		c.replays = 0
+		err := c.newStream()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.stream, nil
+}
+
+func (c *helpChat) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *helpChat) current() svc.Users_GetHelpClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stream
+}
+
+func (c *helpChat) setMetadata(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.md.Set(key, value)
+}
+
+func (c *helpChat) send(stream svc.Users_GetHelpClient, request *svc.UserHelpRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stream != c.stream { // 流已经被重建，消息已随重放发送
+		return nil
+	}
+	request.Ack = c.session.lastAck()
+	return stream.Send(request)
+}
+
+// 发送消息，失败时重建流，消息会随重放一起发送
+func (c *helpChat) sendMessage(request *svc.UserHelpRequest) error {
+	stream, err := c.active()
+	if err != nil {
+		return err
+	}
+	err = c.send(stream, request)
+	if err == nil {
+		return nil
+	}
+	log.Printf("Sending request failed: %v. Will retry.\n", err)
+	return c.reconnect(stream)
+}
+
+// 服务端没有会话的状态（服务端重启、流被路由到其他后端或会话空闲过期）时以FailedPrecondition结束流，
+// 改用新的会话重新发送未确认的消息。旧会话中已被处理但回复未送达的消息会被再次处理。broken为出错的流
+func (c *helpChat) restartSession(broken svc.Users_GetHelpClient, out *chatOutput) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if broken != c.stream {
+		return nil
+	}
+	n, err := c.session.restart()
+	if err != nil {
+		return err
+	}
+	out.notice(fmt.Sprintf("Session lost on the server, continuing with a new session; %d unacknowledged messages are resent", n))
+	return nil
+}
+
+// 重建流并重放所有未确认的消息，broken为出错的流，若流已被其他goroutine重建则直接返回
+func (c *helpChat) reconnect(broken svc.Users_GetHelpClient) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if broken != c.stream {
+		return nil
+	}
+	if c.session.progressed() || !c.session.hasPending() {
+		c.replays = 0
+	}
+	for {
+		c.replays++
+		if c.replays > MaxReplays {
+			return fmt.Errorf("giving up after %d replays without acknowledgement", MaxReplays)
+		}
+		err := c.newStream()
+		if err != nil {
+			return err
+		}
+		err = c.replay(c.stream)
+		if err == nil && c.closed {
+			err = c.stream.CloseSend()
+		}
+		if err == nil {
+			return nil
+		}
+		log.Printf("Replaying unacknowledged requests failed: %v", err)
+	}
+}
+
+func (c *helpChat) replay(stream svc.Users_GetHelpClient) error {
+	for _, request := range c.session.unacked() {
+		request.Ack = c.session.lastAck()
+		err := stream.Send(request)
+		if err != nil {
+			return err
+		}
+		log.Printf("Request replayed: %s\n", request.Request)
+	}
+	return nil
+}
+
+// 接收回复直到流正常结束，流出错时重建流后继续接收
+func (c *helpChat) receive(out *chatOutput, acked chan<- struct{}, done chan<- error) {
+	for {
+		stream := c.current()
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			done <- nil
+			return
+		}
+		if status.Code(err) == codes.DeadlineExceeded && !c.session.hasPending() {
+			if wake := c.idle(stream); wake != nil {
+				log.Printf("Stream ended after being idle, it will be reopened on the next message")
+				<-wake
+			}
+			if c.isClosed() {
+				done <- nil
+				return
+			}
+			continue
+		}
+		if status.Code(err) == codes.FailedPrecondition {
+			log.Printf("Session rejected by the server: %v", err)
+			restartErr := c.restartSession(stream, out)
+			if restartErr != nil {
+				done <- restartErr
+				return
+			}
+		}
+		if err != nil {
+			err = c.reconnect(stream)
+			if err != nil {
+				log.Printf("Recreating stream failed: %v", err)
+				done <- err
+				return
+			}
+			continue
+		}
+		if !c.session.ack(resp) { // 重放导致的重复回复
+			continue
+		}
+		out.record("<", resp.Response)
+		select {
+		case acked <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 等待所有消息都被确认后关闭流，然后等待服务端结束流
+func (c *helpChat) close(acked <-chan struct{}, done <-chan error) error {
+	for c.session.hasPending() {
+		select {
+		case <-acked:
+		case err := <-done:
+			return err
+		}
+	}
+	c.mu.Lock()
+	c.closed = true
+	var err error
+	if c.wake != nil { // 流已因空闲结束，唤醒接收的goroutine退出
+		close(c.wake)
+		c.wake = nil
+	} else {
+		err = c.stream.CloseSend() // 该方法将关闭服务器上的客户端链接，并返回io.EOF错误值
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	err = <-done
+	c.mu.Lock()
+	c.cancel()
+	c.mu.Unlock()
+	return err
+}
+
+// chatOutput 带时间戳输出回复和提示，并记录收发历史
+type chatOutput struct {
+	mu      sync.Mutex
+	w       io.Writer
+	entries []chatEntry
+}
+
+type chatEntry struct {
+	time      time.Time
+	direction string // ">" 发送，"<" 接收
+	text      string
+}
+
+func (o *chatOutput) record(direction, text string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e := chatEntry{time: time.Now(), direction: direction, text: text}
+	o.entries = append(o.entries, e)
+	if direction == "<" {
+		fmt.Fprintf(o.w, "[%s] %s %s\n", e.time.Format("15:04:05.000"), e.direction, e.text)
+	}
+}
+
+func (o *chatOutput) notice(msg string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	fmt.Fprintf(o.w, "[%s] * %s\n", time.Now().Format("15:04:05.000"), msg)
+}
+
+func (o *chatOutput) history() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, e := range o.entries {
+		fmt.Fprintf(o.w, "[%s] %s %s\n", e.time.Format("15:04:05.000"), e.direction, e.text)
+	}
+}
+
+// helpSession 记录GetHelp会话中尚未被服务端确认的消息，服务端按会话ID和序号去重，
+// 流重建后重放这些消息即可实现消息的恰好一次投递。服务端丢失了会话时改用新的会话ID，只能保证至少一次
+type helpSession struct {
+	mu        sync.Mutex
+	id        string
+	nextSeq   uint64
+	acked     uint64 // 已收到回复的最大序号
+	lastCheck uint64 // 上次调用progressed时的acked
+	pending   []*svc.UserHelpRequest
+}
+
+func newHelpSession() (*helpSession, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	return &helpSession{id: id}, nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 改用新的会话ID，未确认的消息按原来的顺序从1重新编号，返回未确认的消息数
+func (s *helpSession) restart() (int, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.id = id
+	s.acked, s.lastCheck = 0, 0
+	for i, request := range s.pending {
+		request.SessionId = id
+		request.Seq = uint64(i + 1)
+	}
+	s.nextSeq = uint64(len(s.pending))
+	return len(s.pending), nil
+}
+
+// 为消息分配序号并加入待确认队列
+func (s *helpSession) next(msg string) *svc.UserHelpRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextSeq++
+	request := &svc.UserHelpRequest{
+		Request:   msg,
+		SessionId: s.id,
+		Seq:       s.nextSeq,
+	}
+	s.pending = append(s.pending, request)
+	return request
+}
+
+// 处理服务端的确认，移除已确认的消息，重复的回复返回false
+func (s *helpSession) ack(reply *svc.UserHelpReply) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reply.Ack <= s.acked {
+		return false
+	}
+	s.acked = reply.Ack
+	i := 0
+	for i < len(s.pending) && s.pending[i].Seq <= reply.Ack {
+		i++
+	}
+	s.pending = s.pending[i:]
+	return true
+}
+
+func (s *helpSession) lastAck() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.acked
+}
+
+// 自上次调用以来是否收到了新的确认
+func (s *helpSession) progressed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ok := s.acked > s.lastCheck
+	s.lastCheck = s.acked
+	return ok
+}
+
+func (s *helpSession) unacked() []*svc.UserHelpRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*svc.UserHelpRequest(nil), s.pending...)
+}
+
+func (s *helpSession) hasPending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending) != 0
+}
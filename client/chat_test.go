@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chatServer 测试用的GetHelp服务端，与server中的会话一样按会话ID和序号去重，
+// plans按顺序控制前几个流的行为，之后的流正常处理所有消息
+type chatServer struct {
+	svc.UnimplementedUsersServer
+
+	mu       sync.Mutex
+	plans    []streamPlan
+	streams  [][]*svc.UserHelpRequest // 每个流收到的消息
+	sessions map[string]uint64        // 每个会话已处理的最大序号
+}
+
+type streamPlan struct {
+	process int // 正常处理并回复的消息数，之后收到的消息不回复
+	breakAt int // 收到第breakAt条消息后以UNAVAILABLE结束流
+}
+
+func (s *chatServer) GetHelp(stream svc.Users_GetHelpServer) error {
+	s.mu.Lock()
+	i := len(s.streams)
+	s.streams = append(s.streams, nil)
+	plan := streamPlan{process: -1}
+	if i < len(s.plans) {
+		plan = s.plans[i]
+	}
+	s.mu.Unlock()
+
+	for n := 1; ; n++ {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reply, err := s.handle(i, n, plan, request)
+		if err != nil {
+			return err
+		}
+		if reply != nil {
+			err = stream.Send(reply)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// 处理第i个流收到的第n条消息，返回nil表示不回复
+func (s *chatServer) handle(i, n int, plan streamPlan, request *svc.UserHelpRequest) (*svc.UserHelpReply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[i] = append(s.streams[i], request)
+	if n == plan.breakAt {
+		return nil, status.Error(codes.Unavailable, "stream broken")
+	}
+	if plan.process >= 0 && n > plan.process {
+		return nil, nil
+	}
+	last := s.sessions[request.SessionId]
+	if request.Seq > last+1 {
+		return nil, status.Errorf(codes.FailedPrecondition, "Unexpected sequence number %d, expected %d", request.Seq, last+1)
+	}
+	if request.Seq == last+1 {
+		s.sessions[request.SessionId] = request.Seq
+	}
+	return &svc.UserHelpReply{Response: request.Request, Ack: request.Seq}, nil
+}
+
+// 第i个流收到的消息，格式为 序号:内容
+func (s *chatServer) received(i int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var msgs []string
+	if i < len(s.streams) {
+		for _, r := range s.streams[i] {
+			msgs = append(msgs, fmt.Sprintf("%d:%s", r.Seq, r.Request))
+		}
+	}
+	return msgs
+}
+
+func (s *chatServer) streamCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// 在bufconn上启动chatServer，返回的函数停止服务端
+func startChatServer(plans ...streamPlan) (*chatServer, *bufconn.Listener, func()) {
+	cs := &chatServer{plans: plans, sessions: make(map[string]uint64)}
+	s := grpc.NewServer()
+	svc.RegisterUsersServer(s, cs)
+	lis := bufconn.Listen(1024 * 1024)
+	go s.Serve(lis)
+	return cs, lis, s.Stop
+}
+
+// chatTarget 客户端拨号时连接到当前的服务端，替换listener即模拟重连到另一个后端
+type chatTarget struct {
+	mu  sync.Mutex
+	lis *bufconn.Listener
+}
+
+func (ct *chatTarget) set(lis *bufconn.Listener) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	ct.lis = lis
+}
+
+func (ct *chatTarget) dial(ctx context.Context, _ string) (net.Conn, error) {
+	ct.mu.Lock()
+	lis := ct.lis
+	ct.mu.Unlock()
+	return lis.DialContext(ctx)
+}
+
+func dialChat(t *testing.T, ct *chatTarget) svc.UsersClient {
+	t.Helper()
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(ct.dial),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return svc.NewUsersClient(conn)
+}
+
+// syncBuffer 并发安全的输出缓冲区
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// 在后台运行setupChat，返回用于输入的管道、输出和setupChat的结果
+func runChat(t *testing.T, c svc.UsersClient) (*io.PipeWriter, *syncBuffer, <-chan error) {
+	t.Helper()
+	r, w := io.Pipe()
+	out := &syncBuffer{}
+	result := make(chan error, 1)
+	go func() {
+		result <- setupChat(r, out, c)
+		r.Close()
+	}()
+	t.Cleanup(func() { w.Close() })
+	return w, out, result
+}
+
+func input(t *testing.T, w io.Writer, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		_, err := io.WriteString(w, line+"\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func waitResult(t *testing.T, result <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(time.Second * 10):
+		t.Fatal("timed out waiting for the chat to end")
+	}
+	return nil
+}
+
+func waitOutput(t *testing.T, out *syncBuffer, want string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for !strings.Contains(out.String(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %q in output:\n%s", want, out.String())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// 输出中收到的回复，按收到的顺序
+func replies(out *syncBuffer) []string {
+	var got []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if i := strings.Index(line, "] < "); i >= 0 {
+			got = append(got, line[i+len("] < "):])
+		}
+	}
+	return got
+}
+
+func assertStrings(t *testing.T, what string, got, want []string) {
+	t.Helper()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %s %v, want %v", what, got, want)
+	}
+}
+
+func TestHelpSessionAck(t *testing.T) {
+	s, err := newHelpSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"a", "b", "c"} {
+		s.next(msg)
+	}
+	if !s.ack(&svc.UserHelpReply{Ack: 2}) {
+		t.Fatal("new ack was treated as duplicate")
+	}
+	if s.ack(&svc.UserHelpReply{Ack: 1}) {
+		t.Fatal("old ack was not treated as duplicate")
+	}
+	pending := s.unacked()
+	if len(pending) != 1 || pending[0].Seq != 3 || s.lastAck() != 2 {
+		t.Fatalf("unexpected pending messages %v after ack 2", pending)
+	}
+	if !s.progressed() || s.progressed() {
+		t.Fatal("progressed should report the new ack exactly once")
+	}
+}
+
+func TestHelpSessionRestart(t *testing.T) {
+	s, err := newHelpSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"a", "b", "c"} {
+		s.next(msg)
+	}
+	s.ack(&svc.UserHelpReply{Ack: 1})
+	old := s.id
+
+	n, err := s.restart()
+	if err != nil || n != 2 {
+		t.Fatalf("restart returned %d, %v", n, err)
+	}
+	// 未确认的消息按原来的顺序从1重新编号
+	pending := s.unacked()
+	if pending[0].Seq != 1 || pending[0].Request != "b" || pending[1].Seq != 2 || pending[1].Request != "c" {
+		t.Fatalf("unexpected pending messages after restart: %v", pending)
+	}
+	if pending[0].SessionId == old || pending[0].SessionId != s.id || s.lastAck() != 0 {
+		t.Fatalf("session was not restarted: %v", pending)
+	}
+	if r := s.next("d"); r.Seq != 3 || r.SessionId != s.id {
+		t.Fatalf("unexpected message after restart: %v", r)
+	}
+}
+
+// 已确认的消息从待确认队列中移除，流重建后只重放未确认的消息，并携带最新的确认序号
+func TestChatReplaysOnlyUnacked(t *testing.T) {
+	cs, lis, stop := startChatServer(streamPlan{process: 2, breakAt: 3})
+	defer stop()
+	w, out, result := runChat(t, dialChat(t, &chatTarget{lis: lis}))
+
+	input(t, w, "a", "b", "c")
+	w.Close()
+	if err := waitResult(t, result); err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, "replies", replies(out), []string{"a", "b", "c"})
+	assertStrings(t, "replayed messages", cs.received(1), []string{"3:c"})
+	cs.mu.Lock()
+	ack := cs.streams[1][0].Ack
+	cs.mu.Unlock()
+	if ack != 2 {
+		t.Fatalf("replayed message carries ack %d, want 2", ack)
+	}
+}
+
+// 流重建后按发送的顺序重放未确认的消息
+func TestChatReplayOrder(t *testing.T) {
+	cs, lis, stop := startChatServer(streamPlan{process: 0, breakAt: 3})
+	defer stop()
+	w, out, result := runChat(t, dialChat(t, &chatTarget{lis: lis}))
+
+	input(t, w, "a", "b", "c")
+	w.Close()
+	if err := waitResult(t, result); err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, "messages on the first stream", cs.received(0), []string{"1:a", "2:b", "3:c"})
+	assertStrings(t, "replayed messages", cs.received(1), []string{"1:a", "2:b", "3:c"})
+	assertStrings(t, "replies", replies(out), []string{"a", "b", "c"})
+}
+
+// 重放后始终没有收到确认时，重放MaxReplays次后放弃
+func TestChatMaxReplays(t *testing.T) {
+	plans := make([]streamPlan, MaxReplays+2)
+	for i := range plans {
+		plans[i] = streamPlan{process: 0, breakAt: 1}
+	}
+	cs, lis, stop := startChatServer(plans...)
+	defer stop()
+	w, _, result := runChat(t, dialChat(t, &chatTarget{lis: lis}))
+
+	input(t, w, "a")
+	w.Close()
+	err := waitResult(t, result)
+	want := fmt.Sprintf("giving up after %d replays without acknowledgement", MaxReplays)
+	if err == nil || err.Error() != want {
+		t.Fatalf("got error %v, want %q", err, want)
+	}
+	if n := cs.streamCount(); n != MaxReplays+1 {
+		t.Fatalf("server saw %d streams, want %d", n, MaxReplays+1)
+	}
+}
+
+// 重连到没有会话状态的新服务端（例如服务端重启）后，服务端拒绝旧会话的序号，客户端以新的会话重新发送
+func TestChatReconnectToFreshServer(t *testing.T) {
+	_, lis, stop := startChatServer()
+	ct := &chatTarget{lis: lis}
+	w, out, result := runChat(t, dialChat(t, ct))
+
+	input(t, w, "a")
+	waitOutput(t, out, "] < a")
+
+	fresh, freshLis, stopFresh := startChatServer()
+	defer stopFresh()
+	ct.set(freshLis)
+	stop()
+
+	input(t, w, "b")
+	waitOutput(t, out, "] < b")
+	w.Close()
+	if err := waitResult(t, result); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Session lost on the server, continuing with a new session; 1 unacknowledged messages are resent") {
+		t.Fatalf("session loss not reported:\n%s", out.String())
+	}
+	assertStrings(t, "replies", replies(out), []string{"a", "b"})
+	// 新服务端先收到旧会话的序号2，拒绝后收到新会话的序号1。重放的消息可能被发送消息的goroutine再发送一次，
+	// 服务端按序号去重，因此忽略连续重复的消息
+	var got []string
+	for i := 0; i < fresh.streamCount(); i++ {
+		for _, msg := range fresh.received(i) {
+			if len(got) == 0 || got[len(got)-1] != msg {
+				got = append(got, msg)
+			}
+		}
+	}
+	assertStrings(t, "messages on the fresh server", got, []string{"2:b", "1:b"})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 按环境变量LANG（例如 zh_CN.UTF-8）设置元数据accept-language，服务端据此返回本地化的错误消息
+func acceptLanguage() string {
+	lang := strings.SplitN(os.Getenv("LANG"), ".", 2)[0]
+	if len(lang) == 0 || lang == "C" || lang == "POSIX" {
+		return ""
+	}
+	return strings.Replace(lang, "_", "-", 1)
+}
+
+// 输出错误码、错误信息以及服务端附加的错误详情
+func formatStatus(s *status.Status) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Request failed: %v-%v", s.Code(), s.Message())
+	for _, d := range s.Details() {
+		switch d := d.(type) {
+		case *errdetails.BadRequest:
+			for _, v := range d.FieldViolations {
+				fmt.Fprintf(&b, "\n  invalid field %s: %s", v.Field, v.Description)
+			}
+		case *errdetails.ErrorInfo:
+			fmt.Fprintf(&b, "\n  reason: %s (%s)", d.Reason, d.Domain)
+			keys := make([]string, 0, len(d.Metadata))
+			for k := range d.Metadata {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Fprintf(&b, " %s=%s", k, d.Metadata[k])
+			}
+		case *errdetails.RetryInfo:
+			fmt.Fprintf(&b, "\n  retry after: %v", d.RetryDelay.AsDuration())
+		case *errdetails.LocalizedMessage:
+			fmt.Fprintf(&b, "\n  %s: %s", d.Locale, d.Message)
+		case error: // 无法解码的详情
+			fmt.Fprintf(&b, "\n  undecodable detail: %v", d)
+		default:
+			fmt.Fprintf(&b, "\n  %T: %v", d, d)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 按环境变量设置流控窗口、缓冲区和消息大小，与服务端使用相同的变量名，未设置的使用grpc的默认值：
+// GRPC_INITIAL_WINDOW_SIZE、GRPC_INITIAL_CONN_WINDOW_SIZE（小于64KB时被忽略，设置后不再根据BDP动态调整）、
+// GRPC_WRITE_BUFFER_SIZE、GRPC_READ_BUFFER_SIZE、GRPC_MAX_RECV_MSG_SIZE、GRPC_MAX_SEND_MSG_SIZE
+func transportDialOptions() ([]grpc.DialOption, error) {
+	var opts []grpc.DialOption
+	var callOpts []grpc.CallOption
+	for _, s := range []struct {
+		key    string
+		option func(n int)
+	}{
+		{"GRPC_INITIAL_WINDOW_SIZE", func(n int) { opts = append(opts, grpc.WithInitialWindowSize(int32(n))) }},
+		{"GRPC_INITIAL_CONN_WINDOW_SIZE", func(n int) { opts = append(opts, grpc.WithInitialConnWindowSize(int32(n))) }},
+		{"GRPC_WRITE_BUFFER_SIZE", func(n int) { opts = append(opts, grpc.WithWriteBufferSize(n)) }},
+		{"GRPC_READ_BUFFER_SIZE", func(n int) { opts = append(opts, grpc.WithReadBufferSize(n)) }},
+		{"GRPC_MAX_RECV_MSG_SIZE", func(n int) { callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(n)) }},
+		{"GRPC_MAX_SEND_MSG_SIZE", func(n int) { callOpts = append(callOpts, grpc.MaxCallSendMsgSize(n)) }},
+	} {
+		v, ok := os.LookupEnv(s.key)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid %s: %s", s.key, v)
+		}
+		s.option(n)
+	}
+	if len(callOpts) != 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+	return opts, nil
+}
+
+// 流RPC方法调用的发送统计拦截器，位于拦截器链的最内层，测量SendMsg因流控窗口用完而阻塞的时间，
+// 流结束时输出发送的消息数、字节数和阻塞时间
+func flowStreamInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &flowClientStream{ClientStream: stream, method: method, serverStreams: desc.ServerStreams}, nil
+}
+
+type flowClientStream struct {
+	grpc.ClientStream
+	method        string
+	serverStreams bool
+	mu            sync.Mutex
+	sends         int
+	bytes         int
+	blocked       time.Duration
+	maxBlocked    time.Duration
+	reported      sync.Once
+}
+
+func (s *flowClientStream) SendMsg(m interface{}) error {
+	start := time.Now()
+	err := s.ClientStream.SendMsg(m)
+	d := time.Since(start)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sends++
+	s.blocked += d
+	if d > s.maxBlocked {
+		s.maxBlocked = d
+	}
+	if pm, ok := m.(proto.Message); ok {
+		s.bytes += proto.Size(pm)
+	}
+	return err
+}
+
+// 服务端流方法在RecvMsg返回错误（包括io.EOF）时结束，否则收到回复后结束
+func (s *flowClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.reported.Do(s.report)
+	}
+	return err
+}
+
+func (s *flowClientStream) report() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sends == 0 {
+		return
+	}
+	log.Printf("Stream %s: sent %d messages (%d bytes), blocked %v (max %v)",
+		s.method, s.sends, s.bytes, s.blocked, s.maxBlocked)
+}
@@ -2,32 +2,45 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	svc "github.com/calmw/grpc-service"
+	"github.com/calmw/grpc-service/codec"
+	"github.com/calmw/grpc-service/compress"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
-	"io"
 	"log"
 	"os"
 	"time"
 )
 
 func main() {
-	if len(os.Args) != 3 {
+	var cf compressFlags
+	flag.StringVar(&cf.call, "compress", "", "compressor used for this call regardless of the compression policy and size, e.g. gzip, zstd or identity")
+	flag.StringVar(&cf.methods, "compress-methods", "", "comma separated per-method compressors, e.g. /Repo/CreateRepo=zstd,/Users/*=gzip, overriding the same methods in GRPC_COMPRESSION_METHODS")
+	flag.IntVar(&cf.minSize, "compress-min-size", -1, "unary requests smaller than this many bytes are sent uncompressed; negative uses GRPC_COMPRESS_MIN_SIZE, or 1024 if unset. "+
+		"Only the request size is checked: the server compresses the reply with the request's compressor, so replies of uncompressed requests are never compressed and replies of compressed requests always are")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: client [flags] <server> <method>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
 		log.Fatal("Specify a gRPC server and method to call")
 	}
-	serverAddr := os.Args[1]
-	methodName := os.Args[2]
+	serverAddr := flag.Arg(0)
+	methodName := flag.Arg(1)
 
 	// 获取TLS证书
 	tlsCertFile, ok := os.LookupEnv("TLS_CERT_FILE")
 	if !ok {
 		tlsCertFile = "./server.crt"
 	}
-	conn, cancel, err := setupGrpcConnection(serverAddr, tlsCertFile)
+	conn, cancel, err := setupGrpcConnection(serverAddr, tlsCertFile, cf)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -44,9 +57,9 @@ func main() {
 			Email: "cisco@doe.com", // @前为panic时触发服务端panic，其它正常
 		}, grpc.WaitForReady(true))
 
-		s := status.Convert(err) // status.Convert函数分别访问错误代码和错误消息
+		s := status.Convert(err) // status.Convert函数分别访问错误代码、错误消息和错误详情
 		if s.Code() != codes.OK {
-			log.Fatalf("Request failed: %v-%v\n", s.Code(), s.Message())
+			log.Fatal(formatStatus(s))
 		}
 		fmt.Fprintf(
 			os.Stdout,
@@ -62,6 +75,18 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
+	case "CreateRepo":
+		// 从标准输入读取仓库数据，按流协议发送：context、分块的data和携带校验和的trailer
+		stream, err := svc.NewRepoClient(conn).CreateRepo(context.Background())
+		if err != nil {
+			log.Fatal(err)
+		}
+		repoContext := &svc.RepoContext{CreatorId: "user-123", Name: "test-repo"}
+		result, err := svc.CreateRepoFromReader(stream, repoContext, os.Stdin, nil)
+		if err != nil {
+			log.Fatal(formatStatus(status.Convert(err)))
+		}
+		fmt.Fprintf(os.Stdout, "Repo: %s, Size: %d, SHA-256: %s\n", result.Repo.Url, result.Size, result.Sha256)
 	default:
 		log.Fatal("Unrecognized method name")
 	}
@@ -86,6 +111,9 @@ func metadataUnaryInterceptor(
 		"Request-Id",
 		"request-123",
 	)
+	if lang := acceptLanguage(); len(lang) != 0 {
+		ctxWithMetadata = metadata.AppendToOutgoingContext(ctxWithMetadata, "accept-language", lang)
+	}
 	return invoker(
 		ctxWithMetadata,
 		method,
@@ -123,38 +151,114 @@ func metadataStreamInterceptor(
 	return clientStream, err
 }
 
-func setupGrpcConnection(addr, tlsCertFile string) (*grpc.ClientConn, context.CancelFunc, error) {
+// compressFlags 命令行指定的压缩器，优先于环境变量中的压缩策略
+type compressFlags struct {
+	call    string // 本次调用使用的压缩器
+	methods string // 方法=压缩器 列表
+	minSize int    // 一元请求的压缩阈值，小于0时使用环境变量中的设置
+}
+
+// 按环境变量GRPC_COMPRESSION（所有方法）和GRPC_COMPRESSION_METHODS（按方法）压缩请求，小于GRPC_COMPRESS_MIN_SIZE（或-compress-min-size）
+// 的一元请求不压缩，阈值只检查请求的大小，不检查回复。-compress-methods覆盖相同方法的设置。注册策略和-compress用到的压缩器，返回的调用选项使本次调用使用-compress指定的压缩器
+func (cf compressFlags) policy() (*compress.Policy, []grpc.CallOption, error) {
+	p, err := compress.PolicyFromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	methods, err := compress.ParseMethods(cf.methods)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid -compress-methods: %v", err)
+	}
+	for method, name := range methods {
+		p.Methods[method] = name
+	}
+	if cf.minSize >= 0 {
+		p.MinSize = cf.minSize
+	}
+	err = p.Validate()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = compress.Register(p.Names()...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(cf.call) == 0 {
+		return p, nil, nil
+	}
+	if cf.call != encoding.Identity {
+		err = compress.Register(cf.call)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid -compress: %v", err)
+		}
+	}
+	// 默认调用选项排在策略选择的压缩器之后，因此优先于策略
+	return p, []grpc.CallOption{grpc.UseCompressor(cf.call)}, nil
+}
+
+func setupGrpcConnection(addr, tlsCertFile string, cf compressFlags) (*grpc.ClientConn, context.CancelFunc, error) {
 	log.Printf("Connecting to server on %s\n", addr)
 	ctx, cancel := context.WithTimeout(
 		context.Background(),
 		10*time.Second,
 	)
 
-	creds, err := credentials.NewClientTLSFromFile(tlsCertFile, "") // 如果第二个参数非空，将覆盖在证书中找到的主机名。并且该主机名将被信任。我们将为localhost主机名生成TLS证书，这是我们希望客户端信任的主机名，因此指定一个空字符串。
+	target, lbOptions, err := balancerDialOptions(addr)
+	if err != nil {
+		return nil, cancel, err
+	}
+
+	creds, err := credentials.NewClientTLSFromFile(tlsCertFile, tlsServerName(target)) // 如果第二个参数非空，将覆盖在证书中找到的主机名。并且该主机名将被信任。我们将为localhost主机名生成TLS证书，这是我们希望客户端信任的主机名。单个地址时指定空字符串，使用地址中的主机名；多个后端时由tlsServerName决定。
 	if err != nil {
 		return nil, cancel, err
 	}
 
 	credsOption := grpc.WithTransportCredentials(creds)
 
+	transportOptions, err := transportDialOptions() // 流控窗口、缓冲区和消息大小
+	if err != nil {
+		return nil, cancel, err
+	}
+
+	compression, compressOptions, err := cf.policy()
+	if err != nil {
+		return nil, cancel, err
+	}
+	if len(compressOptions) != 0 {
+		transportOptions = append(transportOptions, grpc.WithDefaultCallOptions(compressOptions...))
+	}
+
+	// 环境变量GRPC_CODEC选择消息的编解码器：json（application/grpc+json）或vtproto（生成代码的快速protobuf编解码），默认为proto
+	if name := os.Getenv("GRPC_CODEC"); len(name) != 0 {
+		if encoding.GetCodec(name) == nil {
+			return nil, cancel, fmt.Errorf("unknown codec %q, want one of proto, %s, %s", name, codec.JSON, codec.Fast)
+		}
+		transportOptions = append(transportOptions, grpc.WithDefaultCallOptions(grpc.CallContentSubtype(name)))
+	}
+
 	// DialContext 在配置这两项 grpc.FailOnNonTempDialError(true), grpc.WithReturnConnectionError()后，将表现出以下行为
 	// 1）遇到非临时错误时会立即返回。返回的错误值将包含遇到的错误详细信息。
 	// 2）如果遇到非临时错误，它只会尝试建立连接10秒，该函数将返回非临时错误详细信息的错误值
 	conn, err := grpc.DialContext(
 		ctx,
-		addr,
-		credsOption,
-		grpc.WithBlock(),                  // 确保在函数返回之前建立连接。这意味着如果在服务器启动并运行之前运行客户端，它将无限期等待。即使存在需要检查的永久性故障（例如：指定格式错误的服务器地址活不存在的主机名），这也可能导致客户端继续尝试建立连接而不退出。增加下面选项后，有些情况就不会一直等待，不返回错误
-		grpc.FailOnNonTempDialError(true), // true参数，如果发生非临时错误，将不再尝试重新建立连接，DialContext函数将返回遇到的错误
-		grpc.WithReturnConnectionError(),  // 使用此选项，当发生临时错误并且上下文在DialContext函数成功之前到期时，返回的错误还将包含阻止连接发生的原始错误。
-		grpc.WithChainUnaryInterceptor( // 用于注册多个客户端一元拦截器，最内层的拦截器首先执行
-			metadataUnaryInterceptor,
-			// ... 其他拦截器
-		),
-		grpc.WithChainStreamInterceptor( // 用于注册多个客户端流拦截器，最内层的拦截器首先执行
-			metadataStreamInterceptor,
-			// ... 其他拦截器
-		),
+		target,
+		append([]grpc.DialOption{
+			credsOption,
+			grpc.WithBlock(),                  // 确保在函数返回之前建立连接。这意味着如果在服务器启动并运行之前运行客户端，它将无限期等待。即使存在需要检查的永久性故障（例如：指定格式错误的服务器地址活不存在的主机名），这也可能导致客户端继续尝试建立连接而不退出。增加下面选项后，有些情况就不会一直等待，不返回错误
+			grpc.FailOnNonTempDialError(true), // true参数，如果发生非临时错误，将不再尝试重新建立连接，DialContext函数将返回遇到的错误
+			grpc.WithReturnConnectionError(),  // 使用此选项，当发生临时错误并且上下文在DialContext函数成功之前到期时，返回的错误还将包含阻止连接发生的原始错误。
+			grpc.WithChainUnaryInterceptor( // 用于注册多个客户端一元拦截器，最内层的拦截器首先执行
+				metadataUnaryInterceptor,
+				// ... 其他拦截器
+				compression.UnaryClientInterceptor,
+			),
+			grpc.WithChainStreamInterceptor( // 用于注册多个客户端流拦截器，最内层的拦截器首先执行
+				metadataStreamInterceptor,
+				// ... 其他拦截器
+				compression.StreamClientInterceptor,
+				flowStreamInterceptor, // 最内层，测量SendMsg在传输层阻塞的时间
+			),
+		}, append(lbOptions, transportOptions...)...)...,
 	)
 
 	return conn, cancel, err
@@ -164,94 +268,6 @@ func getUserServiceClient(conn *grpc.ClientConn) svc.UsersClient {
 	return svc.NewUsersClient(conn)
 }
 
-func createHelpStream(c svc.UsersClient) (svc.Users_GetHelpClient, error) {
-
-	return c.GetHelp(context.Background(), grpc.WaitForReady(true))
-}
-
-func setupChat(r io.Reader, w io.Writer, c svc.UsersClient) error {
-	var clientConn = make(chan svc.Users_GetHelpClient)
-	var done = make(chan struct{})
-
-	stream, err := createHelpStream(c)
-	defer stream.CloseSend()
-	if err != nil {
-		return err
-	}
-
-	go func() {
-		for {
-			clientConn <- stream
-
-			resp, err := stream.Recv()
-			if err == io.EOF {
-				done <- struct{}{}
-			}
-
-			if err != nil {
-				stream, err = createHelpStream(c)
-				if err != nil {
-					log.Printf("Recreating stream failed: %v", err)
-					close(clientConn)
-					done <- struct{}{}
-					break
-				}
-
-			} else {
-				fmt.Printf("Response: %s\n", resp.Response)
-			}
-
-		}
-	}()
-
-	requestMsg := "hello"
-	msgCount := 1
-	for {
-		if msgCount > 10 {
-			break
-		}
-		stream = <-clientConn
-		if stream == nil {
-			break
-		}
-		if msgCount == 6 {
-			time.Sleep(time.Second * 2)
-		}
-
-		request := svc.UserHelpRequest{
-			Request: fmt.Sprintf("%s-%d", requestMsg, msgCount),
-		}
-
-		err = stream.Send(&request)
-
-		if err == io.EOF {
-			var m svc.UserGetReply
-			err = stream.RecvMsg(&m)
-			if err != nil {
-				stream, err = createHelpStream(c)
-				if err != nil {
-					log.Printf("Recreating stream failed: %v", err)
-					close(clientConn)
-					done <- struct{}{}
-					break
-				}
-			}
-			msgCount++
-		} else {
-			if err != nil {
-				log.Printf("Sending request failed: %v. Will retry.\n", err)
-			} else {
-				log.Printf("Request sent: %s-%d\n", requestMsg, msgCount)
-				msgCount++
-			}
-		}
-
-	}
-
-	<-done
-	return stream.CloseSend() // 该方法将关闭服务器上的客户端链接，并返回io.EOF错误值
-}
-
 // 下面的一个结构体以及方法，是对客户端流的包装，将使用这些方法对原本流处理方法进行替换，来对客户端流的包装，实现每次流传输都可以进行自定义操作，而不是原本的等到全部传输完成才执行拦截器
 type wrappedClientStream struct {
 	grpc.ClientStream
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 文件服务发现使用的解析器scheme，例如 file:///etc/grpc/users.json
+const FileResolverScheme = "file"
+
+// 文件解析器检查后端列表文件是否变化的时间间隔
+const FileResolverPollInterval = time.Second * 2
+
+func init() {
+	resolver.Register(&staticResolverBuilder{})
+	resolver.Register(&fileResolverBuilder{})
+}
+
+// 后端列表文件的格式：
+//
+//	{
+//	  "addresses": [
+//	    {"addr": "localhost:50051", "weight": 2},
+//	    {"addr": "localhost:50052", "weight": 1}
+//	  ]
+//	}
+type backendList struct {
+	Addresses []backend `json:"addresses"`
+}
+
+type backend struct {
+	Addr   string `json:"addr"`
+	Weight uint32 `json:"weight"` // 权重，未设置时为1，least_request策略按权重分配请求
+}
+
+type backendAttrKey struct{}
+
+// 获取解析器为地址设置的权重，未设置时为1
+func backendFromAddress(addr resolver.Address) backend {
+	b, ok := addr.BalancerAttributes.Value(backendAttrKey{}).(backend)
+	if !ok || b.Weight == 0 {
+		b.Weight = 1
+	}
+	return b
+}
+
+func (l backendList) state() (resolver.State, error) {
+	var state resolver.State
+	for _, b := range l.Addresses {
+		if len(b.Addr) == 0 {
+			return state, fmt.Errorf("backend address must not be empty")
+		}
+		if b.Weight == 0 {
+			b.Weight = 1
+		}
+		state.Addresses = append(state.Addresses, resolver.Address{
+			Addr:               b.Addr,
+			BalancerAttributes: attributes.New(backendAttrKey{}, b),
+		})
+	}
+	return state, nil
+}
+
+// staticResolverBuilder 解析 static:///a:50051,b:50051 形式的目标，后端列表固定不变
+type staticResolverBuilder struct{}
+
+func (*staticResolverBuilder) Scheme() string {
+	return StaticResolverScheme
+}
+
+func (*staticResolverBuilder) Build(
+	target resolver.Target,
+	cc resolver.ClientConn,
+	opts resolver.BuildOptions,
+) (resolver.Resolver, error) {
+	var list backendList
+	for _, addr := range strings.Split(target.Endpoint(), ",") {
+		list.Addresses = append(list.Addresses, backend{Addr: strings.TrimSpace(addr)})
+	}
+	state, err := list.state()
+	if err != nil {
+		return nil, err
+	}
+	return nopResolver{}, cc.UpdateState(state)
+}
+
+type nopResolver struct{}
+
+func (nopResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (nopResolver) Close() {}
+
+// fileResolverBuilder 解析 file:///path/to/backends.json 形式的目标，
+// 定时检查文件是否变化，文件变化后将新的后端列表推送给ClientConn，无需重启客户端
+type fileResolverBuilder struct{}
+
+func (*fileResolverBuilder) Scheme() string {
+	return FileResolverScheme
+}
+
+func (*fileResolverBuilder) Build(
+	target resolver.Target,
+	cc resolver.ClientConn,
+	opts resolver.BuildOptions,
+) (resolver.Resolver, error) {
+	r := &fileResolver{
+		path: target.URL.Path,
+		cc:   cc,
+		done: make(chan struct{}),
+		now:  make(chan struct{}, 1),
+	}
+	err := r.resolve()
+	if err != nil {
+		return nil, err
+	}
+	go r.watch()
+
+	return r, nil
+}
+
+type fileResolver struct {
+	path    string
+	cc      resolver.ClientConn
+	modTime time.Time // 上次读取时文件的修改时间
+
+	done      chan struct{}
+	now       chan struct{} // ResolveNow触发立即重新读取
+	closeOnce sync.Once
+}
+
+func (r *fileResolver) resolve() error {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(r.modTime) {
+		return nil
+	}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	var list backendList
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %v", r.path, err)
+	}
+	state, err := list.state()
+	if err != nil {
+		return fmt.Errorf("parsing %s: %v", r.path, err)
+	}
+	r.modTime = fi.ModTime()
+	log.Printf("Resolved %d backends from %s\n", len(state.Addresses), r.path)
+
+	return r.cc.UpdateState(state)
+}
+
+func (r *fileResolver) watch() {
+	t := time.NewTicker(FileResolverPollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-t.C:
+		case <-r.now:
+		}
+		err := r.resolve()
+		if err != nil {
+			log.Printf("Resolving backends failed: %v", err)
+			r.cc.ReportError(err) // 保留上次成功解析的后端列表
+		}
+	}
+}
+
+func (r *fileResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.now <- struct{}{}:
+	default:
+	}
+}
+
+func (r *fileResolver) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"google.golang.org/grpc/resolver"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 测试用的ClientConn，记录解析器推送的后端列表和错误
+type fakeClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+	errs   chan error
+}
+
+func newFakeClientConn() *fakeClientConn {
+	return &fakeClientConn{states: make(chan resolver.State, 10), errs: make(chan error, 10)}
+}
+
+func (cc *fakeClientConn) UpdateState(s resolver.State) error {
+	cc.states <- s
+	return nil
+}
+
+func (cc *fakeClientConn) ReportError(err error) {
+	cc.errs <- err
+}
+
+// 等待解析器推送下一个后端列表，返回每个地址的权重
+func (cc *fakeClientConn) nextState(t *testing.T) map[string]uint32 {
+	t.Helper()
+	select {
+	case s := <-cc.states:
+		return addressWeights(s)
+	case err := <-cc.errs:
+		t.Fatalf("resolver reported error: %v", err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for resolver state")
+	}
+	return nil
+}
+
+func addressWeights(s resolver.State) map[string]uint32 {
+	weights := make(map[string]uint32)
+	for _, addr := range s.Addresses {
+		weights[addr.Addr] = backendFromAddress(addr).Weight
+	}
+	return weights
+}
+
+func assertWeights(t *testing.T, got, want map[string]uint32) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got backends %v, want %v", got, want)
+	}
+	for addr, w := range want {
+		if got[addr] != w {
+			t.Fatalf("got backends %v, want %v", got, want)
+		}
+	}
+}
+
+func parseTarget(t *testing.T, target string) resolver.Target {
+	t.Helper()
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resolver.Target{URL: *u}
+}
+
+func TestStaticResolver(t *testing.T) {
+	cc := newFakeClientConn()
+	r, err := (&staticResolverBuilder{}).Build(parseTarget(t, "static:///localhost:50051, localhost:50052"), cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// 静态列表中的后端权重都为1
+	assertWeights(t, cc.nextState(t), map[string]uint32{"localhost:50051": 1, "localhost:50052": 1})
+
+	_, err = (&staticResolverBuilder{}).Build(parseTarget(t, "static:///localhost:50051,,localhost:50052"), newFakeClientConn(), resolver.BuildOptions{})
+	if err == nil {
+		t.Fatal("empty address was accepted")
+	}
+}
+
+// 写入后端列表文件，并将修改时间设为mtime，保证与上一次写入的修改时间不同。
+// 先写入临时文件再重命名，解析器定时检查时不会读到修改时间还未设置的文件
+func writeBackends(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, []byte(content), 0o644)
+	if err == nil {
+		err = os.Chtimes(tmp, mtime, mtime)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileResolverReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.json")
+	mtime := time.Now().Add(-time.Hour)
+	writeBackends(t, path, `{"addresses": [{"addr": "localhost:50051", "weight": 2}, {"addr": "localhost:50052"}]}`, mtime)
+
+	cc := newFakeClientConn()
+	r, err := (&fileResolverBuilder{}).Build(parseTarget(t, "file://"+path), cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	assertWeights(t, cc.nextState(t), map[string]uint32{"localhost:50051": 2, "localhost:50052": 1})
+
+	// 文件未变化时不推送
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	select {
+	case s := <-cc.states:
+		t.Fatalf("unchanged file was resolved again: %v", addressWeights(s))
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	// 文件变化后推送新的后端列表
+	writeBackends(t, path, `{"addresses": [{"addr": "localhost:50053", "weight": 3}]}`, mtime.Add(time.Second))
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	assertWeights(t, cc.nextState(t), map[string]uint32{"localhost:50053": 3})
+
+	// 无效的文件报告错误，不推送后端列表，ClientConn保留上次的列表
+	writeBackends(t, path, `{"addresses": [{"weight": 1}]}`, mtime.Add(time.Second*2))
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	select {
+	case err := <-cc.errs:
+		t.Logf("reported error: %v", err)
+	case s := <-cc.states:
+		t.Fatalf("invalid file was resolved: %v", addressWeights(s))
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for resolver error")
+	}
+
+	// 修正文件后恢复
+	writeBackends(t, path, `{"addresses": [{"addr": "localhost:50051"}]}`, mtime.Add(time.Second*3))
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	assertWeights(t, cc.nextState(t), map[string]uint32{"localhost:50051": 1})
+}
+
+func TestFileResolverMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.json")
+	_, err := (&fileResolverBuilder{}).Build(parseTarget(t, "file://"+path), newFakeClientConn(), resolver.BuildOptions{})
+	if err == nil {
+		t.Fatal("missing file was accepted")
+	}
+}
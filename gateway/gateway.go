@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	svc "github.com/calmw/grpc-service"
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails" // 注册错误详情的类型，以便将错误编码为JSON
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+var marshaler = protojson.MarshalOptions{UseProtoNames: true}
+
+// gateway 将REST请求转换为对Users和Repo服务的gRPC调用
+//
+//	GET  /v1/users/{id}?email=   -> Users.GetUser
+//	GET  /v1/repos?creator_id=   -> Repo.GetRepos，以换行分隔的JSON流式返回
+//	POST /v1/repos?creator_id=&name=  请求体为仓库数据 -> Repo.CreateRepo
+//	GET  /openapi.json           OpenAPI文档
+type gateway struct {
+	users svc.UsersClient
+	repos svc.RepoClient
+}
+
+func newGateway(conn *grpc.ClientConn) *gateway {
+	return &gateway{
+		users: svc.NewUsersClient(conn),
+		repos: svc.NewRepoClient(conn),
+	}
+}
+
+func (g *gateway) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/users/", g.getUser)
+	mux.HandleFunc("/v1/repos", g.repoHandler)
+	mux.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIDocument)
+	})
+	return mux
+}
+
+func (g *gateway) getUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/v1/users/")
+	if len(id) == 0 || strings.Contains(id, "/") {
+		writeError(w, status.Error(codes.NotFound, "Not found"))
+		return
+	}
+	result, err := g.users.GetUser(outgoingContext(r), &svc.UserGetRequest{
+		Id:    id,
+		Email: r.URL.Query().Get("email"),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeMessage(w, http.StatusOK, result)
+}
+
+func (g *gateway) repoHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		g.getRepos(w, r)
+	case http.MethodPost:
+		g.createRepo(w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// 每收到一个RepoGetReply就写出一行JSON并立即flush，流中途出错时最后一行为错误对象
+func (g *gateway) getRepos(w http.ResponseWriter, r *http.Request) {
+	stream, err := g.repos.GetRepos(outgoingContext(r), &svc.RepoGetRequest{
+		CreatorId: r.URL.Query().Get("creator_id"),
+		Id:        r.URL.Query().Get("id"),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	started := false
+	for {
+		reply, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !started { // 尚未写出响应头，可以返回对应的HTTP状态码
+				writeError(w, err)
+				return
+			}
+			data, _ := marshaler.Marshal(status.Convert(err).Proto())
+			fmt.Fprintf(w, "{\"error\":%s}\n", data)
+			return
+		}
+		data, err := marshaler.Marshal(reply)
+		if err != nil {
+			log.Printf("Marshaling reply failed: %v", err)
+			return
+		}
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		w.Write(append(data, '\n'))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if !started {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// 请求体通过svc.CreateRepoFromReader按流协议分块发送，不会整体读入内存
+func (g *gateway) createRepo(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	stream, err := g.repos.CreateRepo(outgoingContext(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	repoContext := &svc.RepoContext{
+		CreatorId: query.Get("creator_id"),
+		Name:      query.Get("name"),
+	}
+	reply, err := svc.CreateRepoFromReader(stream, repoContext, r.Body, nil)
+	if _, ok := status.FromError(err); !ok { // 不是gRPC状态的错误来自读取请求体，返回后请求的context被取消，调用随之中止
+		writeError(w, status.Errorf(codes.InvalidArgument, "Reading request body failed: %v", err))
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeMessage(w, http.StatusCreated, reply)
+}
+
+// 将HTTP请求头X-Request-Id转发为gRPC元数据Request-Id
+func outgoingContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if requestId := r.Header.Get("X-Request-Id"); len(requestId) != 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, "Request-Id", requestId)
+	}
+	return ctx
+}
+
+func writeMessage(w http.ResponseWriter, code int, m proto.Message) {
+	data, err := marshaler.Marshal(m)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+// 错误以google.rpc.Status的JSON形式返回，HTTP状态码由gRPC状态码转换而来
+func writeError(w http.ResponseWriter, err error) {
+	s := status.Convert(err)
+	writeStatus(w, httpStatusFromCode(s.Code()), s)
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeStatus(w, http.StatusMethodNotAllowed, status.New(codes.Unimplemented, "Method not allowed"))
+}
+
+func writeStatus(w http.ResponseWriter, code int, s *status.Status) {
+	data, err := marshaler.Marshal(s.Proto())
+	if err != nil {
+		http.Error(w, s.Message(), code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+// gRPC状态码到HTTP状态码的映射，参考google.rpc.Code的定义
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // Client Closed Request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError // Unknown、Internal、DataLoss
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	listenAddr := os.Getenv("LISTEN_ADDR")
+	if len(listenAddr) == 0 {
+		listenAddr = "localhost:8080"
+	}
+	serverAddr := os.Getenv("GRPC_SERVER_ADDR")
+	if len(serverAddr) == 0 {
+		serverAddr = "localhost:50051"
+	}
+	// 获取TLS证书
+	tlsCertFile, ok := os.LookupEnv("TLS_CERT_FILE")
+	if !ok {
+		tlsCertFile = "./server.crt"
+	}
+
+	conn, err := setupGrpcConnection(serverAddr, tlsCertFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	g := newGateway(conn)
+	log.Printf("HTTP gateway listening on %s, forwarding to %s\n", listenAddr, serverAddr)
+	log.Fatal(http.ListenAndServe(listenAddr, g.routes()))
+}
+
+func setupGrpcConnection(addr, tlsCertFile string) (*grpc.ClientConn, error) {
+	log.Printf("Connecting to server on %s\n", addr)
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		10*time.Second,
+	)
+	defer cancel()
+
+	creds, err := credentials.NewClientTLSFromFile(tlsCertFile, "")
+	if err != nil {
+		return nil, err
+	}
+	return grpc.DialContext(
+		ctx,
+		addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithBlock(),
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithReturnConnectionError(),
+	)
+}
@@ -0,0 +1,20 @@
+module grpc-bench
+
+go 1.18
+
+require (
+	github.com/HdrHistogram/hdrhistogram-go v1.1.2
+	github.com/calmw/grpc-service v0.0.0
+	google.golang.org/grpc v1.53.0
+	google.golang.org/protobuf v1.29.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.2 // indirect
+	golang.org/x/net v0.5.0 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+	golang.org/x/text v0.6.0 // indirect
+	google.golang.org/genproto v0.0.0-20230110181048-76db0878b65f // indirect
+)
+
+replace github.com/calmw/grpc-service => ./../service
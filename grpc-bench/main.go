@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const usage = `Usage: grpc-bench [flags]
+
+Drives GetUser, GetRepos or GetHelp with the given concurrency and reports
+throughput, latency percentiles and errors by status code.
+
+Flags:
+`
+
+// 可重复指定的命令行参数，例如 -H "Request-Id: 123" -H "Authorization: Bearer xxx"
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(v string) error {
+	*h = append(*h, v)
+	return nil
+}
+
+type config struct {
+	addr        string
+	method      string
+	concurrency int
+	conns       int
+	qps         float64
+	duration    time.Duration
+	requests    int64
+	warmup      time.Duration
+	timeout     time.Duration
+	data        string
+	headers     headerFlags
+	format      string
+	histOut     string
+	histFormat  string
+
+	plaintext  bool
+	caCert     string
+	clientCert string
+	clientKey  string
+	serverName string
+}
+
+func main() {
+	log.SetFlags(0)
+	cfg, err := parseFlags(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = run(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseFlags(args []string) (*config, error) {
+	cfg := config{}
+	var dataFile string
+	fs := flag.NewFlagSet("grpc-bench", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.addr, "addr", "localhost:50051", "gRPC server address")
+	fs.StringVar(&cfg.method, "method", "GetUser", "method to call: GetUser, GetRepos or GetHelp")
+	fs.IntVar(&cfg.concurrency, "c", 10, "number of concurrent workers")
+	fs.IntVar(&cfg.conns, "conns", 1, "number of connections, workers are spread over them")
+	fs.Float64Var(&cfg.qps, "qps", 0, "total request rate over all workers, 0 means as fast as possible")
+	fs.DurationVar(&cfg.duration, "duration", 10*time.Second, "how long to run, including the warmup")
+	fs.Int64Var(&cfg.requests, "n", 0, "stop after this many requests, 0 means no limit")
+	fs.DurationVar(&cfg.warmup, "warmup", 0, "results in this initial period are discarded")
+	fs.DurationVar(&cfg.timeout, "timeout", 10*time.Second, "deadline of each GetUser or GetRepos call")
+	fs.StringVar(&cfg.data, "data", "", "request payload template in JSON, see README for the template fields")
+	fs.StringVar(&dataFile, "data-file", "", "read the request payload template from a file")
+	fs.Var(&cfg.headers, "H", `metadata header "key: value", may be repeated`)
+	fs.StringVar(&cfg.format, "format", "text", "report format: text or json")
+	fs.StringVar(&cfg.histOut, "hist-out", "", "write the HDR latency histogram to this file")
+	fs.StringVar(&cfg.histFormat, "hist-format", "json", "histogram format: json or csv")
+	fs.BoolVar(&cfg.plaintext, "plaintext", false, "connect without TLS")
+	fs.StringVar(&cfg.caCert, "tls-ca-cert", "", "CA certificate used to verify the server, defaults to the system roots")
+	fs.StringVar(&cfg.clientCert, "tls-client-cert", "", "client certificate for mTLS")
+	fs.StringVar(&cfg.clientKey, "tls-client-key", "", "client private key for mTLS")
+	fs.StringVar(&cfg.serverName, "tls-server-name", "", "override the server name used to verify the certificate")
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	if _, ok := workloads[cfg.method]; !ok {
+		return nil, fmt.Errorf("unsupported method: %s", cfg.method)
+	}
+	if cfg.concurrency < 1 || cfg.conns < 1 {
+		return nil, errors.New("-c and -conns must be at least 1")
+	}
+	if cfg.qps < 0 {
+		return nil, errors.New("-qps must not be negative")
+	}
+	if cfg.warmup >= cfg.duration {
+		return nil, errors.New("-warmup must be shorter than -duration")
+	}
+	if cfg.format != "text" && cfg.format != "json" {
+		return nil, fmt.Errorf("unsupported format: %s", cfg.format)
+	}
+	if cfg.histFormat != "json" && cfg.histFormat != "csv" {
+		return nil, fmt.Errorf("unsupported histogram format: %s", cfg.histFormat)
+	}
+	if len(dataFile) != 0 {
+		if len(cfg.data) != 0 {
+			return nil, errors.New("-data and -data-file can't be used together")
+		}
+		b, err := os.ReadFile(dataFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.data = string(b)
+	}
+	return &cfg, nil
+}
+
+func run(cfg *config) error {
+	w := workloads[cfg.method]
+	payload, err := newPayload(cfg.data, w)
+	if err != nil {
+		return err
+	}
+	conns, err := setupGrpcConnections(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	md, err := headerMetadata(cfg)
+	if err != nil {
+		return err
+	}
+
+	// 收到SIGINT或SIGTERM时提前结束，仍然输出已有的结果
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, cfg.duration)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, md)
+
+	var p *pacer
+	if cfg.qps > 0 {
+		p = newPacer(cfg.qps)
+	}
+	var issued int64
+	start := time.Now()
+	measureFrom := start.Add(cfg.warmup)
+	workers := make([]*stats, cfg.concurrency)
+	var wg sync.WaitGroup
+	for i := range workers {
+		workers[i] = newStats()
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			call := w.newCaller(conns[id%len(conns)], cfg)
+			defer call.close()
+			for seq := 0; ; seq++ {
+				var slot time.Time
+				if p != nil {
+					var ok bool
+					slot, ok = p.wait(ctx)
+					if !ok {
+						return
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				if cfg.requests > 0 && atomic.AddInt64(&issued, 1) > cfg.requests {
+					return
+				}
+				req, err := payload.render(id, seq)
+				if err != nil {
+					log.Printf("Rendering payload failed: %v", err)
+					cancel()
+					return
+				}
+				begin := time.Now()
+				if p != nil {
+					// 限速时从计划的时间片开始计算延迟，服务端变慢导致请求推迟发出的等待时间也计入延迟，避免协同遗漏
+					begin = slot
+				}
+				err = call.invoke(ctx, req)
+				end := time.Now()
+				// 测试结束时被中断的调用不计入结果
+				if ctx.Err() != nil {
+					return
+				}
+				if begin.Before(measureFrom) {
+					continue
+				}
+				workers[id].record(end.Sub(begin), err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(measureFrom)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	total := newStats()
+	for _, s := range workers {
+		total.merge(s)
+	}
+	r := newReport(cfg, total, elapsed)
+	err = r.print(os.Stdout, cfg.format)
+	if err != nil {
+		return err
+	}
+	if len(cfg.histOut) != 0 {
+		return writeHistogram(cfg.histOut, cfg.histFormat, total.latency)
+	}
+	return nil
+}
+
+// 建立-conns个连接，工作协程轮流使用这些连接
+func setupGrpcConnections(cfg *config) ([]*grpc.ClientConn, error) {
+	creds, err := transportCredentials(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var conns []*grpc.ClientConn
+	for i := 0; i < cfg.conns; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		conn, err := grpc.DialContext(
+			ctx,
+			cfg.addr,
+			grpc.WithTransportCredentials(creds),
+			grpc.WithBlock(),
+			grpc.FailOnNonTempDialError(true),
+			grpc.WithReturnConnectionError(),
+		)
+		cancel()
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, fmt.Errorf("connecting to %s failed: %w", cfg.addr, err)
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+func transportCredentials(cfg *config) (credentials.TransportCredentials, error) {
+	if cfg.plaintext {
+		return insecure.NewCredentials(), nil
+	}
+	tlsConfig := &tls.Config{ServerName: cfg.serverName}
+	if len(cfg.caCert) != 0 {
+		pem, err := os.ReadFile(cfg.caCert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(cfg.clientCert) != 0 || len(cfg.clientKey) != 0 {
+		cert, err := tls.LoadX509KeyPair(cfg.clientCert, cfg.clientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// 将-H指定的请求头转换为元数据
+func headerMetadata(cfg *config) (metadata.MD, error) {
+	md := metadata.MD{}
+	for _, h := range cfg.headers {
+		i := strings.Index(h, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid header %q, want \"key: value\"", h)
+		}
+		md.Append(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+	return md, nil
+}
+
+// pacer 将所有工作协程的请求速率限制在qps，时间片按固定间隔排定，处理不过来时不丢弃错过的时间片，
+// 之后的请求立即发出直到追上计划
+type pacer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newPacer(qps float64) *pacer {
+	return &pacer{interval: time.Duration(float64(time.Second) / qps), next: time.Now()}
+}
+
+// 等待下一个时间片，返回该时间片的计划时间，ctx结束时返回false
+func (p *pacer) wait(ctx context.Context) (time.Time, bool) {
+	p.mu.Lock()
+	slot := p.next
+	p.next = slot.Add(p.interval)
+	p.mu.Unlock()
+
+	d := time.Until(slot)
+	if d <= 0 {
+		return slot, ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return slot, false
+	case <-t.C:
+		return slot, true
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 直方图记录的延迟单位为微秒，范围1微秒到60秒，3位有效数字
+const (
+	HistogramMin     = 1
+	HistogramMax     = int64(time.Minute / time.Microsecond)
+	HistogramSigFigs = 3
+)
+
+// stats 记录成功调用的延迟和每个状态码的调用次数，每个工作协程一个，结束后合并
+type stats struct {
+	latency *hdrhistogram.Histogram
+	codes   map[codes.Code]int64
+}
+
+func newStats() *stats {
+	return &stats{
+		latency: hdrhistogram.New(HistogramMin, HistogramMax, HistogramSigFigs),
+		codes:   make(map[codes.Code]int64),
+	}
+}
+
+func (s *stats) record(d time.Duration, err error) {
+	code := status.Code(err)
+	s.codes[code]++
+	if code != codes.OK {
+		return
+	}
+	us := d.Microseconds()
+	if us < HistogramMin {
+		us = HistogramMin
+	}
+	if us > HistogramMax {
+		us = HistogramMax
+	}
+	s.latency.RecordValue(us)
+}
+
+func (s *stats) merge(other *stats) {
+	s.latency.Merge(other.latency)
+	for code, n := range other.codes {
+		s.codes[code] += n
+	}
+}
+
+// report 压测结果，延迟单位为毫秒
+type report struct {
+	Method      string           `json:"method"`
+	Concurrency int              `json:"concurrency"`
+	Connections int              `json:"connections"`
+	TargetQPS   float64          `json:"target_qps,omitempty"`
+	Duration    float64          `json:"duration_seconds"`
+	Requests    int64            `json:"requests"`
+	Errors      int64            `json:"errors"`
+	Throughput  float64          `json:"throughput_rps"`
+	Latency     latencySummary   `json:"latency_ms"`
+	StatusCodes map[string]int64 `json:"status_codes"`
+}
+
+type latencySummary struct {
+	Min    float64 `json:"min"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P99    float64 `json:"p99"`
+	P999   float64 `json:"p999"`
+	Max    float64 `json:"max"`
+}
+
+func newReport(cfg *config, s *stats, elapsed time.Duration) *report {
+	r := &report{
+		Method:      cfg.method,
+		Concurrency: cfg.concurrency,
+		Connections: cfg.conns,
+		TargetQPS:   cfg.qps,
+		Duration:    elapsed.Seconds(),
+		StatusCodes: make(map[string]int64),
+	}
+	for code, n := range s.codes {
+		r.Requests += n
+		if code != codes.OK {
+			r.Errors += n
+		}
+		r.StatusCodes[code.String()] = n
+	}
+	if elapsed > 0 {
+		r.Throughput = float64(r.Requests) / elapsed.Seconds()
+	}
+	h := s.latency
+	if h.TotalCount() != 0 {
+		r.Latency = latencySummary{
+			Min:    ms(float64(h.Min())),
+			Mean:   ms(h.Mean()),
+			StdDev: ms(h.StdDev()),
+			P50:    ms(float64(h.ValueAtQuantile(50))),
+			P90:    ms(float64(h.ValueAtQuantile(90))),
+			P99:    ms(float64(h.ValueAtQuantile(99))),
+			P999:   ms(float64(h.ValueAtQuantile(99.9))),
+			Max:    ms(float64(h.Max())),
+		}
+	}
+	return r
+}
+
+// 微秒转换为毫秒
+func ms(us float64) float64 {
+	return us / 1000
+}
+
+func (r *report) print(w io.Writer, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	fmt.Fprintf(w, "Method:      %s\n", r.Method)
+	fmt.Fprintf(w, "Workers:     %d on %d connection(s)\n", r.Concurrency, r.Connections)
+	if r.TargetQPS > 0 {
+		fmt.Fprintf(w, "Target QPS:  %.1f\n", r.TargetQPS)
+	}
+	fmt.Fprintf(w, "Duration:    %.2fs\n", r.Duration)
+	fmt.Fprintf(w, "Requests:    %d (%d errors)\n", r.Requests, r.Errors)
+	fmt.Fprintf(w, "Throughput:  %.1f req/s\n", r.Throughput)
+	fmt.Fprintln(w, "\nLatency (ms, successful calls):")
+	l := r.Latency
+	fmt.Fprintf(w, "  min %.3f  mean %.3f  stddev %.3f  max %.3f\n", l.Min, l.Mean, l.StdDev, l.Max)
+	fmt.Fprintf(w, "  p50 %.3f  p90 %.3f  p99 %.3f  p999 %.3f\n", l.P50, l.P90, l.P99, l.P999)
+	fmt.Fprintln(w, "\nStatus codes:")
+	names := make([]string, 0, len(r.StatusCodes))
+	for name := range r.StatusCodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-20s %d\n", name, r.StatusCodes[name])
+	}
+	return nil
+}
+
+// 导出的直方图，percentiles为累计分布，snapshot可以用hdrhistogram.Import还原
+type histogramExport struct {
+	Unit        string                 `json:"unit"`
+	TotalCount  int64                  `json:"total_count"`
+	Percentiles []percentile           `json:"percentiles"`
+	Snapshot    *hdrhistogram.Snapshot `json:"snapshot"`
+}
+
+type percentile struct {
+	Percentile float64 `json:"percentile"`
+	Value      int64   `json:"value"`
+	Count      int64   `json:"count"` // 小于等于value的调用次数
+}
+
+// 将延迟直方图的累计分布写入文件，json格式还包含完整的计数，csv格式每行一个百分位
+func writeHistogram(path, format string, h *hdrhistogram.Histogram) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var percentiles []percentile
+	if h.TotalCount() != 0 {
+		for _, b := range h.CumulativeDistributionWithTicks(5) {
+			percentiles = append(percentiles, percentile{Percentile: b.Quantile, Value: b.ValueAt, Count: b.Count})
+		}
+	}
+	if format == "csv" {
+		w := csv.NewWriter(f)
+		w.Write([]string{"percentile", "value_us", "count"})
+		for _, p := range percentiles {
+			w.Write([]string{
+				strconv.FormatFloat(p.Percentile, 'f', -1, 64),
+				strconv.FormatInt(p.Value, 10),
+				strconv.FormatInt(p.Count, 10),
+			})
+		}
+		w.Flush()
+		err = w.Error()
+	} else {
+		err = json.NewEncoder(f).Encode(histogramExport{
+			Unit:        "us",
+			TotalCount:  h.TotalCount(),
+			Percentiles: percentiles,
+			Snapshot:    h.Export(),
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"math/rand"
+	"strings"
+	"text/template"
+)
+
+// workload 描述一个被压测的方法：请求消息类型、默认的请求模板和发起一次调用的方式
+type workload struct {
+	newRequest  func() proto.Message
+	defaultData string
+	newCaller   func(conn *grpc.ClientConn, cfg *config) caller
+}
+
+// caller 每个工作协程一个，invoke发起一次调用并等待结果
+type caller interface {
+	invoke(ctx context.Context, req proto.Message) error
+	close()
+}
+
+var workloads = map[string]workload{
+	"GetUser": {
+		newRequest:  func() proto.Message { return &svc.UserGetRequest{} },
+		defaultData: `{"email":"user{{.Seq}}@doe.com","id":"{{.Worker}}-{{.Seq}}"}`,
+		newCaller: func(conn *grpc.ClientConn, cfg *config) caller {
+			return &userCaller{client: svc.NewUsersClient(conn), cfg: cfg}
+		},
+	},
+	"GetRepos": {
+		newRequest:  func() proto.Message { return &svc.RepoGetRequest{} },
+		defaultData: `{"creator_id":"user-{{.Worker}}","id":"repo-{{.Seq}}"}`,
+		newCaller: func(conn *grpc.ClientConn, cfg *config) caller {
+			return &reposCaller{client: svc.NewRepoClient(conn), cfg: cfg}
+		},
+	},
+	"GetHelp": {
+		newRequest:  func() proto.Message { return &svc.UserHelpRequest{} },
+		defaultData: `{"request":"hello-{{.Worker}}-{{.Seq}}"}`,
+		newCaller: func(conn *grpc.ClientConn, cfg *config) caller {
+			return &helpCaller{client: svc.NewUsersClient(conn)}
+		},
+	},
+}
+
+// 一次GetUser调用
+type userCaller struct {
+	client svc.UsersClient
+	cfg    *config
+}
+
+func (c *userCaller) invoke(ctx context.Context, req proto.Message) error {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+	_, err := c.client.GetUser(ctx, req.(*svc.UserGetRequest))
+	return err
+}
+
+func (c *userCaller) close() {}
+
+// 一次GetRepos调用，接收完所有的仓库才算完成
+type reposCaller struct {
+	client svc.RepoClient
+	cfg    *config
+}
+
+func (c *reposCaller) invoke(ctx context.Context, req proto.Message) error {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+	stream, err := c.client.GetRepos(ctx, req.(*svc.RepoGetRequest))
+	if err != nil {
+		return err
+	}
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *reposCaller) close() {}
+
+// GetHelp的一次调用是在工作协程的长期流上发送一条消息并收到回复，流出错后下一次调用时重建
+type helpCaller struct {
+	client svc.UsersClient
+	stream svc.Users_GetHelpClient
+	cancel context.CancelFunc
+}
+
+func (c *helpCaller) invoke(ctx context.Context, req proto.Message) error {
+	if c.stream == nil {
+		streamCtx, cancel := context.WithCancel(ctx)
+		stream, err := c.client.GetHelp(streamCtx)
+		if err != nil {
+			cancel()
+			return err
+		}
+		c.stream, c.cancel = stream, cancel
+	}
+	err := c.stream.Send(req.(*svc.UserHelpRequest))
+	// 流已被服务端结束时Send返回io.EOF，真正的错误需要通过Recv获取
+	if err == nil || err == io.EOF {
+		_, err = c.stream.Recv()
+	}
+	if err != nil {
+		c.close()
+	}
+	return err
+}
+
+func (c *helpCaller) close() {
+	if c.stream == nil {
+		return
+	}
+	c.stream.CloseSend()
+	c.cancel()
+	c.stream = nil
+}
+
+// payload 根据模板生成请求消息，模板中没有变量时只解析一次
+type payload struct {
+	tmpl       *template.Template
+	static     proto.Message
+	newRequest func() proto.Message
+}
+
+// 模板中可以使用的字段
+type templateData struct {
+	Worker int // 工作协程编号，从0开始
+	Seq    int // 该工作协程发出的第几个请求，从0开始
+}
+
+var templateFuncs = template.FuncMap{
+	"randInt": rand.Intn,
+	"randString": func(n int) string {
+		const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = letters[rand.Intn(len(letters))]
+		}
+		return string(b)
+	},
+}
+
+func newPayload(data string, w workload) (*payload, error) {
+	if len(data) == 0 {
+		data = w.defaultData
+	}
+	p := &payload{newRequest: w.newRequest}
+	if !strings.Contains(data, "{{") {
+		req := w.newRequest()
+		err := protojson.Unmarshal([]byte(data), req)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload: %w", err)
+		}
+		p.static = req
+		return p, nil
+	}
+	tmpl, err := template.New("payload").Funcs(templateFuncs).Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload template: %w", err)
+	}
+	p.tmpl = tmpl
+	// 提前渲染一次，尽早发现模板错误
+	_, err = p.render(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *payload) render(worker, seq int) (proto.Message, error) {
+	if p.static != nil {
+		return p.static, nil
+	}
+	var buf bytes.Buffer
+	err := p.tmpl.Execute(&buf, templateData{Worker: worker, Seq: seq})
+	if err != nil {
+		return nil, err
+	}
+	req := p.newRequest()
+	err = protojson.Unmarshal(buf.Bytes(), req)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload %s: %w", buf.String(), err)
+	}
+	return req, nil
+}
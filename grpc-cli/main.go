@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: grpc-cli [flags] <command> [args]
+
+Commands:
+  list                        list services
+  list <service>              list the methods of a service
+  describe <symbol>           describe a service, method or message
+  call <service/method> [json]
+                              invoke a method; the request is read from the
+                              argument or from stdin, streaming methods read a
+                              sequence of JSON objects from stdin
+
+Flags:
+`
+
+// 可重复指定的命令行参数，例如 -H "Request-Id: 123" -H "Authorization: Bearer xxx"
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(v string) error {
+	*h = append(*h, v)
+	return nil
+}
+
+type config struct {
+	addr       string
+	headers    headerFlags
+	timeout    time.Duration
+	plaintext  bool
+	caCert     string
+	clientCert string
+	clientKey  string
+	serverName string
+	verbose    bool
+}
+
+func main() {
+	log.SetFlags(0)
+	cfg := config{}
+	fs := flag.NewFlagSet("grpc-cli", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.addr, "addr", "localhost:50051", "gRPC server address")
+	fs.Var(&cfg.headers, "H", `metadata header "key: value", may be repeated`)
+	fs.DurationVar(&cfg.timeout, "timeout", 0, "deadline of the call, 0 means no deadline")
+	fs.BoolVar(&cfg.plaintext, "plaintext", false, "connect without TLS")
+	fs.StringVar(&cfg.caCert, "tls-ca-cert", "", "CA certificate used to verify the server, defaults to the system roots")
+	fs.StringVar(&cfg.clientCert, "tls-client-cert", "", "client certificate for mTLS")
+	fs.StringVar(&cfg.clientKey, "tls-client-key", "", "client private key for mTLS")
+	fs.StringVar(&cfg.serverName, "tls-server-name", "", "override the server name used to verify the certificate")
+	fs.BoolVar(&cfg.verbose, "v", false, "print response headers and trailers")
+	fs.Parse(os.Args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	err := run(&cfg, fs.Args())
+	if err != nil {
+		if s, ok := status.FromError(err); ok {
+			log.Fatalf("ERROR:\n  Code: %s\n  Message: %s", s.Code(), s.Message())
+		}
+		log.Fatal(err)
+	}
+}
+
+func run(cfg *config, args []string) error {
+	conn, err := setupGrpcConnection(cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	rc, err := newReflectionClient(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer rc.close()
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		return listServices(rc)
+	case args[0] == "list" && len(args) == 2:
+		return listMethods(rc, args[1])
+	case args[0] == "describe" && len(args) == 2:
+		return describe(rc, args[1])
+	case args[0] == "call" && (len(args) == 2 || len(args) == 3):
+		var input io.Reader = os.Stdin
+		if len(args) == 3 {
+			input = strings.NewReader(args[2])
+		}
+		return call(ctx, cfg, conn, rc, args[1], input)
+	}
+	return fmt.Errorf("invalid command: %s", strings.Join(args, " "))
+}
+
+func setupGrpcConnection(cfg *config) (*grpc.ClientConn, error) {
+	creds, err := transportCredentials(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return grpc.DialContext(
+		ctx,
+		cfg.addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithBlock(),
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithReturnConnectionError(),
+	)
+}
+
+func transportCredentials(cfg *config) (credentials.TransportCredentials, error) {
+	if cfg.plaintext {
+		return insecure.NewCredentials(), nil
+	}
+	tlsConfig := &tls.Config{ServerName: cfg.serverName}
+	if len(cfg.caCert) != 0 {
+		pem, err := os.ReadFile(cfg.caCert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(cfg.clientCert) != 0 || len(cfg.clientKey) != 0 {
+		cert, err := tls.LoadX509KeyPair(cfg.clientCert, cfg.clientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+func listServices(rc *reflectionClient) error {
+	services, err := rc.listServices()
+	if err != nil {
+		return err
+	}
+	for _, s := range services {
+		fmt.Println(s)
+	}
+	return nil
+}
+
+func listMethods(rc *reflectionClient, service string) error {
+	sd, err := resolveService(rc, service)
+	if err != nil {
+		return err
+	}
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		fmt.Printf("%s.%s\n", sd.FullName(), methods.Get(i).Name())
+	}
+	return nil
+}
+
+func resolveService(rc *reflectionClient, service string) (protoreflect.ServiceDescriptor, error) {
+	d, err := rc.resolve(service)
+	if err != nil {
+		return nil, err
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	return sd, nil
+}
+
+// 方法名可以是 Users/GetUser、Users.GetUser 或 /Users/GetUser
+func resolveMethod(rc *reflectionClient, name string) (protoreflect.MethodDescriptor, error) {
+	name = strings.TrimPrefix(name, "/")
+	i := strings.LastIndexAny(name, "/.")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid method name %s, want service/method", name)
+	}
+	sd, err := resolveService(rc, name[:i])
+	if err != nil {
+		return nil, err
+	}
+	md := sd.Methods().ByName(protoreflect.Name(name[i+1:]))
+	if md == nil {
+		return nil, fmt.Errorf("service %s has no method %s", sd.FullName(), name[i+1:])
+	}
+	return md, nil
+}
+
+func describe(rc *reflectionClient, symbol string) error {
+	d, err := rc.resolve(symbol)
+	if err != nil {
+		return err
+	}
+	switch d := d.(type) {
+	case protoreflect.ServiceDescriptor:
+		fmt.Printf("service %s {\n", d.FullName())
+		for i := 0; i < d.Methods().Len(); i++ {
+			fmt.Printf("  %s\n", methodSignature(d.Methods().Get(i)))
+		}
+		fmt.Println("}")
+	case protoreflect.MethodDescriptor:
+		fmt.Println(methodSignature(d))
+	case protoreflect.MessageDescriptor:
+		printMessage(d, "")
+	case protoreflect.EnumDescriptor:
+		printEnum(d, "")
+	default:
+		return fmt.Errorf("don't know how to describe %s", d.FullName())
+	}
+	return nil
+}
+
+func methodSignature(md protoreflect.MethodDescriptor) string {
+	in, out := string(md.Input().FullName()), string(md.Output().FullName())
+	if md.IsStreamingClient() {
+		in = "stream " + in
+	}
+	if md.IsStreamingServer() {
+		out = "stream " + out
+	}
+	return fmt.Sprintf("rpc %s(%s) returns (%s);", md.Name(), in, out)
+}
+
+func printMessage(md protoreflect.MessageDescriptor, indent string) {
+	fmt.Printf("%smessage %s {\n", indent, md.Name())
+	for i := 0; i < md.Enums().Len(); i++ {
+		printEnum(md.Enums().Get(i), indent+"  ")
+	}
+	for i := 0; i < md.Messages().Len(); i++ {
+		if !md.Messages().Get(i).IsMapEntry() {
+			printMessage(md.Messages().Get(i), indent+"  ")
+		}
+	}
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if oneof := fd.ContainingOneof(); oneof != nil && !oneof.IsSynthetic() {
+			if oneof.Fields().Get(0) != fd {
+				continue
+			}
+			fmt.Printf("%s  oneof %s {\n", indent, oneof.Name())
+			for j := 0; j < oneof.Fields().Len(); j++ {
+				of := oneof.Fields().Get(j)
+				fmt.Printf("%s    %s %s = %d;\n", indent, fieldType(of), of.Name(), of.Number())
+			}
+			fmt.Printf("%s  }\n", indent)
+			continue
+		}
+		label := ""
+		if fd.IsList() {
+			label = "repeated "
+		} else if fd.HasOptionalKeyword() {
+			label = "optional "
+		}
+		fmt.Printf("%s  %s%s %s = %d;\n", indent, label, fieldType(fd), fd.Name(), fd.Number())
+	}
+	fmt.Printf("%s}\n", indent)
+}
+
+func printEnum(ed protoreflect.EnumDescriptor, indent string) {
+	fmt.Printf("%senum %s {\n", indent, ed.Name())
+	for i := 0; i < ed.Values().Len(); i++ {
+		v := ed.Values().Get(i)
+		fmt.Printf("%s  %s = %d;\n", indent, v.Name(), v.Number())
+	}
+	fmt.Printf("%s}\n", indent)
+}
+
+func fieldType(fd protoreflect.FieldDescriptor) string {
+	switch {
+	case fd.IsMap():
+		return fmt.Sprintf("map<%s, %s>", fieldType(fd.MapKey()), fieldType(fd.MapValue()))
+	case fd.Message() != nil:
+		return string(fd.Message().FullName())
+	case fd.Enum() != nil:
+		return string(fd.Enum().FullName())
+	}
+	return fd.Kind().String()
+}
+
+// 调用任意方法：请求消息为JSON，响应消息以JSON输出，每行一个
+func call(
+	ctx context.Context,
+	cfg *config,
+	conn *grpc.ClientConn,
+	rc *reflectionClient,
+	name string,
+	input io.Reader,
+) error {
+	md, err := resolveMethod(rc, name)
+	if err != nil {
+		return err
+	}
+	ctx, err = outgoingContext(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.timeout)
+		defer cancel()
+	}
+
+	method := fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+	requests := newRequestReader(input, md.Input())
+	var header, trailer metadata.MD
+	defer func() {
+		if cfg.verbose {
+			printMetadata("Response headers", header)
+			printMetadata("Response trailers", trailer)
+		}
+	}()
+
+	if !md.IsStreamingClient() && !md.IsStreamingServer() {
+		req, err := requests.next()
+		if err == io.EOF {
+			req = dynamicpb.NewMessage(md.Input()) // 没有输入时发送空消息
+		} else if err != nil {
+			return err
+		}
+		resp := dynamicpb.NewMessage(md.Output())
+		err = conn.Invoke(ctx, method, req, resp, grpc.Header(&header), grpc.Trailer(&trailer))
+		if err != nil {
+			return err
+		}
+		return printMessageJSON(resp)
+	}
+
+	stream, err := conn.NewStream(
+		ctx,
+		&grpc.StreamDesc{ClientStreams: md.IsStreamingClient(), ServerStreams: md.IsStreamingServer()},
+		method,
+		grpc.Header(&header),
+		grpc.Trailer(&trailer),
+	)
+	if err != nil {
+		return err
+	}
+
+	// 发送请求和接收响应同时进行，双向流时每读到一行输入就发送一条消息
+	sendErr := make(chan error, 1)
+	go func() {
+		sendErr <- sendRequests(stream, requests, md)
+	}()
+	for {
+		resp := dynamicpb.NewMessage(md.Output())
+		err := stream.RecvMsg(resp)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		err = printMessageJSON(resp)
+		if err != nil {
+			return err
+		}
+	}
+	select {
+	case err := <-sendErr:
+		return err
+	default: // 服务端提前结束了流，不再等待输入
+		return nil
+	}
+}
+
+func sendRequests(stream grpc.ClientStream, requests *requestReader, md protoreflect.MethodDescriptor) error {
+	sent := 0
+	for {
+		req, err := requests.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			stream.CloseSend()
+			return err
+		}
+		err = stream.SendMsg(req)
+		if err == io.EOF { // 流已结束，错误由RecvMsg返回
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		sent++
+		if !md.IsStreamingClient() {
+			break
+		}
+	}
+	if sent == 0 && !md.IsStreamingClient() {
+		err := stream.SendMsg(dynamicpb.NewMessage(md.Input()))
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return stream.CloseSend()
+}
+
+// 将-H指定的请求头添加到元数据中
+func outgoingContext(ctx context.Context, cfg *config) (context.Context, error) {
+	for _, h := range cfg.headers {
+		i := strings.Index(h, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid header %q, want \"key: value\"", h)
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+	return ctx, nil
+}
+
+// requestReader 从输入中依次读取JSON对象，转换为请求消息
+type requestReader struct {
+	dec *json.Decoder
+	md  protoreflect.MessageDescriptor
+}
+
+func newRequestReader(r io.Reader, md protoreflect.MessageDescriptor) *requestReader {
+	return &requestReader{dec: json.NewDecoder(r), md: md}
+}
+
+func (r *requestReader) next() (proto.Message, error) {
+	var raw json.RawMessage
+	err := r.dec.Decode(&raw)
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("invalid JSON input: %v", err)
+	}
+	m := dynamicpb.NewMessage(r.md)
+	err = protojson.Unmarshal(raw, m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", r.md.FullName(), err)
+	}
+	return m, nil
+}
+
+func printMessageJSON(m proto.Message) error {
+	data, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func printMetadata(title string, md metadata.MD) {
+	fmt.Fprintf(os.Stderr, "%s:\n", title)
+	for k, vv := range md {
+		for _, v := range vv {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", k, v)
+		}
+	}
+}
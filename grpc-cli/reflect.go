@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"sort"
+)
+
+// reflectionClient 通过服务端反射服务获取服务列表和proto文件描述，
+// 解析出的描述用于构造动态消息，因此不需要事先编译服务的proto文件
+type reflectionClient struct {
+	stream rpb.ServerReflection_ServerReflectionInfoClient
+	files  map[string]*descriptorpb.FileDescriptorProto // 已获取的文件描述，按文件名索引
+}
+
+func newReflectionClient(ctx context.Context, conn *grpc.ClientConn) (*reflectionClient, error) {
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &reflectionClient{
+		stream: stream,
+		files:  make(map[string]*descriptorpb.FileDescriptorProto),
+	}, nil
+}
+
+func (c *reflectionClient) close() {
+	c.stream.CloseSend()
+}
+
+func (c *reflectionClient) request(req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+	err := c.stream.Send(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if e := resp.GetErrorResponse(); e != nil {
+		return nil, fmt.Errorf("reflection error %d: %s", e.ErrorCode, e.ErrorMessage)
+	}
+	return resp, nil
+}
+
+// 列出服务端注册的所有服务
+func (c *reflectionClient) listServices() ([]string, error) {
+	resp, err := c.request(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		names = append(names, s.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// 查找服务、方法或消息的描述
+func (c *reflectionClient) resolve(symbol string) (protoreflect.Descriptor, error) {
+	resp, err := c.request(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = c.addFiles(resp)
+	if err != nil {
+		return nil, err
+	}
+	files, err := c.registry()
+	if err != nil {
+		return nil, err
+	}
+	return files.FindDescriptorByName(protoreflect.FullName(symbol))
+}
+
+func (c *reflectionClient) addFiles(resp *rpb.ServerReflectionResponse) error {
+	for _, b := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		fd := &descriptorpb.FileDescriptorProto{}
+		err := proto.Unmarshal(b, fd)
+		if err != nil {
+			return err
+		}
+		c.files[fd.GetName()] = fd
+	}
+	// 服务端可能不会返回已经发送过的依赖文件，按文件名补齐
+	for _, fd := range c.files {
+		for _, dep := range fd.Dependency {
+			if _, ok := c.files[dep]; ok {
+				continue
+			}
+			resp, err := c.request(&rpb.ServerReflectionRequest{
+				MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+			})
+			if err != nil {
+				return err
+			}
+			return c.addFiles(resp)
+		}
+	}
+	return nil
+}
+
+func (c *reflectionClient) registry() (*protoregistry.Files, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range c.files {
+		set.File = append(set.File, fd)
+	}
+	return protodesc.NewFiles(set)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthz "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 退出码，与Kubernetes的gRPC探针（grpc-health-probe）保持一致
+const (
+	ExitOK            = 0 // 所有服务都是SERVING
+	ExitInvalidArgs   = 1 // 命令行参数错误
+	ExitConnectFailed = 2 // 连接服务器失败
+	ExitRPCFailed     = 3 // 健康检查RPC调用失败
+	ExitUnhealthy     = 4 // 至少一个服务不是SERVING
+)
+
+// Watch流出错后重新建立的间隔
+const WatchRetryInterval = time.Second
+
+type config struct {
+	addr           string
+	services       []string
+	connectTimeout time.Duration
+	rpcTimeout     time.Duration
+	watch          bool
+	format         string
+
+	tls                bool
+	caCert             string
+	clientCert         string
+	clientKey          string
+	serverName         string
+	insecureSkipVerify bool
+}
+
+// 一次健康检查的结果
+type result struct {
+	Service string    `json:"service"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+func main() {
+	log.SetFlags(0)
+	cfg, err := parseFlags(os.Args[1:])
+	if err != nil {
+		log.Println(err)
+		os.Exit(ExitInvalidArgs)
+	}
+	os.Exit(run(cfg))
+}
+
+func parseFlags(args []string) (*config, error) {
+	cfg := config{}
+	var services string
+	fs := flag.NewFlagSet("grpc-health", flag.ContinueOnError)
+	fs.StringVar(&cfg.addr, "addr", "localhost:50051", "gRPC server address")
+	fs.StringVar(&services, "service", "", `comma separated service names to check, "" checks the overall server health`)
+	fs.DurationVar(&cfg.connectTimeout, "connect-timeout", time.Second*5, "timeout for establishing the connection")
+	fs.DurationVar(&cfg.rpcTimeout, "rpc-timeout", time.Second, "timeout for each Check call")
+	fs.BoolVar(&cfg.watch, "watch", false, "watch status changes until interrupted, re-establishing the stream on failure")
+	fs.StringVar(&cfg.format, "format", "text", "output format: text or json")
+	fs.BoolVar(&cfg.tls, "tls", false, "use TLS")
+	fs.StringVar(&cfg.caCert, "tls-ca-cert", "", "CA certificate used to verify the server (implies -tls)")
+	fs.StringVar(&cfg.clientCert, "tls-client-cert", "", "client certificate for mTLS (implies -tls)")
+	fs.StringVar(&cfg.clientKey, "tls-client-key", "", "client private key for mTLS")
+	fs.StringVar(&cfg.serverName, "tls-server-name", "", "override the server name used to verify the certificate")
+	fs.BoolVar(&cfg.insecureSkipVerify, "tls-no-verify", false, "don't verify the server certificate (implies -tls)")
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	cfg.services = strings.Split(services, ",")
+	for i := range cfg.services {
+		cfg.services[i] = strings.TrimSpace(cfg.services[i])
+	}
+	if cfg.format != "text" && cfg.format != "json" {
+		return nil, fmt.Errorf("unsupported format: %s", cfg.format)
+	}
+	if (len(cfg.clientCert) == 0) != (len(cfg.clientKey) == 0) {
+		return nil, errors.New("-tls-client-cert and -tls-client-key must be specified together")
+	}
+	if len(cfg.caCert) != 0 || len(cfg.clientCert) != 0 || cfg.insecureSkipVerify {
+		cfg.tls = true
+	}
+	return &cfg, nil
+}
+
+func run(cfg *config) int {
+	creds, err := transportCredentials(cfg)
+	if err != nil {
+		log.Println(err)
+		return ExitInvalidArgs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.connectTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(
+		ctx,
+		cfg.addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithBlock(),
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithReturnConnectionError(),
+	)
+	if err != nil {
+		log.Printf("Connecting to %s failed: %v", cfg.addr, err)
+		return ExitConnectFailed
+	}
+	defer conn.Close()
+	client := healthz.NewHealthClient(conn)
+	out := &printer{w: os.Stdout, json: cfg.format == "json"}
+
+	if cfg.watch {
+		return watch(client, cfg, out)
+	}
+	return check(client, cfg, out)
+}
+
+func transportCredentials(cfg *config) (credentials.TransportCredentials, error) {
+	if !cfg.tls {
+		return insecure.NewCredentials(), nil
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.serverName,
+		InsecureSkipVerify: cfg.insecureSkipVerify,
+	}
+	if len(cfg.caCert) != 0 {
+		pem, err := os.ReadFile(cfg.caCert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(cfg.clientCert) != 0 {
+		cert, err := tls.LoadX509KeyPair(cfg.clientCert, cfg.clientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// 依次检查每个服务，全部SERVING时返回ExitOK
+func check(client healthz.HealthClient, cfg *config, out *printer) int {
+	code := ExitOK
+	for _, service := range cfg.services {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.rpcTimeout)
+		resp, err := client.Check(ctx, &healthz.HealthCheckRequest{Service: service})
+		cancel()
+
+		r := result{Service: service, Time: time.Now()}
+		switch {
+		case status.Code(err) == codes.NotFound: // 服务端没有设置该服务的健康状态
+			r.Status = healthz.HealthCheckResponse_SERVICE_UNKNOWN.String()
+			code = worse(code, ExitUnhealthy)
+		case err != nil:
+			r.Status = healthz.HealthCheckResponse_UNKNOWN.String()
+			r.Error = err.Error()
+			code = worse(code, ExitRPCFailed)
+		default:
+			r.Status = resp.Status.String()
+			if resp.Status != healthz.HealthCheckResponse_SERVING {
+				code = worse(code, ExitUnhealthy)
+			}
+		}
+		out.print(r)
+	}
+	return code
+}
+
+// 同时Watch所有服务，流出错后重新建立，直到收到SIGINT或SIGTERM，返回最后一次状态对应的退出码
+func watch(client healthz.HealthClient, cfg *config, out *printer) int {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	var mu sync.Mutex
+	last := make(map[string]healthz.HealthCheckResponse_ServingStatus)
+	var wg sync.WaitGroup
+	for _, service := range cfg.services {
+		wg.Add(1)
+		go func(service string) {
+			defer wg.Done()
+			watchService(ctx, client, service, func(s healthz.HealthCheckResponse_ServingStatus, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				last[service] = s
+				r := result{Service: service, Status: s.String(), Time: time.Now()}
+				if err != nil {
+					r.Error = err.Error()
+				}
+				out.print(r)
+			})
+		}(service)
+	}
+	wg.Wait()
+
+	code := ExitOK
+	for _, service := range cfg.services {
+		if last[service] != healthz.HealthCheckResponse_SERVING {
+			code = worse(code, ExitUnhealthy)
+		}
+	}
+	return code
+}
+
+func watchService(
+	ctx context.Context,
+	client healthz.HealthClient,
+	service string,
+	update func(healthz.HealthCheckResponse_ServingStatus, error),
+) {
+	for {
+		err := watchOnce(ctx, client, service, update)
+		if ctx.Err() != nil {
+			return
+		}
+		update(healthz.HealthCheckResponse_UNKNOWN, err) // 流中断期间状态未知
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(WatchRetryInterval):
+		}
+	}
+}
+
+func watchOnce(
+	ctx context.Context,
+	client healthz.HealthClient,
+	service string,
+	update func(healthz.HealthCheckResponse_ServingStatus, error),
+) error {
+	stream, err := client.Watch(ctx, &healthz.HealthCheckRequest{Service: service}, grpc.WaitForReady(true))
+	if err != nil {
+		return err
+	}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return errors.New("watch stream closed by server")
+		}
+		if err != nil {
+			return err
+		}
+		update(resp.Status, nil)
+	}
+}
+
+// 返回更严重的退出码
+func worse(a, b int) int {
+	if b > a {
+		return b
+	}
+	return a
+}
+
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+func (p *printer) print(r result) {
+	if p.json {
+		data, err := json.Marshal(r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		fmt.Fprintln(p.w, string(data))
+		return
+	}
+	service := r.Service
+	if len(service) == 0 {
+		service = `""`
+	}
+	if len(r.Error) != 0 {
+		fmt.Fprintf(p.w, "%s\t%s\t%s\n", service, r.Status, r.Error)
+		return
+	}
+	fmt.Fprintf(p.w, "%s\t%s\n", service, r.Status)
+}
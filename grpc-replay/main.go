@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	svc "github.com/calmw/grpc-service"
+	"github.com/calmw/grpc-service/record"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: grpc-replay [flags] <record files...>
+
+Re-issues the calls recorded by the server (RECORD_DIR) against another
+server and reports the calls whose status or responses differ.
+
+Flags:
+`
+
+type config struct {
+	addr    string
+	methods map[string]bool
+	timeout time.Duration
+	timing  bool
+	ignore  *record.Redactor
+	auth    bool
+	dump    bool
+	verbose bool
+
+	plaintext  bool
+	caCert     string
+	clientCert string
+	clientKey  string
+	serverName string
+}
+
+func main() {
+	log.SetFlags(0)
+	cfg, files, err := parseFlags(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	ok, err := run(cfg, files)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func parseFlags(args []string) (*config, []string, error) {
+	cfg := config{}
+	var methods, ignore string
+	fs := flag.NewFlagSet("grpc-replay", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.addr, "addr", "localhost:50051", "gRPC server to replay against")
+	fs.StringVar(&methods, "method", "", "comma separated full method names to replay, e.g. /Users/GetUser, empty replays all")
+	fs.DurationVar(&cfg.timeout, "timeout", 10*time.Second, "deadline of each replayed call")
+	fs.BoolVar(&cfg.timing, "timing", false, "send stream messages with the recorded delays")
+	fs.StringVar(&ignore, "ignore", "", "comma separated field paths ignored when comparing responses, e.g. RepoCreateReply.repo.id")
+	fs.BoolVar(&cfg.auth, "keep-auth", false, "also replay recorded authentication metadata such as authorization and cookie, which is removed by default")
+	fs.BoolVar(&cfg.dump, "dump", false, "print the records as JSON instead of replaying them")
+	fs.BoolVar(&cfg.verbose, "v", false, "also print the calls that match")
+	fs.BoolVar(&cfg.plaintext, "plaintext", false, "connect without TLS")
+	fs.StringVar(&cfg.caCert, "tls-ca-cert", "", "CA certificate used to verify the server, defaults to the system roots")
+	fs.StringVar(&cfg.clientCert, "tls-client-cert", "", "client certificate for mTLS")
+	fs.StringVar(&cfg.clientKey, "tls-client-key", "", "client private key for mTLS")
+	fs.StringVar(&cfg.serverName, "tls-server-name", "", "override the server name used to verify the certificate")
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return nil, nil, errors.New("no record files specified")
+	}
+
+	if len(methods) != 0 {
+		cfg.methods = make(map[string]bool)
+		for _, m := range strings.Split(methods, ",") {
+			cfg.methods[strings.TrimSpace(m)] = true
+		}
+	}
+	cfg.ignore, err = record.NewRedactor(ignore)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 录制文件名中的时间保证按字典序即按录制顺序排列
+	var files []string
+	for _, arg := range fs.Args() {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(matches) == 0 {
+			return nil, nil, fmt.Errorf("no such file: %s", arg)
+		}
+		files = append(files, matches...)
+	}
+	return &cfg, files, nil
+}
+
+// 依次重放所有文件中的调用，所有调用的结果都与录制的一致时返回true
+func run(cfg *config, files []string) (bool, error) {
+	var conn *grpc.ClientConn
+	if !cfg.dump {
+		var err error
+		conn, err = setupGrpcConnection(cfg)
+		if err != nil {
+			return false, err
+		}
+		defer conn.Close()
+	}
+
+	var total, differ int
+	for _, name := range files {
+		err := readRecords(name, func(rec *svc.CallRecord) error {
+			if cfg.methods != nil && !cfg.methods[rec.Method] {
+				return nil
+			}
+			if cfg.dump {
+				return printRecord(rec)
+			}
+			total++
+			result := replay(conn, cfg, rec)
+			if !result.matched() {
+				differ++
+			}
+			if !result.matched() || cfg.verbose {
+				result.print(os.Stdout)
+			}
+			return nil
+		})
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if !cfg.dump {
+		fmt.Printf("%d calls replayed, %d differ\n", total, differ)
+	}
+	return differ == 0, nil
+}
+
+// 读取录制文件中长度前缀的svc.CallRecord
+func readRecords(name string, fn func(*svc.CallRecord) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	opts := protodelim.UnmarshalOptions{MaxSize: -1}
+	for {
+		rec := &svc.CallRecord{}
+		err := opts.UnmarshalFrom(r, rec)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(rec)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func printRecord(rec *svc.CallRecord) error {
+	data, err := protojson.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func setupGrpcConnection(cfg *config) (*grpc.ClientConn, error) {
+	creds, err := transportCredentials(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return grpc.DialContext(
+		ctx,
+		cfg.addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithBlock(),
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithReturnConnectionError(),
+	)
+}
+
+func transportCredentials(cfg *config) (credentials.TransportCredentials, error) {
+	if cfg.plaintext {
+		return insecure.NewCredentials(), nil
+	}
+	tlsConfig := &tls.Config{ServerName: cfg.serverName}
+	if len(cfg.caCert) != 0 {
+		pem, err := os.ReadFile(cfg.caCert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(cfg.clientCert) != 0 || len(cfg.clientKey) != 0 {
+		cert, err := tls.LoadX509KeyPair(cfg.clientCert, cfg.clientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
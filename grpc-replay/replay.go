@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	svc "github.com/calmw/grpc-service"
+	"github.com/calmw/grpc-service/record"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+	"io"
+	"strings"
+	"time"
+)
+
+// 重放一次调用的结果
+type result struct {
+	method   string
+	duration time.Duration
+	err      error    // 无法重放，例如录制的方法不存在
+	diffs    []string // 状态或响应与录制的不一致之处
+}
+
+func (r *result) matched() bool {
+	return r.err == nil && len(r.diffs) == 0
+}
+
+func (r *result) print(w io.Writer) {
+	switch {
+	case r.err != nil:
+		fmt.Fprintf(w, "ERROR %s: %v\n", r.method, r.err)
+	case len(r.diffs) != 0:
+		fmt.Fprintf(w, "DIFF  %s (%v)\n", r.method, r.duration)
+		for _, d := range r.diffs {
+			fmt.Fprintf(w, "  %s\n", d)
+		}
+	default:
+		fmt.Fprintf(w, "OK    %s (%v)\n", r.method, r.duration)
+	}
+}
+
+// 以录制的元数据和请求重新调用方法，并与录制的状态和响应比较
+func replay(conn *grpc.ClientConn, cfg *config, rec *svc.CallRecord) *result {
+	res := &result{method: rec.Method}
+	md, err := lookupMethod(rec.Method)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	respType, err := protoregistry.GlobalTypes.FindMessageByName(md.Output().FullName())
+	if err != nil {
+		res.err = err
+		return res
+	}
+	recorded, err := unpackMessages(rec.Responses)
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, replayMetadata(rec.Metadata, cfg.auth))
+	start := time.Now()
+	replayed, err := invoke(ctx, conn, cfg, md, rec, respType)
+	res.duration = time.Since(start)
+
+	want := status.New(codes.Code(rec.StatusCode), rec.StatusMessage)
+	got := status.Convert(err)
+	if want.Code() != got.Code() || want.Message() != got.Message() {
+		res.diffs = append(res.diffs, fmt.Sprintf(
+			"status: recorded %s %q, replayed %s %q",
+			want.Code(), want.Message(), got.Code(), got.Message(),
+		))
+	}
+	for i := 0; i < len(recorded) || i < len(replayed); i++ {
+		var a, b proto.Message
+		if i < len(recorded) {
+			a = recorded[i]
+			cfg.ignore.Clear(a)
+		}
+		if i < len(replayed) {
+			b = replayed[i]
+			cfg.ignore.Clear(b)
+		}
+		if a != nil && b != nil && proto.Equal(a, b) {
+			continue
+		}
+		res.diffs = append(res.diffs, fmt.Sprintf(
+			"response %d:\n    - %s\n    + %s", i+1, messageJSON(a), messageJSON(b),
+		))
+	}
+	return res
+}
+
+// 方法名例如 /Users/GetUser，服务的描述由导入的service包注册
+func lookupMethod(method string) (protoreflect.MethodDescriptor, error) {
+	name := strings.Replace(strings.TrimPrefix(method, "/"), "/", ".", 1)
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("unknown method: %v", err)
+	}
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a method", method)
+	}
+	return md, nil
+}
+
+func unpackMessages(messages []*svc.RecordedMessage) ([]proto.Message, error) {
+	var result []proto.Message
+	for _, m := range messages {
+		pm, err := anypb.UnmarshalNew(m.Message, proto.UnmarshalOptions{})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, pm)
+	}
+	return result, nil
+}
+
+// 由gRPC自己设置的元数据和录制时被脱敏的元数据不重放，认证元数据只在auth为true时重放
+func replayMetadata(entries []*svc.MetadataEntry, auth bool) metadata.MD {
+	md := metadata.MD{}
+	for _, e := range entries {
+		switch {
+		case strings.HasPrefix(e.Key, ":"), strings.HasPrefix(e.Key, "grpc-"):
+		case e.Key == "content-type", e.Key == "user-agent", e.Key == "te", e.Key == "authority":
+		case !auth && record.IsAuthMetadata(e.Key):
+		case len(e.Values) == 1 && e.Values[0] == record.RedactedValue:
+		default:
+			md.Append(e.Key, e.Values...)
+		}
+	}
+	return md
+}
+
+// 所有类型的方法都通过流发起调用，一元方法发送一条请求并接收一条响应
+func invoke(
+	ctx context.Context,
+	conn *grpc.ClientConn,
+	cfg *config,
+	md protoreflect.MethodDescriptor,
+	rec *svc.CallRecord,
+	respType protoreflect.MessageType,
+) ([]proto.Message, error) {
+	stream, err := conn.NewStream(
+		ctx,
+		&grpc.StreamDesc{ClientStreams: md.IsStreamingClient(), ServerStreams: md.IsStreamingServer()},
+		rec.Method,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// 发送请求和接收响应同时进行，-timing时按录制的时间间隔发送
+	sendErr := make(chan error, 1)
+	go func() {
+		sendErr <- sendRequests(ctx, stream, cfg, rec)
+	}()
+	var responses []proto.Message
+	for {
+		resp := respType.New().Interface()
+		err := stream.RecvMsg(resp)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return responses, err
+		}
+		responses = append(responses, resp)
+	}
+	select {
+	case err := <-sendErr:
+		return responses, err
+	default: // 服务端提前结束了流，剩余的请求不再发送
+		return responses, nil
+	}
+}
+
+func sendRequests(ctx context.Context, stream grpc.ClientStream, cfg *config, rec *svc.CallRecord) error {
+	start := time.Now()
+	for _, m := range rec.Requests {
+		if cfg.timing {
+			d := time.Until(start.Add(m.Offset.AsDuration()))
+			select {
+			case <-ctx.Done():
+				return nil // 错误由RecvMsg返回
+			case <-time.After(d):
+			}
+		}
+		req, err := anypb.UnmarshalNew(m.Message, proto.UnmarshalOptions{})
+		if err != nil {
+			stream.CloseSend()
+			return err
+		}
+		err = stream.SendMsg(req)
+		if err == io.EOF { // 流已结束，错误由RecvMsg返回
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return stream.CloseSend()
+}
+
+func messageJSON(m proto.Message) string {
+	if m == nil {
+		return "<none>"
+	}
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
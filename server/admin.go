@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/grpc/codes"
+	healthz "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// adminService 运行时查看和控制服务端，调用需要携带元数据 authorization: Bearer <token>，
+// token来自环境变量ADMIN_TOKEN，未设置时拒绝所有调用
+type adminService struct {
+	svc.UnimplementedAdminServer
+	token   string
+	health  *healthManager
+	tracker *callTracker
+	trigger *shutdownTrigger
+	cache   *responseCache
+}
+
+func newAdminService(token string, hm *healthManager, t *callTracker, trigger *shutdownTrigger, rc *responseCache) *adminService {
+	return &adminService{token: token, health: hm, tracker: t, trigger: trigger, cache: rc}
+}
+
+func (a *adminService) authorize(ctx context.Context) error {
+	if len(a.token) == 0 {
+		return status.Error(codes.PermissionDenied, "Admin service is disabled")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		token := strings.TrimPrefix(v, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "Invalid admin token")
+}
+
+func (a *adminService) ListCalls(ctx context.Context, in *svc.ListCallsRequest) (*svc.ListCallsReply, error) {
+	err := a.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conns, calls := a.tracker.snapshot()
+	reply := &svc.ListCallsReply{}
+	for _, c := range conns {
+		conn := &svc.Connection{
+			Id:         c.id,
+			RemoteAddr: addrString(c.remoteAddr),
+			LocalAddr:  addrString(c.localAddr),
+			StartTime:  timestamppb.New(c.start),
+		}
+		for _, call := range calls[c.id] {
+			conn.Calls = append(conn.Calls, &svc.Call{
+				Id:           call.id,
+				Method:       call.method,
+				ClientStream: call.clientStream,
+				ServerStream: call.serverStream,
+				StartTime:    timestamppb.New(call.start),
+			})
+		}
+		reply.Connections = append(reply.Connections, conn)
+	}
+	return reply, nil
+}
+
+func (a *adminService) CloseCall(ctx context.Context, in *svc.CloseCallRequest) (*svc.CloseCallReply, error) {
+	err := a.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !a.tracker.cancel(in.Id) {
+		return nil, status.Errorf(codes.NotFound, "No call with id %d", in.Id)
+	}
+	log.Printf("Admin: call %d closed", in.Id)
+	return &svc.CloseCallReply{}, nil
+}
+
+// 手动设置的状态优先于健康检查探针的结果，直到以AUTO清除
+func (a *adminService) SetHealth(ctx context.Context, in *svc.SetHealthRequest) (*svc.SetHealthReply, error) {
+	err := a.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToUpper(in.Status) == "AUTO" {
+		a.health.clearOverride(in.Service)
+		log.Printf("Admin: health of %q is driven by probes again", in.Service)
+		return &svc.SetHealthReply{}, nil
+	}
+	s := healthz.HealthCheckResponse_ServingStatus(healthz.HealthCheckResponse_ServingStatus_value[strings.ToUpper(in.Status)])
+	if s != healthz.HealthCheckResponse_SERVING && s != healthz.HealthCheckResponse_NOT_SERVING {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid status %q, want SERVING, NOT_SERVING or AUTO", in.Status)
+	}
+	a.health.override(in.Service, s)
+	log.Printf("Admin: health of %q set to %v", in.Service, s)
+	return &svc.SetHealthReply{}, nil
+}
+
+func (a *adminService) SetLogLevel(ctx context.Context, in *svc.SetLogLevelRequest) (*svc.SetLogLevelReply, error) {
+	err := a.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := setLogLevel(in.Level)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	log.Printf("Admin: log level set to %s", strings.ToLower(in.Level))
+	return &svc.SetLogLevelReply{Previous: prev}, nil
+}
+
+func (a *adminService) SetInterceptor(ctx context.Context, in *svc.SetInterceptorRequest) (*svc.SetInterceptorReply, error) {
+	err := a.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = interceptors.set(in.Name, in.Enabled)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	log.Printf("Admin: interceptor %s enabled: %v", in.Name, in.Enabled)
+	reply := &svc.SetInterceptorReply{}
+	for _, s := range interceptors.list() {
+		reply.Interceptors = append(reply.Interceptors, &svc.InterceptorState{Name: s.name, Enabled: s.enabled})
+	}
+	return reply, nil
+}
+
+// 与收到SIGTERM相同，立即返回，服务端在后台按SHUTDOWN_DELAY和SHUTDOWN_TIMEOUT优雅关闭
+func (a *adminService) Drain(ctx context.Context, in *svc.DrainRequest) (*svc.DrainReply, error) {
+	err := a.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !a.trigger.fire("Admin.Drain") {
+		return nil, status.Error(codes.FailedPrecondition, "Server is already shutting down")
+	}
+	return &svc.DrainReply{}, nil
+}
+
+// 数据在服务端之外被修改后删除缓存的回复，tags为 user:<id>、email:<邮箱> 等标签，all为true时清空缓存
+func (a *adminService) InvalidateCache(ctx context.Context, in *svc.InvalidateCacheRequest) (*svc.InvalidateCacheReply, error) {
+	err := a.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var n int
+	switch {
+	case in.All:
+		n = a.cache.purge()
+	case len(in.Tags) != 0:
+		n = a.cache.invalidate(in.Tags...)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "Specify tags or all")
+	}
+	log.Printf("Admin: %d cached replies removed", n)
+	return &svc.InvalidateCacheReply{Removed: uint32(n)}, nil
+}
+
+func addrString(addr fmt.Stringer) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// 日志级别，debug输出流收发每条消息的日志，error只输出失败的调用
+const (
+	logDebug int32 = iota
+	logInfo
+	logError
+)
+
+var logLevelNames = []string{"debug", "info", "error"}
+
+var logLevel = logDebug
+
+func setLogLevel(name string) (string, error) {
+	for i, n := range logLevelNames {
+		if n == strings.ToLower(name) {
+			prev := atomic.SwapInt32(&logLevel, int32(i))
+			return logLevelNames[prev], nil
+		}
+	}
+	return "", fmt.Errorf("invalid log level %q, want one of %s", name, strings.Join(logLevelNames, ", "))
+}
+
+func logEnabled(level int32) bool {
+	return atomic.LoadInt32(&logLevel) <= level
+}
+
+func debugf(format string, v ...interface{}) {
+	if logEnabled(logDebug) {
+		log.Printf(format, v...)
+	}
+}
+
+// 可以通过Admin服务开关的拦截器，panic处理拦截器不能关闭
+var interceptors = newInterceptorSwitches("logging", "recording", "timeout", "validation", "fault-injection", "cache", "singleflight")
+
+type interceptorSwitches struct {
+	mu      sync.RWMutex
+	names   []string
+	enabled map[string]bool
+}
+
+type interceptorState struct {
+	name    string
+	enabled bool
+}
+
+func newInterceptorSwitches(names ...string) *interceptorSwitches {
+	s := &interceptorSwitches{names: names, enabled: make(map[string]bool)}
+	for _, n := range names {
+		s.enabled[n] = true
+	}
+	return s
+}
+
+func (s *interceptorSwitches) isEnabled(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.enabled[name]
+}
+
+// panic等不在开关中的拦截器总是开启
+func (s *interceptorSwitches) canToggle(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.enabled[name]
+	return ok
+}
+
+func (s *interceptorSwitches) set(name string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.enabled[name]; !ok {
+		return fmt.Errorf("unknown interceptor %q, want one of %s", name, strings.Join(s.names, ", "))
+	}
+	s.enabled[name] = enabled
+	return nil
+}
+
+func (s *interceptorSwitches) list() []interceptorState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var states []interceptorState
+	for _, n := range s.names {
+		states = append(states, interceptorState{name: n, enabled: s.enabled[n]})
+	}
+	return states
+}
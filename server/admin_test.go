@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/grpc/codes"
+	healthz "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminRequiresToken(t *testing.T) {
+	ts := startTestServer(t)
+	_, err := ts.Admin.ListCalls(context.Background(), &svc.ListCallsRequest{})
+	assertStatus(t, err, codes.Unauthenticated, "Invalid admin token")
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer wrong")
+	_, err = ts.Admin.Drain(ctx, &svc.DrainRequest{})
+	assertStatus(t, err, codes.Unauthenticated, "Invalid admin token")
+	_, err = ts.Admin.ListCalls(adminContext(), &svc.ListCallsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdminListAndCloseCall(t *testing.T) {
+	ts := startTestServer(t)
+	// 关闭超时拦截器，避免流在CloseCall之前因RecvMsgTimeout结束
+	ts.Admin.SetInterceptor(adminContext(), &svc.SetInterceptorRequest{Name: "timeout", Enabled: false})
+	stream, err := ts.Users.GetHelp(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&svc.UserHelpRequest{Request: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := ts.Admin.ListCalls(adminContext(), &svc.ListCallsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var call *svc.Call
+	for _, conn := range resp.Connections {
+		for _, c := range conn.Calls {
+			if c.Method == "/Users/GetHelp" {
+				call = c
+			}
+		}
+	}
+	if call == nil || !call.ClientStream || !call.ServerStream {
+		t.Fatalf("GetHelp stream not listed correctly: %v", resp)
+	}
+
+	_, err = ts.Admin.CloseCall(adminContext(), &svc.CloseCallRequest{Id: call.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	assertStatus(t, err, codes.Canceled, "context canceled")
+	_, err = ts.Admin.CloseCall(adminContext(), &svc.CloseCallRequest{Id: call.Id})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("got %v, want NotFound for a closed call", err)
+	}
+}
+
+func TestAdminSetHealth(t *testing.T) {
+	ts := startTestServer(t)
+	_, err := ts.Admin.SetHealth(adminContext(), &svc.SetHealthRequest{Service: "Repo", Status: "not_serving"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ts.Health.Check(context.Background(), &healthz.HealthCheckRequest{Service: "Repo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthz.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("Repo is %v, want NOT_SERVING", resp.Status)
+	}
+	_, err = ts.Admin.SetHealth(adminContext(), &svc.SetHealthRequest{Service: "Repo", Status: "UNKNOWN"})
+	assertStatus(t, err, codes.InvalidArgument, `Invalid status "UNKNOWN", want SERVING, NOT_SERVING or AUTO`)
+}
+
+// 手动设置的状态不会被健康检查探针的结果覆盖，直到以AUTO清除
+func TestAdminSetHealthOverridesProbes(t *testing.T) {
+	t.Setenv("HEALTH_CHECK_INTERVAL", "10ms")
+	ts := startTestServer(t)
+	ts.runHealthManager(t)
+	healthOf := func(service string) healthz.HealthCheckResponse_ServingStatus {
+		resp, err := ts.Health.Check(context.Background(), &healthz.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Status
+	}
+
+	_, err := ts.Admin.SetHealth(adminContext(), &svc.SetHealthRequest{Service: "Repo", Status: "NOT_SERVING"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // 探针执行多次，结果都为SERVING
+	if s := healthOf("Repo"); s != healthz.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("Repo is %v, want NOT_SERVING", s)
+	}
+	if s := healthOf(""); s != healthz.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("server is %v, want NOT_SERVING", s)
+	}
+
+	_, err = ts.Admin.SetHealth(adminContext(), &svc.SetHealthRequest{Service: "Repo", Status: "auto"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := healthOf("Repo"); s != healthz.HealthCheckResponse_SERVING {
+		t.Fatalf("Repo is %v, want SERVING", s)
+	}
+	if s := healthOf(""); s != healthz.HealthCheckResponse_SERVING {
+		t.Fatalf("server is %v, want SERVING", s)
+	}
+}
+
+func TestAdminSetInterceptor(t *testing.T) {
+	ts := startTestServer(t)
+	ts.injectFaults(faultRule{Method: "/Users/GetUser", Delay: faultDelay(400 * time.Millisecond)})
+	resp, err := ts.Admin.SetInterceptor(adminContext(), &svc.SetInterceptorRequest{Name: "timeout", Enabled: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range resp.Interceptors {
+		if s.Enabled != (s.Name != "timeout") {
+			t.Fatalf("unexpected interceptor state: %v", resp.Interceptors)
+		}
+	}
+	// 关闭超时拦截器后，超过300ms的调用不再被终止
+	_, err = ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane@doe.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ts.Admin.SetInterceptor(adminContext(), &svc.SetInterceptorRequest{Name: "panic"})
+	assertStatus(t, err, codes.InvalidArgument, `unknown interceptor "panic", want one of logging, recording, timeout, validation, fault-injection, cache, singleflight`)
+}
+
+func TestAdminSetLogLevel(t *testing.T) {
+	ts := startTestServer(t)
+	resp, err := ts.Admin.SetLogLevel(adminContext(), &svc.SetLogLevelRequest{Level: "error"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Previous != "debug" {
+		t.Fatalf("previous level is %q, want debug", resp.Previous)
+	}
+	_, err = ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane"})
+	assertStatus(t, err, codes.InvalidArgument, "Invalid UserGetRequest: email: value must be a valid email address")
+	_, err = ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane@doe.com", Id: "quiet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// error级别只记录失败的调用
+	ts.assertLogged(t, `Method: /Users/GetUser, Latency: \S+, Error: rpc error`)
+	if strings.Contains(ts.logs.String(), "Error: <nil>") {
+		t.Fatalf("successful call logged at error level:\n%s", ts.logs.String())
+	}
+}
+
+func TestAdminDrain(t *testing.T) {
+	ts := startTestServer(t)
+	_, err := ts.Admin.Drain(adminContext(), &svc.DrainRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason := <-ts.trigger.ch; reason != "Admin.Drain" {
+		t.Fatalf("shutdown triggered by %q, want Admin.Drain", reason)
+	}
+	_, err = ts.Admin.Drain(adminContext(), &svc.DrainRequest{})
+	assertStatus(t, err, codes.FailedPrecondition, "Server is already shutting down")
+}
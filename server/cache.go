@@ -0,0 +1,337 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 缓存回复的默认有效期
+const DefaultCacheTTL = time.Second * 10
+
+// 默认最多缓存的回复数，超过后淘汰最久未使用的
+const DefaultCacheMaxEntries = 1000
+
+// 默认缓存回复的方法
+const DefaultCacheMethods = "/Users/GetUser"
+
+// responseCache 按方法和请求内容缓存一元方法的成功回复，有效期为ttl，超过maxEntries时淘汰最久未使用的回复。
+// 每条回复带有标签（例如 user:1），Users.UpdateUser修改用户后删除该用户的缓存，Admin.InvalidateCache按标签删除缓存
+type responseCache struct {
+	ttl        time.Duration
+	maxEntries int
+	methods    map[string]bool
+
+	mu      sync.Mutex
+	lru     *list.List // 元素为*cacheEntry，最近使用的在前
+	entries map[string]*list.Element
+	stats   map[string]*methodCacheStats
+	evicted int64
+	expired int64
+	removed int64  // 被invalidate删除的回复数
+	gen     uint64 // 每次invalidate或purge时加1，处理函数执行期间发生过删除时不缓存其回复
+}
+
+type cacheEntry struct {
+	key     string
+	method  string
+	reply   proto.Message
+	tags    []string
+	expires time.Time
+}
+
+type methodCacheStats struct {
+	Method string `json:"method"`
+	Hits   int64  `json:"hits"`
+	Misses int64  `json:"misses"`
+	Bypass int64  `json:"bypass"` // 请求携带 cache-control: no-cache 或 no-store
+}
+
+// 设置CACHE_TTL为0时不缓存，CACHE_MAX_ENTRIES限制缓存的回复数，
+// CACHE_METHODS为逗号分隔的完整方法名，只有一元方法的回复会被缓存
+func setupResponseCache() (*responseCache, error) {
+	ttl, err := durationFromEnv("CACHE_TTL", DefaultCacheTTL)
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		return nil, nil
+	}
+	maxEntries, err := intFromEnv("CACHE_MAX_ENTRIES", DefaultCacheMaxEntries)
+	if err != nil {
+		return nil, err
+	}
+	methods, ok := os.LookupEnv("CACHE_METHODS")
+	if !ok {
+		methods = DefaultCacheMethods
+	}
+	return newResponseCache(ttl, maxEntries, strings.Split(methods, ",")...), nil
+}
+
+func newResponseCache(ttl time.Duration, maxEntries int, methods ...string) *responseCache {
+	rc := &responseCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		methods:    make(map[string]bool),
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+		stats:      make(map[string]*methodCacheStats),
+	}
+	for _, m := range methods {
+		m = strings.TrimSpace(m)
+		if len(m) != 0 {
+			rc.methods[m] = true
+		}
+	}
+	return rc
+}
+
+// 缓存的键为方法名和确定性序列化的请求，字段顺序和map的遍历顺序不影响结果
+func cacheKey(method string, req interface{}) (string, bool) {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return "", false
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return "", false
+	}
+	return method + "\x00" + string(b), true
+}
+
+// 回复的标签，invalidate按标签删除缓存
+func cacheTags(req interface{}) []string {
+	switch r := req.(type) {
+	case *svc.UserGetRequest:
+		return userCacheTags(r.Id, r.Email)
+	}
+	return nil
+}
+
+// 用户数据的标签，按用户ID和邮箱删除缓存
+func userCacheTags(id, email string) []string {
+	var tags []string
+	if len(id) != 0 {
+		tags = append(tags, "user:"+id)
+	}
+	if len(email) != 0 {
+		tags = append(tags, "email:"+email)
+	}
+	return tags
+}
+
+// 请求元数据 cache-control: no-cache 跳过缓存但缓存新的回复，no-store 既不读取也不写入缓存
+func cacheControl(ctx context.Context) (noCache, noStore bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("cache-control") {
+		for _, d := range strings.Split(v, ",") {
+			switch strings.ToLower(strings.TrimSpace(d)) {
+			case "no-cache":
+				noCache = true
+			case "no-store":
+				noCache, noStore = true, true
+			}
+		}
+	}
+	return
+}
+
+func (rc *responseCache) get(key string) (proto.Message, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	e, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		rc.remove(e)
+		rc.expired++
+		return nil, false
+	}
+	rc.lru.MoveToFront(e)
+	return proto.Clone(entry.reply), true // 调用方可能修改回复，每次返回副本
+}
+
+func (rc *responseCache) generation() uint64 {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.gen
+}
+
+// 缓存回复，gen为调用处理函数前的generation，之后发生过删除时回复可能已经过时，不缓存
+func (rc *responseCache) put(key, method string, reply proto.Message, tags []string, gen uint64) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.gen != gen {
+		return
+	}
+	if e, ok := rc.entries[key]; ok {
+		rc.remove(e)
+	}
+	rc.entries[key] = rc.lru.PushFront(&cacheEntry{
+		key:     key,
+		method:  method,
+		reply:   proto.Clone(reply),
+		tags:    tags,
+		expires: time.Now().Add(rc.ttl),
+	})
+	for rc.lru.Len() > rc.maxEntries {
+		rc.remove(rc.lru.Back())
+		rc.evicted++
+	}
+}
+
+// 调用方持有mu
+func (rc *responseCache) remove(e *list.Element) {
+	rc.lru.Remove(e)
+	delete(rc.entries, e.Value.(*cacheEntry).key)
+}
+
+// 删除带有任一标签的回复，返回删除的数量，rc为nil（未启用缓存）时什么也不做
+func (rc *responseCache) invalidate(tags ...string) int {
+	if rc == nil || len(tags) == 0 {
+		return 0
+	}
+	want := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		want[t] = true
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.gen++
+	n := 0
+	for e := rc.lru.Front(); e != nil; {
+		next := e.Next()
+		for _, t := range e.Value.(*cacheEntry).tags {
+			if want[t] {
+				rc.remove(e)
+				n++
+				break
+			}
+		}
+		e = next
+	}
+	rc.removed += int64(n)
+	return n
+}
+
+// 清空缓存，返回删除的数量
+func (rc *responseCache) purge() int {
+	if rc == nil {
+		return 0
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.gen++
+	n := rc.lru.Len()
+	rc.lru.Init()
+	rc.entries = make(map[string]*list.Element)
+	rc.removed += int64(n)
+	return n
+}
+
+func (rc *responseCache) count(method string, f func(s *methodCacheStats)) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	s, ok := rc.stats[method]
+	if !ok {
+		s = &methodCacheStats{Method: method}
+		rc.stats[method] = s
+	}
+	f(s)
+}
+
+type cacheOverview struct {
+	TTL         string             `json:"ttl"`
+	MaxEntries  int                `json:"max_entries"`
+	Entries     int                `json:"entries"`
+	Evicted     int64              `json:"evicted"`
+	Expired     int64              `json:"expired"`
+	Invalidated int64              `json:"invalidated"`
+	Methods     []methodCacheStats `json:"methods"` // 按方法名排序
+}
+
+func (rc *responseCache) overview() cacheOverview {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	o := cacheOverview{
+		TTL:         rc.ttl.String(),
+		MaxEntries:  rc.maxEntries,
+		Entries:     rc.lru.Len(),
+		Evicted:     rc.evicted,
+		Expired:     rc.expired,
+		Invalidated: rc.removed,
+		Methods:     make([]methodCacheStats, 0, len(rc.methods)),
+	}
+	for m := range rc.methods {
+		s := methodCacheStats{Method: m}
+		if got, ok := rc.stats[m]; ok {
+			s = *got
+		}
+		o.Methods = append(o.Methods, s)
+	}
+	sort.Slice(o.Methods, func(i, j int) bool { return o.Methods[i].Method < o.Methods[j].Method })
+	return o
+}
+
+func (rc *responseCache) String() string {
+	if rc == nil {
+		return "CACHE_TTL=0"
+	}
+	methods := make([]string, 0, len(rc.methods))
+	for m := range rc.methods {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return fmt.Sprintf("ttl=%v max_entries=%d methods=%s", rc.ttl, rc.maxEntries, strings.Join(methods, ","))
+}
+
+// 服务端，一元方法的回复缓存拦截器，位于拦截器链的最内层，故障注入和校验对缓存的回复同样生效。
+// 只缓存成功的回复，回复头 x-cache 为HIT或MISS
+func (rc *responseCache) unaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if rc == nil || !rc.methods[info.FullMethod] || !interceptors.isEnabled("cache") {
+		return handler(ctx, req)
+	}
+	key, ok := cacheKey(info.FullMethod, req)
+	if !ok {
+		return handler(ctx, req)
+	}
+	noCache, noStore := cacheControl(ctx)
+	if !noCache {
+		if reply, ok := rc.get(key); ok {
+			rc.count(info.FullMethod, func(s *methodCacheStats) { s.Hits++ })
+			grpc.SetHeader(ctx, metadata.Pairs("x-cache", "HIT"))
+			debugf("Cache hit for %s", info.FullMethod)
+			return reply, nil
+		}
+	}
+	rc.count(info.FullMethod, func(s *methodCacheStats) {
+		if noCache {
+			s.Bypass++
+		} else {
+			s.Misses++
+		}
+	})
+	grpc.SetHeader(ctx, metadata.Pairs("x-cache", "MISS"))
+	gen := rc.generation()
+	resp, err := handler(ctx, req)
+	if reply, ok := resp.(proto.Message); ok && err == nil && !noStore {
+		rc.put(key, info.FullMethod, reply, cacheTags(req), gen)
+	}
+	return resp, err
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 调用GetUser，返回回复头x-cache的值
+func (ts *testServer) getUserCached(t *testing.T, ctx context.Context, in *svc.UserGetRequest) string {
+	t.Helper()
+	var header metadata.MD
+	resp, err := ts.Users.GetUser(ctx, in, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.User.Id != in.Id {
+		t.Fatalf("unexpected user: %v", resp.User)
+	}
+	return strings.Join(header.Get("x-cache"), ",")
+}
+
+// 处理函数被调用的次数
+func (ts *testServer) getUserHandled() int {
+	return strings.Count(ts.logs.String(), "Received request for user")
+}
+
+func TestResponseCache(t *testing.T) {
+	ts := startTestServer(t)
+	ctx := context.Background()
+	jane := &svc.UserGetRequest{Email: "jane@doe.com", Id: "1"}
+	for i, want := range []string{"MISS", "HIT", "HIT"} {
+		if got := ts.getUserCached(t, ctx, jane); got != want {
+			t.Fatalf("call %d: got x-cache %q, want %q", i, got, want)
+		}
+	}
+	if got := ts.getUserCached(t, ctx, &svc.UserGetRequest{Email: "jane@doe.com", Id: "2"}); got != "MISS" {
+		t.Fatalf("different request: got x-cache %q, want MISS", got)
+	}
+	if n := ts.getUserHandled(); n != 2 {
+		t.Fatalf("handler called %d times, want 2", n)
+	}
+
+	// no-cache跳过缓存并缓存新的回复，no-store既不读取也不写入
+	noCache := metadata.AppendToOutgoingContext(ctx, "cache-control", "no-cache")
+	if got := ts.getUserCached(t, noCache, jane); got != "MISS" {
+		t.Fatalf("no-cache: got x-cache %q, want MISS", got)
+	}
+	noStore := metadata.AppendToOutgoingContext(ctx, "cache-control", "no-store")
+	if got := ts.getUserCached(t, noStore, &svc.UserGetRequest{Email: "john@doe.com", Id: "3"}); got != "MISS" {
+		t.Fatalf("no-store: got x-cache %q, want MISS", got)
+	}
+	if got := ts.getUserCached(t, ctx, &svc.UserGetRequest{Email: "john@doe.com", Id: "3"}); got != "MISS" {
+		t.Fatalf("after no-store: got x-cache %q, want MISS", got)
+	}
+
+	o := ts.cache.overview()
+	if o.Entries != 3 || len(o.Methods) != 1 {
+		t.Fatalf("unexpected cache overview: %+v", o)
+	}
+	if s := o.Methods[0]; s.Method != "/Users/GetUser" || s.Hits != 2 || s.Misses != 3 || s.Bypass != 2 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+// 失败的回复不缓存，故障注入在缓存之外，对缓存的回复同样生效
+func TestResponseCacheErrors(t *testing.T) {
+	ts := startTestServer(t)
+	ctx := context.Background()
+	interceptors.set("validation", false)
+	for i := 0; i < 2; i++ {
+		_, err := ts.Users.GetUser(ctx, &svc.UserGetRequest{Email: "jane"})
+		assertStatus(t, err, codes.InvalidArgument, "Invalid email address specified")
+	}
+	jane := &svc.UserGetRequest{Email: "jane@doe.com", Id: "1"}
+	ts.getUserCached(t, ctx, jane)
+	ts.injectFaults(faultRule{Method: "/Users/GetUser", Code: codes.Unavailable, Message: "injected"})
+	_, err := ts.Users.GetUser(ctx, jane)
+	assertStatus(t, err, codes.Unavailable, "injected")
+	if n := ts.getUserHandled(); n != 3 {
+		t.Fatalf("handler called %d times, want 3", n)
+	}
+
+	// 关闭缓存拦截器后每次都调用处理函数
+	ts.injectFaults()
+	interceptors.set("cache", false)
+	ts.getUserCached(t, ctx, jane)
+	if n := ts.getUserHandled(); n != 4 {
+		t.Fatalf("handler called %d times, want 4", n)
+	}
+}
+
+func TestAdminInvalidateCache(t *testing.T) {
+	ts := startTestServer(t)
+	ctx := context.Background()
+	jane := &svc.UserGetRequest{Email: "jane@doe.com", Id: "1"}
+	john := &svc.UserGetRequest{Email: "john@doe.com", Id: "2"}
+	ts.getUserCached(t, ctx, jane)
+	ts.getUserCached(t, ctx, john)
+
+	resp, err := ts.Admin.InvalidateCache(adminContext(), &svc.InvalidateCacheRequest{Tags: []string{"email:jane@doe.com"}})
+	if err != nil || resp.Removed != 1 {
+		t.Fatalf("unexpected reply %v: %v", resp, err)
+	}
+	if got := ts.getUserCached(t, ctx, jane); got != "MISS" {
+		t.Fatalf("jane: got x-cache %q, want MISS", got)
+	}
+	if got := ts.getUserCached(t, ctx, john); got != "HIT" {
+		t.Fatalf("john: got x-cache %q, want HIT", got)
+	}
+
+	resp, err = ts.Admin.InvalidateCache(adminContext(), &svc.InvalidateCacheRequest{All: true})
+	if err != nil || resp.Removed != 2 {
+		t.Fatalf("unexpected reply %v: %v", resp, err)
+	}
+	_, err = ts.Admin.InvalidateCache(adminContext(), &svc.InvalidateCacheRequest{})
+	assertStatus(t, err, codes.InvalidArgument, "Specify tags or all")
+	_, err = ts.Admin.InvalidateCache(ctx, &svc.InvalidateCacheRequest{All: true})
+	assertStatus(t, err, codes.Unauthenticated, "Invalid admin token")
+	if o := ts.cache.overview(); o.Entries != 0 || o.Invalidated != 3 {
+		t.Fatalf("unexpected cache overview: %+v", o)
+	}
+}
+
+func TestUpdateUserInvalidatesCache(t *testing.T) {
+	ts := startTestServer(t)
+	ctx := context.Background()
+	jane := &svc.UserGetRequest{Email: "jane@doe.com", Id: "1"}
+	john := &svc.UserGetRequest{Email: "john@doe.com", Id: "2"}
+	ts.getUserCached(t, ctx, jane)
+	ts.getUserCached(t, ctx, &svc.UserGetRequest{Email: "jane@example.com", Id: "1"})
+	ts.getUserCached(t, ctx, john)
+
+	_, err := ts.Users.UpdateUser(ctx, &svc.UserUpdateRequest{User: &svc.User{Id: "1", FirstName: "Janet", LastName: "Doe", Age: 37}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header metadata.MD
+	resp, err := ts.Users.GetUser(ctx, jane, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get("x-cache"); len(got) != 1 || got[0] != "MISS" {
+		t.Fatalf("jane: got x-cache %v, want MISS", got)
+	}
+	if resp.User.FirstName != "Janet" || resp.User.Age != 37 {
+		t.Fatalf("got stale user %v", resp.User)
+	}
+	if got := ts.getUserCached(t, ctx, john); got != "HIT" {
+		t.Fatalf("john: got x-cache %q, want HIT", got)
+	}
+	if o := ts.cache.overview(); o.Invalidated != 2 {
+		t.Fatalf("unexpected cache overview: %+v", o)
+	}
+
+	_, err = ts.Users.UpdateUser(ctx, &svc.UserUpdateRequest{User: &svc.User{FirstName: "Janet"}})
+	assertStatus(t, err, codes.InvalidArgument, "User id is required")
+	_, err = ts.Users.UpdateUser(ctx, &svc.UserUpdateRequest{})
+	assertStatus(t, err, codes.InvalidArgument, "Invalid UserUpdateRequest: user: value is required")
+}
+
+func TestResponseCacheExpiryAndEviction(t *testing.T) {
+	rc := newResponseCache(50*time.Millisecond, 2, "/Users/GetUser")
+	for _, id := range []string{"1", "2", "3"} {
+		rc.put(id, "/Users/GetUser", &svc.UserGetReply{User: &svc.User{Id: id}}, userCacheTags(id, ""), rc.generation())
+	}
+	if _, ok := rc.get("1"); ok {
+		t.Fatal("least recently used reply not evicted")
+	}
+	reply, ok := rc.get("2")
+	if !ok || reply.(*svc.UserGetReply).User.Id != "2" {
+		t.Fatalf("unexpected reply: %v", reply)
+	}
+	// 返回的是副本，修改不影响缓存
+	reply.(*svc.UserGetReply).User.Id = "changed"
+	if reply, _ := rc.get("2"); reply.(*svc.UserGetReply).User.Id != "2" {
+		t.Fatalf("cached reply modified: %v", reply)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := rc.get("2"); ok {
+		t.Fatal("expired reply returned")
+	}
+	if o := rc.overview(); o.Entries != 1 || o.Evicted != 1 || o.Expired != 1 {
+		t.Fatalf("unexpected cache overview: %+v", o)
+	}
+}
+
+func TestResponseCacheFromEnv(t *testing.T) {
+	t.Setenv("CACHE_TTL", "0")
+	rc, err := setupResponseCache()
+	if err != nil || rc != nil {
+		t.Fatalf("got %v, %v, want cache disabled", rc, err)
+	}
+	if n := rc.invalidate("user:1"); n != 0 {
+		t.Fatalf("disabled cache removed %d replies", n)
+	}
+
+	t.Setenv("CACHE_TTL", "1m")
+	t.Setenv("CACHE_MAX_ENTRIES", "10")
+	t.Setenv("CACHE_METHODS", "/Users/GetUser, /Repo/GetRepos")
+	rc, err = setupResponseCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rc.String(); got != "ttl=1m0s max_entries=10 methods=/Repo/GetRepos,/Users/GetUser" {
+		t.Fatalf("unexpected config: %s", got)
+	}
+
+	t.Setenv("CACHE_MAX_ENTRIES", "0")
+	_, err = setupResponseCache()
+	if err == nil || err.Error() != "invalid CACHE_MAX_ENTRIES: 0" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDebugCache(t *testing.T) {
+	ts := startTestServer(t)
+	ts.getUserCached(t, context.Background(), &svc.UserGetRequest{Email: "jane@doe.com", Id: "1"})
+	code, body := ts.getDebug(t, "/debug/cache?format=json")
+	var o cacheOverview
+	if code != http.StatusOK || json.Unmarshal([]byte(body), &o) != nil {
+		t.Fatalf("unexpected response %d: %s", code, body)
+	}
+	if o.Entries != 1 || len(o.Methods) != 1 || o.Methods[0].Misses != 1 {
+		t.Fatalf("unexpected cache overview: %s", body)
+	}
+}
+
+// 处理函数执行期间发生的删除使其回复不被缓存，否则可能缓存删除前读到的过时数据
+func TestResponseCacheInvalidatedDuringHandler(t *testing.T) {
+	resetRuntimeConfig(t)
+	rc := newResponseCache(time.Minute, 10, "/Users/GetUser")
+	in := &svc.UserGetRequest{Email: "jane@doe.com", Id: "1"}
+	calls := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		if calls == 1 {
+			rc.invalidate("user:1") // 数据在处理函数读取之后被修改
+		}
+		return &svc.UserGetReply{User: &svc.User{Id: "1"}}, nil
+	}
+	for i := 0; i < 3; i++ {
+		_, err := rc.unaryInterceptor(context.Background(), in, getUserInfo, handler)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("handler called %d times, want 2", calls)
+	}
+	if o := rc.overview(); o.Entries != 1 || o.Methods[0].Hits != 1 || o.Methods[0].Misses != 2 {
+		t.Fatalf("unexpected cache overview: %+v", o)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	svc "github.com/calmw/grpc-service"
+	"github.com/calmw/grpc-service/codec"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"strings"
+	"testing"
+)
+
+func TestCodecs(t *testing.T) {
+	ws := newWireStats()
+	ts := startTestServer(t, grpc.WithStatsHandler(ws))
+	for _, name := range []string{codec.JSON, codec.Fast} {
+		t.Run(name, func(t *testing.T) {
+			reply, err := ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane@doe.com", Id: "1"}, grpc.CallContentSubtype(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply.User.FirstName != "jane" || reply.User.Id != "1" {
+				t.Fatalf("unexpected reply: %v", reply)
+			}
+			ws.mu.Lock()
+			isJSON := bytes.HasPrefix(ws.payloads["/Users/GetUser"], []byte("{"))
+			ws.mu.Unlock()
+			if isJSON != (name == codec.JSON) {
+				t.Fatalf("unexpected encoding of the request")
+			}
+
+			// 服务端的校验等拦截器看到的是解码后的消息，与编解码器无关
+			_, err = ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane"}, grpc.CallContentSubtype(name))
+			assertStatus(t, err, codes.InvalidArgument, "Invalid UserGetRequest: email: value must be a valid email address")
+
+			stream, err := ts.Repo.CreateRepo(context.Background(), grpc.CallContentSubtype(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := bytes.Repeat([]byte{0, 1, 2, 255}, 20000)
+			created, err := svc.CreateRepoFromReader(stream, &svc.RepoContext{CreatorId: "user-123", Name: "repo-" + name}, bytes.NewReader(data), map[string]string{"codec": name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created.Size != int64(len(data)) {
+				t.Fatalf("unexpected size: %d", created.Size)
+			}
+		})
+	}
+}
+
+// 命令行工具可以不使用生成的消息类型，直接发送和接收JSON
+func TestRawJSON(t *testing.T) {
+	ts := startTestServer(t)
+	var reply codec.RawJSON
+	err := ts.conn.Invoke(context.Background(), "/Users/GetUser", codec.RawJSON(`{"email":"jane@doe.com","id":"1","unknown":true}`), &reply, grpc.CallContentSubtype(codec.JSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(reply), `"firstName":"jane"`) {
+		t.Fatalf("unexpected reply: %s", reply)
+	}
+	err = ts.conn.Invoke(context.Background(), "/Users/GetUser", codec.RawJSON(`{"email":`), &reply, grpc.CallContentSubtype(codec.JSON))
+	if status.Code(err) != codes.Internal || !strings.Contains(err.Error(), "error unmarshalling request") {
+		t.Fatalf("expected unmarshalling error, got %v", err)
+	}
+}
+
+func TestFastCodecMatchesProto(t *testing.T) {
+	fast := encoding.GetCodec(codec.Fast)
+	for _, m := range []proto.Message{
+		&svc.RepoGetReply{Repo: &svc.Repository{Id: "repo-1", Name: "test", Url: "https://git.example.com/test", Owner: &svc.User{Id: "user-1", FirstName: "Jane", Age: 36}}},
+		&svc.RepoCreateRequest{Body: &svc.RepoCreateRequest_Data{Data: []byte("hello")}},
+		&svc.UserHelpReply{Response: "hello"},
+	} {
+		want, err := proto.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := fast.Marshal(m)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("vtproto encoding of %T differs: %x, want %x, err %v", m, got, want, err)
+		}
+		// 解码前清空消息，不会与已有的字段合并
+		decoded := m.ProtoReflect().New().Interface()
+		proto.Merge(decoded, m)
+		err = fast.Unmarshal(want, decoded)
+		if err != nil || !proto.Equal(decoded, m) {
+			t.Fatalf("vtproto decoding of %T: %v, want %v, err %v", m, decoded, m, err)
+		}
+	}
+}
+
+// 比较proto、vtproto和JSON编解码器编码和解码CreateRepo的数据分块和GetRepos的回复的开销
+func BenchmarkCodecs(b *testing.B) {
+	messages := []struct {
+		name string
+		msg  proto.Message
+	}{
+		{"RepoCreateRequest", &svc.RepoCreateRequest{Body: &svc.RepoCreateRequest_Data{Data: repoData(svc.RepoChunkSize)}}},
+		{"RepoGetReply", &svc.RepoGetReply{Repo: &svc.Repository{Id: "repo-1", Name: "repo-1", Url: "https://git.example.com/test/repo-1", Owner: &svc.User{Id: "user-123", FirstName: "Jane", LastName: "han", Age: 36}}}},
+	}
+	for _, m := range messages {
+		for _, name := range []string{"proto", codec.Fast, codec.JSON} {
+			c := encoding.GetCodec(name)
+			b.Run(m.name+"/"+name, func(b *testing.B) {
+				b.ReportAllocs()
+				decoded := m.msg.ProtoReflect().New().Interface()
+				for i := 0; i < b.N; i++ {
+					data, err := c.Marshal(m.msg)
+					if err == nil {
+						err = c.Unmarshal(data, decoded)
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	svc "github.com/calmw/grpc-service"
+	"github.com/calmw/grpc-service/compress"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// wireStats 客户端的stats.Handler，记录每个方法请求和回复使用的压缩器、最后发送的序列化后的消息，以及发送的消息压缩前后的字节数
+type wireStats struct {
+	mu       sync.Mutex
+	sent     map[string]string
+	recv     map[string]string
+	payloads map[string][]byte
+	length   int64
+	wire     int64
+}
+
+type wireMethodKey struct{}
+
+func newWireStats() *wireStats {
+	return &wireStats{sent: make(map[string]string), recv: make(map[string]string), payloads: make(map[string][]byte)}
+}
+
+func (ws *wireStats) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, wireMethodKey{}, info.FullMethodName)
+}
+
+func (ws *wireStats) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	method, _ := ctx.Value(wireMethodKey{}).(string)
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	switch s := s.(type) {
+	case *stats.OutHeader:
+		ws.sent[method] = s.Compression
+	case *stats.InHeader:
+		ws.recv[method] = s.Compression
+	case *stats.OutPayload:
+		ws.payloads[method] = s.Data
+		ws.length += int64(s.Length)
+		ws.wire += int64(s.WireLength)
+	}
+}
+
+func (ws *wireStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (ws *wireStats) HandleConn(context.Context, stats.ConnStats) {}
+
+func (ws *wireStats) compression(method string) (string, string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.sent[method], ws.recv[method]
+}
+
+// 类似源代码的可压缩数据，seed固定以便结果可以比较
+func repoData(n int) []byte {
+	r := rand.New(rand.NewSource(1))
+	words := []string{"func", "return", "err", "nil", "if", "ctx", "stream", "svc", "status", "codes", "log.Printf"}
+	var b bytes.Buffer
+	for b.Len() < n {
+		fmt.Fprintf(&b, "\t%s %s(%d) // %s\n", words[r.Intn(len(words))], words[r.Intn(len(words))], r.Intn(1000), words[r.Intn(len(words))])
+	}
+	return b.Bytes()[:n]
+}
+
+func TestCompressionPolicy(t *testing.T) {
+	err := compress.Register(compress.Gzip, compress.Zstd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &compress.Policy{
+		Methods: map[string]string{"/Repo/CreateRepo": compress.Zstd, "/Users/*": compress.Gzip},
+		MinSize: compress.DefaultMinSize,
+	}
+	ws := newWireStats()
+	ts := startTestServer(t,
+		grpc.WithChainUnaryInterceptor(p.UnaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(p.StreamClientInterceptor),
+		grpc.WithStatsHandler(ws),
+	)
+
+	// 小于MinSize的请求不压缩，回复也不压缩
+	_, err = ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane@doe.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent, recv := ws.compression("/Users/GetUser"); sent != "" || recv != "" {
+		t.Fatalf("small GetUser compressed: request %q, reply %q", sent, recv)
+	}
+
+	// 调用时指定的压缩器优先于策略，服务端以相同的压缩器压缩回复
+	_, err = ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane@doe.com"}, grpc.UseCompressor(compress.Zstd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent, recv := ws.compression("/Users/GetUser"); sent != compress.Zstd || recv != compress.Zstd {
+		t.Fatalf("unexpected GetUser compression: request %q, reply %q", sent, recv)
+	}
+
+	stream, err := ts.Users.GetHelp(context.Background())
+	if err == nil {
+		err = stream.Send(&svc.UserHelpRequest{Request: "hello"})
+	}
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.CloseSend()
+	if _, err = stream.Recv(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	if sent, recv := ws.compression("/Users/GetHelp"); sent != compress.Gzip || recv != compress.Gzip {
+		t.Fatalf("unexpected GetHelp compression: request %q, reply %q", sent, recv)
+	}
+
+	data := repoData(256 << 10)
+	repos, err := ts.Repo.CreateRepo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.mu.Lock()
+	ws.length, ws.wire = 0, 0
+	ws.mu.Unlock()
+	_, err = svc.CreateRepoFromReader(repos, &svc.RepoContext{CreatorId: "user-123", Name: "test-repo"}, bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent, _ := ws.compression("/Repo/CreateRepo"); sent != compress.Zstd {
+		t.Fatalf("unexpected CreateRepo compression: %q", sent)
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.wire*2 > ws.length {
+		t.Fatalf("CreateRepo poorly compressed: %d bytes on wire for %d bytes", ws.wire, ws.length)
+	}
+}
+
+func TestCompressionPolicyFromEnv(t *testing.T) {
+	t.Setenv("GRPC_COMPRESSION", "gzip")
+	t.Setenv("GRPC_COMPRESSION_METHODS", "/Repo/CreateRepo=zstd, /Users/GetUser=identity")
+	t.Setenv("GRPC_COMPRESS_MIN_SIZE", "0")
+	p, err := compress.PolicyFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Default != compress.Gzip || p.MinSize != 0 || len(p.Methods) != 2 || p.Methods["/Users/GetUser"] != "identity" {
+		t.Fatalf("unexpected policy: %+v", p)
+	}
+	if names := p.Names(); len(names) != 2 {
+		t.Fatalf("unexpected compressors: %v", names)
+	}
+
+	for env, want := range map[string]string{
+		"/Repo/CreateRepo=lz4": `unknown compressor "lz4", want one of gzip, zstd`,
+		"CreateRepo=zstd":      `invalid GRPC_COMPRESSION_METHODS: entry "CreateRepo=zstd", want /Service/Method=compressor`,
+	} {
+		t.Setenv("GRPC_COMPRESSION_METHODS", env)
+		_, err = compress.PolicyFromEnv()
+		if err == nil || err.Error() != want {
+			t.Fatalf("unexpected error for %s: %v", env, err)
+		}
+	}
+}
+
+// 比较不同压缩器下CreateRepo分块流的吞吐量和线上字节数，wire-ratio为压缩后与压缩前的字节数之比
+func BenchmarkCreateRepoCompression(b *testing.B) {
+	err := compress.Register(compress.Gzip, compress.Zstd)
+	if err != nil {
+		b.Fatal(err)
+	}
+	random := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(random)
+	for _, data := range []struct {
+		name string
+		data []byte
+	}{{"source", repoData(1 << 20)}, {"random", random}} {
+		for _, name := range []string{"identity", compress.Gzip, compress.Zstd} {
+			b.Run(data.name+"/"+name, func(b *testing.B) {
+				ws := newWireStats()
+				ts := startTestServer(b, grpc.WithStatsHandler(ws))
+				setLogLevel("info")
+				repoContext := &svc.RepoContext{CreatorId: "user-123", Name: "bench-repo"}
+				b.SetBytes(int64(len(data.data)))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					stream, err := ts.Repo.CreateRepo(context.Background(), grpc.UseCompressor(name))
+					if err != nil {
+						b.Fatal(err)
+					}
+					_, err = svc.CreateRepoFromReader(stream, repoContext, bytes.NewReader(data.data), nil)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.StopTimer()
+				ws.mu.Lock()
+				defer ws.mu.Unlock()
+				b.ReportMetric(float64(ws.wire)/float64(ws.length), "wire-ratio")
+			})
+		}
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	channelzservice "google.golang.org/grpc/channelz/service"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// debugServer 调试用的HTTP服务，提供pprof、channelz、进行中的RPC、流控统计、回复缓存统计和拦截器链配置，
+// 设置了环境变量DEBUG_LISTEN_ADDR时启用，不使用TLS也不鉴权，只应监听在本机或内网地址上
+type debugServer struct {
+	tracker  *callTracker
+	rec      *recorder
+	fi       *faultInjector
+	rc       *responseCache
+	sf       *coalescer
+	tc       *transportConfig
+	channelz channelzgrpc.ChannelzServer
+}
+
+// 调试页面直接调用channelz服务的实现，不需要经过网络
+type channelzRegistrar struct {
+	impl channelzgrpc.ChannelzServer
+}
+
+func (r *channelzRegistrar) RegisterService(_ *grpc.ServiceDesc, impl interface{}) {
+	r.impl = impl.(channelzgrpc.ChannelzServer)
+}
+
+func newDebugServer(tracker *callTracker, rec *recorder, fi *faultInjector, rc *responseCache, sf *coalescer, tc *transportConfig) *debugServer {
+	r := &channelzRegistrar{}
+	channelzservice.RegisterChannelzServiceToServer(r)
+	return &debugServer{tracker: tracker, rec: rec, fi: fi, rc: rc, sf: sf, tc: tc, channelz: r.impl}
+}
+
+func (ds *debugServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/channelz", ds.serveChannelz)
+	mux.HandleFunc("/debug/channelz/socket", ds.serveSocket)
+	mux.HandleFunc("/debug/rpcs", ds.serveRPCs)
+	mux.HandleFunc("/debug/flow", ds.serveFlow)
+	mux.HandleFunc("/debug/cache", ds.serveCache)
+	mux.HandleFunc("/debug/interceptors", ds.serveInterceptors)
+	mux.HandleFunc("/debug/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/debug/" {
+			http.NotFound(w, r)
+			return
+		}
+		render(w, indexTemplate, nil)
+	})
+	return mux
+}
+
+func startDebugServer(ds *debugServer, l net.Listener) *http.Server {
+	hs := &http.Server{Handler: ds.handler()}
+	go func() {
+		log.Printf("Debug HTTP listening on %s\n", l.Addr())
+		err := hs.Serve(l)
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("Debug server failed: %v", err)
+		}
+	}()
+	return hs
+}
+
+// 请求参数format=json或Accept为application/json时返回JSON
+func wantJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+func render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := t.Execute(w, data)
+	if err != nil {
+		log.Printf("Rendering debug page failed: %v", err)
+	}
+}
+
+// protojson编码的消息，以JSON返回时保持channelz的字段名
+func protoJSON(m proto.Message) json.RawMessage {
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		data, _ = json.Marshal(err.Error())
+	}
+	return data
+}
+
+type channelzServer struct {
+	Server  *channelzgrpc.Server
+	Sockets []*channelzgrpc.SocketRef
+}
+
+type channelzOverview struct {
+	Servers  []channelzServer
+	Channels []*channelzgrpc.Channel
+}
+
+func (ds *debugServer) channelzOverview(ctx context.Context) (*channelzOverview, error) {
+	o := &channelzOverview{}
+	var start int64
+	for {
+		resp, err := ds.channelz.GetServers(ctx, &channelzgrpc.GetServersRequest{StartServerId: start})
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range resp.Server {
+			sockets, err := ds.serverSockets(ctx, s.Ref.ServerId)
+			if err != nil {
+				return nil, err
+			}
+			o.Servers = append(o.Servers, channelzServer{Server: s, Sockets: sockets})
+			start = s.Ref.ServerId + 1
+		}
+		if resp.End || len(resp.Server) == 0 {
+			break
+		}
+	}
+	start = 0
+	for {
+		resp, err := ds.channelz.GetTopChannels(ctx, &channelzgrpc.GetTopChannelsRequest{StartChannelId: start})
+		if err != nil {
+			return nil, err
+		}
+		o.Channels = append(o.Channels, resp.Channel...)
+		if resp.End || len(resp.Channel) == 0 {
+			break
+		}
+		start = resp.Channel[len(resp.Channel)-1].Ref.ChannelId + 1
+	}
+	return o, nil
+}
+
+func (ds *debugServer) serverSockets(ctx context.Context, id int64) ([]*channelzgrpc.SocketRef, error) {
+	var sockets []*channelzgrpc.SocketRef
+	var start int64
+	for {
+		resp, err := ds.channelz.GetServerSockets(ctx, &channelzgrpc.GetServerSocketsRequest{ServerId: id, StartSocketId: start})
+		if err != nil {
+			return nil, err
+		}
+		sockets = append(sockets, resp.SocketRef...)
+		if resp.End || len(resp.SocketRef) == 0 {
+			return sockets, nil
+		}
+		start = resp.SocketRef[len(resp.SocketRef)-1].SocketId + 1
+	}
+}
+
+// 服务端（包括监听socket和连接socket）和客户端channel的概览
+func (ds *debugServer) serveChannelz(w http.ResponseWriter, r *http.Request) {
+	o, err := ds.channelzOverview(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !wantJSON(r) {
+		render(w, channelzTemplate, o)
+		return
+	}
+	type server struct {
+		Server  json.RawMessage   `json:"server"`
+		Sockets []json.RawMessage `json:"sockets"`
+	}
+	var result struct {
+		Servers  []server          `json:"servers"`
+		Channels []json.RawMessage `json:"channels"`
+	}
+	result.Servers = []server{}
+	result.Channels = []json.RawMessage{}
+	for _, s := range o.Servers {
+		js := server{Server: protoJSON(s.Server), Sockets: []json.RawMessage{}}
+		for _, ref := range s.Sockets {
+			js.Sockets = append(js.Sockets, protoJSON(ref))
+		}
+		result.Servers = append(result.Servers, js)
+	}
+	for _, c := range o.Channels {
+		result.Channels = append(result.Channels, protoJSON(c))
+	}
+	writeJSON(w, result)
+}
+
+// 单个socket的详细信息，包括流和消息计数、流控窗口和keepalive
+func (ds *debugServer) serveSocket(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid socket id", http.StatusBadRequest)
+		return
+	}
+	resp, err := ds.channelz.GetSocket(r.Context(), &channelzgrpc.GetSocketRequest{SocketId: id})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if wantJSON(r) {
+		writeJSON(w, protoJSON(resp.Socket))
+		return
+	}
+	data, _ := protojson.MarshalOptions{Multiline: true}.Marshal(resp.Socket)
+	render(w, socketTemplate, struct {
+		ID   int64
+		JSON string
+	}{id, string(data)})
+}
+
+type inFlightRPC struct {
+	ID         uint64       `json:"id"`
+	Method     string       `json:"method"`
+	Peer       string       `json:"peer"`
+	Streaming  bool         `json:"streaming"`
+	Start      string       `json:"start"`
+	DurationMs int64        `json:"duration_ms"`
+	Flow       flowSnapshot `json:"flow"`
+}
+
+// 进行中的RPC，按持续时间从长到短排列，卡住的流排在最前面
+func (ds *debugServer) serveRPCs(w http.ResponseWriter, r *http.Request) {
+	conns, calls := ds.tracker.snapshot()
+	peers := make(map[uint64]string)
+	for _, c := range conns {
+		peers[c.id] = addrString(c.remoteAddr)
+	}
+	rpcs := []inFlightRPC{}
+	now := time.Now()
+	for connID, cs := range calls {
+		for _, c := range cs {
+			rpcs = append(rpcs, inFlightRPC{
+				ID:         c.id,
+				Method:     c.method,
+				Peer:       peers[connID],
+				Streaming:  c.clientStream || c.serverStream,
+				Start:      c.start.Format(time.RFC3339Nano),
+				DurationMs: now.Sub(c.start).Milliseconds(),
+				Flow:       c.flow.snapshot(),
+			})
+		}
+	}
+	sort.Slice(rpcs, func(i, j int) bool {
+		if rpcs[i].DurationMs != rpcs[j].DurationMs {
+			return rpcs[i].DurationMs > rpcs[j].DurationMs
+		}
+		return rpcs[i].ID < rpcs[j].ID
+	})
+	if wantJSON(r) {
+		writeJSON(w, rpcs)
+		return
+	}
+	render(w, rpcsTemplate, rpcs)
+}
+
+type connectionFlow struct {
+	SocketID       int64  `json:"socket_id"`
+	Remote         string `json:"remote"`
+	StreamsStarted int64  `json:"streams_started"`
+	MessagesSent   int64  `json:"messages_sent"`
+	LocalWindow    int64  `json:"local_window"`  // 本端还能接收的字节数
+	RemoteWindow   int64  `json:"remote_window"` // 对端还能接收的字节数，接近0时发送被阻塞
+}
+
+type flowOverview struct {
+	Transport   *transportConfig `json:"transport"`
+	Methods     []methodFlow     `json:"methods"`
+	Connections []connectionFlow `json:"connections"`
+}
+
+// 传输层设置、按方法汇总的已结束流的发送统计，以及channelz中各连接当前的流控窗口
+func (ds *debugServer) serveFlow(w http.ResponseWriter, r *http.Request) {
+	o, err := ds.channelzOverview(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f := flowOverview{Transport: ds.tc, Methods: flows.list(), Connections: []connectionFlow{}}
+	if f.Transport == nil {
+		f.Transport = &transportConfig{}
+	}
+	for _, s := range o.Servers {
+		for _, ref := range s.Sockets {
+			resp, err := ds.channelz.GetSocket(r.Context(), &channelzgrpc.GetSocketRequest{SocketId: ref.SocketId})
+			if err != nil { // 连接已关闭
+				continue
+			}
+			data := resp.Socket.Data
+			f.Connections = append(f.Connections, connectionFlow{
+				SocketID:       ref.SocketId,
+				Remote:         ref.Name,
+				StreamsStarted: data.StreamsStarted,
+				MessagesSent:   data.MessagesSent,
+				LocalWindow:    data.LocalFlowControlWindow.GetValue(),
+				RemoteWindow:   data.RemoteFlowControlWindow.GetValue(),
+			})
+		}
+	}
+	if wantJSON(r) {
+		writeJSON(w, f)
+		return
+	}
+	render(w, flowTemplate, f)
+}
+
+// 回复缓存的配置、条目数和按方法统计的命中次数，未启用缓存时返回404
+func (ds *debugServer) serveCache(w http.ResponseWriter, r *http.Request) {
+	if ds.rc == nil {
+		http.Error(w, "response cache is disabled (CACHE_TTL=0)", http.StatusNotFound)
+		return
+	}
+	o := ds.rc.overview()
+	if wantJSON(r) {
+		writeJSON(w, o)
+		return
+	}
+	render(w, cacheTemplate, o)
+}
+
+type interceptorConfig struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Config  string `json:"config"`
+}
+
+// 拦截器链由外到内的顺序、是否开启（可以通过Admin.SetInterceptor修改）以及各自的配置
+func (ds *debugServer) serveInterceptors(w http.ResponseWriter, r *http.Request) {
+	var chain []interceptorConfig
+	for _, name := range interceptorChain {
+		c := interceptorConfig{Name: name, Enabled: !interceptors.canToggle(name) || interceptors.isEnabled(name)}
+		switch name {
+		case "flow-metrics":
+			if ds.tc != nil {
+				c.Config = fmt.Sprintf("compressors=%s window=%d conn_window=%d write_buffer=%d read_buffer=%d max_recv=%d max_send=%d (0 = grpc default)",
+					strings.Join(ds.tc.Compressors, ","), ds.tc.InitialWindowSize, ds.tc.InitialConnWindowSize, ds.tc.WriteBufferSize, ds.tc.ReadBufferSize, ds.tc.MaxRecvMsgSize, ds.tc.MaxSendMsgSize)
+			}
+		case "logging":
+			c.Config = "level=" + logLevelNames[atomic.LoadInt32(&logLevel)]
+		case "recording":
+			if ds.rec == nil {
+				c.Config = "RECORD_DIR not set"
+			} else {
+				c.Config = fmt.Sprintf("dir=%s max_size=%d max_files=%d", ds.rec.dir, ds.rec.maxSize, ds.rec.maxFiles)
+			}
+		case "timeout":
+			c.Config = fmt.Sprintf("unary=%v stream_recv=%v", UnaryTimeout, RecvMsgTimeout)
+		case "cache":
+			c.Config = ds.rc.String() + " (unary only)"
+		case "singleflight":
+			c.Config = ds.sf.String() + " (unary only)"
+		case "fault-injection":
+			if ds.fi == nil {
+				c.Config = "FAULT_CONFIG not set"
+			} else {
+				c.Config = fmt.Sprintf("config=%s rules=%d", ds.fi.path, ds.fi.ruleCount())
+			}
+		}
+		chain = append(chain, c)
+	}
+	if wantJSON(r) {
+		writeJSON(w, chain)
+		return
+	}
+	render(w, interceptorsTemplate, chain)
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<html><head><title>debug</title></head><body>
+<h1>debug</h1>
+<ul>
+<li><a href="/debug/pprof/">pprof</a></li>
+<li><a href="/debug/channelz">channelz</a> (<a href="/debug/channelz?format=json">json</a>)</li>
+<li><a href="/debug/rpcs">in-flight RPCs</a> (<a href="/debug/rpcs?format=json">json</a>)</li>
+<li><a href="/debug/flow">flow control</a> (<a href="/debug/flow?format=json">json</a>)</li>
+<li><a href="/debug/cache">response cache</a> (<a href="/debug/cache?format=json">json</a>)</li>
+<li><a href="/debug/interceptors">interceptor chain</a> (<a href="/debug/interceptors?format=json">json</a>)</li>
+</ul>
+</body></html>
+`))
+
+var channelzTemplate = template.Must(template.New("channelz").Parse(`<html><head><title>channelz</title></head><body>
+<h1>Servers</h1>
+{{range .Servers}}
+<h2>Server {{.Server.Ref.ServerId}} {{.Server.Ref.Name}}</h2>
+<p>calls started: {{.Server.Data.CallsStarted}}, succeeded: {{.Server.Data.CallsSucceeded}}, failed: {{.Server.Data.CallsFailed}}</p>
+<p>listen sockets: {{range .Server.ListenSocket}}<a href="/debug/channelz/socket?id={{.SocketId}}">{{.SocketId}} {{.Name}}</a> {{end}}</p>
+<table border="1"><tr><th>socket</th><th>name</th></tr>
+{{range .Sockets}}<tr><td><a href="/debug/channelz/socket?id={{.SocketId}}">{{.SocketId}}</a></td><td>{{.Name}}</td></tr>
+{{end}}</table>
+{{end}}
+<h1>Channels</h1>
+<table border="1"><tr><th>channel</th><th>target</th><th>state</th><th>calls started</th><th>succeeded</th><th>failed</th></tr>
+{{range .Channels}}<tr><td>{{.Ref.ChannelId}}</td><td>{{.Data.Target}}</td><td>{{.Data.State.State}}</td><td>{{.Data.CallsStarted}}</td><td>{{.Data.CallsSucceeded}}</td><td>{{.Data.CallsFailed}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+var socketTemplate = template.Must(template.New("socket").Parse(`<html><head><title>socket {{.ID}}</title></head><body>
+<h1>Socket {{.ID}}</h1>
+<pre>{{.JSON}}</pre>
+</body></html>
+`))
+
+var rpcsTemplate = template.Must(template.New("rpcs").Parse(`<html><head><title>in-flight RPCs</title></head><body>
+<h1>In-flight RPCs</h1>
+<table border="1"><tr><th>id</th><th>method</th><th>peer</th><th>streaming</th><th>start</th><th>duration (ms)</th><th>send flow</th></tr>
+{{range .}}<tr><td>{{.ID}}</td><td>{{.Method}}</td><td>{{.Peer}}</td><td>{{.Streaming}}</td><td>{{.Start}}</td><td>{{.DurationMs}}</td><td>{{if .Streaming}}{{.Flow}}{{end}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+var flowTemplate = template.Must(template.New("flow").Parse(`<html><head><title>flow control</title></head><body>
+<h1>Transport settings</h1>
+<p>compressors: {{range .Transport.Compressors}}{{.}} {{else}}none{{end}}, initial window: {{.Transport.InitialWindowSize}}, initial conn window: {{.Transport.InitialConnWindowSize}},
+write buffer: {{.Transport.WriteBufferSize}}, read buffer: {{.Transport.ReadBufferSize}},
+max recv msg: {{.Transport.MaxRecvMsgSize}}, max send msg: {{.Transport.MaxSendMsgSize}} (0 = grpc default)</p>
+<h1>Completed streams by method</h1>
+<table border="1"><tr><th>method</th><th>streams</th><th>messages sent</th><th>bytes</th><th>blocked (ms)</th><th>max blocked (ms)</th></tr>
+{{range .Methods}}<tr><td>{{.Method}}</td><td>{{.Streams}}</td><td>{{.Sends}}</td><td>{{.Bytes}}</td><td>{{printf "%.3f" .BlockedMs}}</td><td>{{printf "%.3f" .MaxBlockedMs}}</td></tr>
+{{end}}</table>
+<h1>Connections</h1>
+<table border="1"><tr><th>socket</th><th>remote</th><th>streams started</th><th>messages sent</th><th>local window</th><th>remote window</th></tr>
+{{range .Connections}}<tr><td><a href="/debug/channelz/socket?id={{.SocketID}}">{{.SocketID}}</a></td><td>{{.Remote}}</td><td>{{.StreamsStarted}}</td><td>{{.MessagesSent}}</td><td>{{.LocalWindow}}</td><td>{{.RemoteWindow}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+var cacheTemplate = template.Must(template.New("cache").Parse(`<html><head><title>response cache</title></head><body>
+<h1>Response cache</h1>
+<p>ttl: {{.TTL}}, entries: {{.Entries}} / {{.MaxEntries}}, evicted: {{.Evicted}}, expired: {{.Expired}}, invalidated: {{.Invalidated}}</p>
+<table border="1"><tr><th>method</th><th>hits</th><th>misses</th><th>bypass</th></tr>
+{{range .Methods}}<tr><td>{{.Method}}</td><td>{{.Hits}}</td><td>{{.Misses}}</td><td>{{.Bypass}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+var interceptorsTemplate = template.Must(template.New("interceptors").Parse(`<html><head><title>interceptor chain</title></head><body>
+<h1>Interceptor chain (outermost first)</h1>
+<table border="1"><tr><th>name</th><th>enabled</th><th>config</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Enabled}}</td><td>{{.Config}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	svc "github.com/calmw/grpc-service"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 请求调试页面，返回状态码和响应体
+func (ts *testServer) getDebug(t *testing.T, path string) (int, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	newDebugServer(ts.tracker, nil, ts.faults, ts.cache, ts.flights, &transportConfig{}).handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w.Code, w.Body.String()
+}
+
+func TestDebugRPCs(t *testing.T) {
+	ts := startTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := ts.Repo.GetRepos(ctx, &svc.RepoGetRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, body := ts.getDebug(t, "/debug/rpcs?format=json")
+	var rpcs []inFlightRPC
+	if code != http.StatusOK || json.Unmarshal([]byte(body), &rpcs) != nil {
+		t.Fatalf("unexpected response %d: %s", code, body)
+	}
+	if len(rpcs) != 1 || rpcs[0].Method != "/Repo/GetRepos" || !rpcs[0].Streaming {
+		t.Fatalf("unexpected in-flight RPCs: %s", body)
+	}
+	_, body = ts.getDebug(t, "/debug/rpcs")
+	if !strings.Contains(body, "/Repo/GetRepos") {
+		t.Fatalf("GetRepos missing from html page: %s", body)
+	}
+}
+
+func TestDebugChannelz(t *testing.T) {
+	ts := startTestServer(t)
+	_, err := ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane@doe.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, body := ts.getDebug(t, "/debug/channelz?format=json")
+	var o struct {
+		Servers []struct {
+			Sockets []struct {
+				SocketID string `json:"socketId"`
+			} `json:"sockets"`
+		} `json:"servers"`
+	}
+	if code != http.StatusOK || json.Unmarshal([]byte(body), &o) != nil || len(o.Servers) == 0 {
+		t.Fatalf("unexpected response %d: %s", code, body)
+	}
+	var socket string
+	for _, s := range o.Servers {
+		for _, sock := range s.Sockets {
+			socket = sock.SocketID
+		}
+	}
+	if socket == "" {
+		t.Fatalf("no server sockets: %s", body)
+	}
+	code, body = ts.getDebug(t, "/debug/channelz/socket?id="+socket)
+	if code != http.StatusOK || !strings.Contains(body, "streamsStarted") {
+		t.Fatalf("unexpected socket page %d: %s", code, body)
+	}
+}
+
+func TestDebugInterceptors(t *testing.T) {
+	ts := startTestServer(t)
+	ts.injectFaults(faultRule{Method: "/Users/GetUser", Panic: true})
+	interceptors.set("logging", false)
+	_, body := ts.getDebug(t, "/debug/interceptors?format=json")
+	var chain []interceptorConfig
+	err := json.Unmarshal([]byte(body), &chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range chain {
+		names = append(names, c.Name)
+		if c.Enabled != (c.Name != "logging") {
+			t.Fatalf("unexpected state of %s: %s", c.Name, body)
+		}
+	}
+	if strings.Join(names, ",") != "flow-metrics,logging,recording,timeout,panic,validation,fault-injection,cache,singleflight" {
+		t.Fatalf("unexpected chain order: %v", names)
+	}
+	if chain[6].Config != "config= rules=1" {
+		t.Fatalf("unexpected fault-injection config: %q", chain[6].Config)
+	}
+}
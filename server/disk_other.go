@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+// 当前平台是否支持获取磁盘剩余空间
+const diskFreeSupported = false
+
+// 不支持的平台上总是返回错误，setupHealthManager不会注册依赖它的探针
+func diskFree(path string) (uint64, error) {
+	return 0, errors.New("disk space probe is not supported on " + runtime.GOOS)
+}
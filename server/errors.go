@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"log"
+	"strings"
+	"time"
+)
+
+// 错误详情ErrorInfo中的domain，标识产生错误的服务
+const ErrorDomain = "grpc-service.calmw.github.com"
+
+// 可重试的错误在RetryInfo中建议客户端等待的时间
+const RetryDelay = time.Millisecond * 100
+
+// 客户端未通过元数据accept-language指定语言时，LocalizedMessage使用的语言
+const DefaultLocale = "en-US"
+
+// 附加错误详情，失败时返回不带详情的错误
+func withDetails(s *status.Status, details ...protoiface.MessageV1) error {
+	ds, err := s.WithDetails(details...)
+	if err != nil {
+		log.Printf("Adding error details failed: %v", err)
+		return s.Err()
+	}
+	return ds.Err()
+}
+
+func errorInfo(reason string, md map[string]string) *errdetails.ErrorInfo {
+	return &errdetails.ErrorInfo{Reason: reason, Domain: ErrorDomain, Metadata: md}
+}
+
+func retryInfo(d time.Duration) *errdetails.RetryInfo {
+	return &errdetails.RetryInfo{RetryDelay: durationpb.New(d)}
+}
+
+// 按客户端元数据accept-language选择本地化的消息，messages以语言为键，例如 zh-CN，找不到时使用DefaultLocale
+func localizedMessage(ctx context.Context, messages map[string]string) *errdetails.LocalizedMessage {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("accept-language") {
+		for _, lang := range strings.Split(v, ",") {
+			lang = strings.TrimSpace(strings.SplitN(lang, ";", 2)[0])
+			for locale, msg := range messages {
+				if strings.EqualFold(locale, lang) || strings.EqualFold(strings.SplitN(locale, "-", 2)[0], lang) {
+					return &errdetails.LocalizedMessage{Locale: locale, Message: msg}
+				}
+			}
+		}
+	}
+	return &errdetails.LocalizedMessage{Locale: DefaultLocale, Message: messages[DefaultLocale]}
+}
+
+// 请求字段无效，返回携带BadRequest、ErrorInfo和LocalizedMessage详情的InvalidArgument错误
+func invalidFieldError(
+	ctx context.Context,
+	msg, field, reason string,
+	localized map[string]string,
+) error {
+	return withDetails(
+		status.New(codes.InvalidArgument, msg),
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: field, Description: localized[DefaultLocale]},
+		}},
+		errorInfo(reason, map[string]string{"field": field}),
+		localizedMessage(ctx, localized),
+	)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 检查故障注入配置文件是否有变化的间隔
+const FaultConfigPollInterval = time.Second * 2
+
+// faultRule 一条故障注入规则，依次执行：延迟、panic、返回状态码或在流收发若干条消息后中断流
+type faultRule struct {
+	Method      string            `json:"method"`      // 完整方法名，例如 /Users/GetUser，/Users/* 匹配服务的所有方法，为空匹配所有方法
+	Metadata    map[string]string `json:"metadata"`    // 只对携带这些元数据的请求生效，例如 {"x-chaos": "on"}
+	Probability float64           `json:"probability"` // 生效的概率，0或不设置表示总是生效
+	Delay       faultDelay        `json:"delay"`       // 例如 "200ms"
+	Panic       bool              `json:"panic"`
+	Code        codes.Code        `json:"code"` // 例如 "UNAVAILABLE"，设置了AbortAfter时为流中断的状态码
+	Message     string            `json:"message"`
+	AbortAfter  int               `json:"abort_after"` // 流收发这么多条消息后中断，状态码默认为ABORTED
+}
+
+type faultDelay time.Duration
+
+func (d *faultDelay) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = faultDelay(v)
+	return nil
+}
+
+func (r *faultRule) matches(ctx context.Context, method string) bool {
+	switch {
+	case len(r.Method) == 0:
+	case strings.HasSuffix(r.Method, "/*"):
+		if !strings.HasPrefix(method, strings.TrimSuffix(r.Method, "*")) {
+			return false
+		}
+	case r.Method != method:
+		return false
+	}
+	if len(r.Metadata) == 0 {
+		return true
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, v := range r.Metadata {
+		found := false
+		for _, got := range md.Get(k) {
+			if got == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *faultRule) err(def codes.Code) error {
+	code := r.Code
+	if code == codes.OK {
+		code = def
+	}
+	msg := r.Message
+	if len(msg) == 0 {
+		msg = "Injected fault"
+	}
+	return status.Error(code, msg)
+}
+
+// faultInjector 按规则向RPC调用注入延迟、错误、流中断或panic，用于在不停止服务端的情况下测试客户端的重试和重建流逻辑，
+// 规则从环境变量FAULT_CONFIG指定的JSON文件读取，文件变化后自动重新加载，为nil时不注入故障
+type faultInjector struct {
+	path    string
+	modTime time.Time
+
+	mu    sync.Mutex
+	rules []faultRule
+	rand  *rand.Rand
+}
+
+func newFaultInjector() *faultInjector {
+	return &faultInjector{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// 设置了环境变量FAULT_CONFIG时启用故障注入，配置文件格式见docs/消息.md
+func setupFaultInjector() (*faultInjector, error) {
+	path, ok := os.LookupEnv("FAULT_CONFIG")
+	if !ok {
+		return nil, nil
+	}
+	fi := newFaultInjector()
+	fi.path = path
+	_, err := fi.reload()
+	if err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+// 替换所有规则，规则按顺序匹配，第一条匹配的规则生效，按概率未生效时继续匹配后面的规则
+func (fi *faultInjector) set(rules []faultRule) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.rules = rules
+}
+
+func (fi *faultInjector) ruleCount() int {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return len(fi.rules)
+}
+
+// 配置文件有变化时重新加载，返回是否重新加载了规则
+func (fi *faultInjector) reload() (bool, error) {
+	info, err := os.Stat(fi.path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(fi.modTime) {
+		return false, nil
+	}
+	data, err := os.ReadFile(fi.path)
+	if err != nil {
+		return false, err
+	}
+	var config struct {
+		Rules []faultRule `json:"rules"`
+	}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return false, fmt.Errorf("invalid fault config %s: %v", fi.path, err)
+	}
+	fi.modTime = info.ModTime()
+	fi.set(config.Rules)
+	return true, nil
+}
+
+// 定期检查配置文件，加载失败时保留原来的规则，直到stop被关闭
+func (fi *faultInjector) watch(stop <-chan struct{}) {
+	if fi == nil {
+		return
+	}
+	t := time.NewTicker(FaultConfigPollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		reloaded, err := fi.reload()
+		if err != nil {
+			log.Printf("Reloading fault config failed: %v", err)
+			continue
+		}
+		if reloaded {
+			log.Printf("Fault config reloaded from %s", fi.path)
+		}
+	}
+}
+
+// 返回对本次调用生效的规则，没有时返回nil
+func (fi *faultInjector) pick(ctx context.Context, method string) *faultRule {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	for i := range fi.rules {
+		r := fi.rules[i]
+		if !r.matches(ctx, method) {
+			continue
+		}
+		if r.Probability > 0 && fi.rand.Float64() >= r.Probability { // 本次不生效，继续匹配后面的规则
+			continue
+		}
+		return &r
+	}
+	return nil
+}
+
+// 执行延迟和panic
+func (r *faultRule) apply(ctx context.Context, method string) {
+	log.Printf("Injecting fault into %s", method)
+	if r.Delay > 0 {
+		t := time.NewTimer(time.Duration(r.Delay))
+		select {
+		case <-ctx.Done():
+		case <-t.C:
+		}
+		t.Stop()
+	}
+	if r.Panic {
+		panic("Injected fault")
+	}
+}
+
+// 服务端，一元故障注入拦截器，位于拦截器链的最内层，注入的panic和延迟会经过panic和超时处理
+func (fi *faultInjector) unaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if fi == nil || !interceptors.isEnabled("fault-injection") {
+		return handler(ctx, req)
+	}
+	r := fi.pick(ctx, info.FullMethod)
+	if r == nil {
+		return handler(ctx, req)
+	}
+	r.apply(ctx, info.FullMethod)
+	if r.Code != codes.OK {
+		return nil, r.err(r.Code)
+	}
+	return handler(ctx, req)
+}
+
+// 服务端，流故障注入拦截器，设置了AbortAfter时通过wrappedServerStream在收发若干条消息后中断流
+func (fi *faultInjector) streamInterceptor(
+	srv interface{},
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if fi == nil || !interceptors.isEnabled("fault-injection") {
+		return handler(srv, stream)
+	}
+	r := fi.pick(stream.Context(), info.FullMethod)
+	if r == nil {
+		return handler(srv, stream)
+	}
+	r.apply(stream.Context(), info.FullMethod)
+	if r.AbortAfter > 0 {
+		abort := &streamAbort{remaining: r.AbortAfter, err: r.err(codes.Aborted)}
+		return handler(srv, wrappedServerStream{ServerStream: stream, RecvMsgTimeout: RecvMsgTimeout, abort: abort})
+	}
+	if r.Code != codes.OK {
+		return r.err(r.Code)
+	}
+	return handler(srv, stream)
+}
+
+// streamAbort 流收发remaining条消息后，之后的SendMsg和RecvMsg都返回err
+type streamAbort struct {
+	mu        sync.Mutex
+	remaining int
+	err       error
+}
+
+func (a *streamAbort) next() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.remaining <= 0 {
+		return a.err
+	}
+	a.remaining--
+	return nil
+}
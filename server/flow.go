@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/calmw/grpc-service/compress"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// transportConfig 传输层的流量控制窗口、缓冲区、消息大小和压缩器的设置，来自环境变量，为0时使用grpc的默认值
+type transportConfig struct {
+	Compressors           []string `json:"compressors"`              // GRPC_COMPRESSORS，逗号分隔，例如 gzip,zstd，默认不注册压缩器
+	InitialWindowSize     int      `json:"initial_window_size"`      // GRPC_INITIAL_WINDOW_SIZE，每个流的接收窗口，设置后不再根据BDP动态调整
+	InitialConnWindowSize int      `json:"initial_conn_window_size"` // GRPC_INITIAL_CONN_WINDOW_SIZE，每个连接的接收窗口
+	WriteBufferSize       int      `json:"write_buffer_size"`        // GRPC_WRITE_BUFFER_SIZE，写入socket前合并数据的缓冲区
+	ReadBufferSize        int      `json:"read_buffer_size"`         // GRPC_READ_BUFFER_SIZE
+	MaxRecvMsgSize        int      `json:"max_recv_msg_size"`        // GRPC_MAX_RECV_MSG_SIZE，默认4MB
+	MaxSendMsgSize        int      `json:"max_send_msg_size"`        // GRPC_MAX_SEND_MSG_SIZE
+}
+
+func setupTransportConfig() (*transportConfig, error) {
+	compressors, err := compress.ParseList(os.Getenv("GRPC_COMPRESSORS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GRPC_COMPRESSORS: %v", err)
+	}
+	tc := &transportConfig{Compressors: compressors}
+	for _, s := range []struct {
+		key string
+		v   *int
+	}{
+		{"GRPC_INITIAL_WINDOW_SIZE", &tc.InitialWindowSize},
+		{"GRPC_INITIAL_CONN_WINDOW_SIZE", &tc.InitialConnWindowSize},
+		{"GRPC_WRITE_BUFFER_SIZE", &tc.WriteBufferSize},
+		{"GRPC_READ_BUFFER_SIZE", &tc.ReadBufferSize},
+		{"GRPC_MAX_RECV_MSG_SIZE", &tc.MaxRecvMsgSize},
+		{"GRPC_MAX_SEND_MSG_SIZE", &tc.MaxSendMsgSize},
+	} {
+		n, err := intFromEnv(s.key, 0)
+		if err != nil {
+			return nil, err
+		}
+		*s.v = n
+	}
+	return tc, nil
+}
+
+// 只包含设置了的选项，grpc会忽略小于64KB的窗口
+func (tc *transportConfig) serverOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if tc.InitialWindowSize > 0 {
+		opts = append(opts, grpc.InitialWindowSize(int32(tc.InitialWindowSize)))
+	}
+	if tc.InitialConnWindowSize > 0 {
+		opts = append(opts, grpc.InitialConnWindowSize(int32(tc.InitialConnWindowSize)))
+	}
+	if tc.WriteBufferSize > 0 {
+		opts = append(opts, grpc.WriteBufferSize(tc.WriteBufferSize))
+	}
+	if tc.ReadBufferSize > 0 {
+		opts = append(opts, grpc.ReadBufferSize(tc.ReadBufferSize))
+	}
+	if tc.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(tc.MaxRecvMsgSize))
+	}
+	if tc.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(tc.MaxSendMsgSize))
+	}
+	return opts
+}
+
+// flowStats 一个流的发送统计。SendMsg在流的发送配额或连接的流控窗口用完时阻塞，
+// 阻塞时间反映了对端或网络的背压
+type flowStats struct {
+	sends      int64
+	bytes      int64
+	blocked    int64 // 纳秒
+	maxBlocked int64
+}
+
+type flowSnapshot struct {
+	Sends        int64   `json:"sends"`
+	Bytes        int64   `json:"bytes"`
+	BlockedMs    float64 `json:"blocked_ms"`
+	MaxBlockedMs float64 `json:"max_blocked_ms"`
+}
+
+type flowStatsKey struct{}
+
+// 由callTracker在TagRPC时放入context，调试页面可以看到进行中的流的统计
+func flowStatsFromContext(ctx context.Context) *flowStats {
+	f, _ := ctx.Value(flowStatsKey{}).(*flowStats)
+	return f
+}
+
+func (f *flowStats) end(start time.Time, m interface{}) {
+	d := int64(time.Since(start))
+	atomic.AddInt64(&f.sends, 1)
+	atomic.AddInt64(&f.blocked, d)
+	storeMax64(&f.maxBlocked, d)
+	if pm, ok := m.(proto.Message); ok {
+		atomic.AddInt64(&f.bytes, int64(proto.Size(pm)))
+	}
+}
+
+func (f *flowStats) snapshot() flowSnapshot {
+	return flowSnapshot{
+		Sends:        atomic.LoadInt64(&f.sends),
+		Bytes:        atomic.LoadInt64(&f.bytes),
+		BlockedMs:    milliseconds(atomic.LoadInt64(&f.blocked)),
+		MaxBlockedMs: milliseconds(atomic.LoadInt64(&f.maxBlocked)),
+	}
+}
+
+func (s flowSnapshot) String() string {
+	return fmt.Sprintf("sent %d messages (%d bytes), blocked %.3fms (max %.3fms)",
+		s.Sends, s.Bytes, s.BlockedMs, s.MaxBlockedMs)
+}
+
+func milliseconds(ns int64) float64 {
+	return float64(ns) / float64(time.Millisecond)
+}
+
+func storeMax64(addr *int64, v int64) {
+	for {
+		old := atomic.LoadInt64(addr)
+		if v <= old || atomic.CompareAndSwapInt64(addr, old, v) {
+			return
+		}
+	}
+}
+
+// flowMetrics 按方法汇总已结束的流的发送统计，用于调整窗口和缓冲区的大小
+type flowMetrics struct {
+	mu      sync.Mutex
+	methods map[string]*methodFlow
+}
+
+type methodFlow struct {
+	Method       string  `json:"method"`
+	Streams      int64   `json:"streams"`
+	Sends        int64   `json:"sends"`
+	Bytes        int64   `json:"bytes"`
+	BlockedMs    float64 `json:"blocked_ms"`
+	MaxBlockedMs float64 `json:"max_blocked_ms"`
+}
+
+var flows = newFlowMetrics()
+
+func newFlowMetrics() *flowMetrics {
+	return &flowMetrics{methods: make(map[string]*methodFlow)}
+}
+
+func (fm *flowMetrics) add(method string, s flowSnapshot) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	m, ok := fm.methods[method]
+	if !ok {
+		m = &methodFlow{Method: method}
+		fm.methods[method] = m
+	}
+	m.Streams++
+	m.Sends += s.Sends
+	m.Bytes += s.Bytes
+	m.BlockedMs += s.BlockedMs
+	if s.MaxBlockedMs > m.MaxBlockedMs {
+		m.MaxBlockedMs = s.MaxBlockedMs
+	}
+}
+
+// 按方法名排序
+func (fm *flowMetrics) list() []methodFlow {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	methods := make([]methodFlow, 0, len(fm.methods))
+	for _, m := range fm.methods {
+		methods = append(methods, *m)
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Method < methods[j].Method })
+	return methods
+}
+
+func (fm *flowMetrics) reset() {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fm.methods = make(map[string]*methodFlow)
+}
+
+// 服务端，流发送统计拦截器，位于拦截器链的最外层，测量的阻塞时间最接近传输层。
+// 流结束后将统计计入flows，debug日志级别下输出每个流的统计
+func flowStreamInterceptor(
+	srv interface{},
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	f := flowStatsFromContext(stream.Context())
+	if f == nil { // 未注册callTracker
+		f = &flowStats{}
+	}
+	err := handler(srv, flowServerStream{ServerStream: stream, stats: f})
+	s := f.snapshot()
+	if s.Sends > 0 {
+		flows.add(info.FullMethod, s)
+		debugf("Stream %s: %v", info.FullMethod, s)
+	}
+	return err
+}
+
+type flowServerStream struct {
+	grpc.ServerStream
+	stats *flowStats
+}
+
+func (s flowServerStream) SendMsg(m interface{}) error {
+	start := time.Now()
+	err := s.ServerStream.SendMsg(m)
+	s.stats.end(start, m)
+	return err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	svc "github.com/calmw/grpc-service"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTransportConfigFromEnv(t *testing.T) {
+	t.Setenv("GRPC_INITIAL_WINDOW_SIZE", "1048576")
+	t.Setenv("GRPC_MAX_RECV_MSG_SIZE", "8388608")
+	t.Setenv("GRPC_COMPRESSORS", "gzip, zstd")
+	tc, err := setupTransportConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.InitialWindowSize != 1<<20 || tc.MaxRecvMsgSize != 8<<20 || tc.WriteBufferSize != 0 || len(tc.Compressors) != 2 {
+		t.Fatalf("unexpected config: %+v", tc)
+	}
+	if n := len(tc.serverOptions()); n != 2 {
+		t.Fatalf("expected 2 server options, got %d", n)
+	}
+
+	t.Setenv("GRPC_COMPRESSORS", "snappy")
+	_, err = setupTransportConfig()
+	if err == nil || err.Error() != `invalid GRPC_COMPRESSORS: unknown compressor "snappy", want one of gzip, zstd` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Setenv("GRPC_COMPRESSORS", "")
+	t.Setenv("GRPC_WRITE_BUFFER_SIZE", "big")
+	_, err = setupTransportConfig()
+	if err == nil || err.Error() != "invalid GRPC_WRITE_BUFFER_SIZE: big" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestFlowMetrics(t *testing.T) {
+	ts := startTestServer(t)
+	stream, err := ts.Users.GetHelp(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []string{"hello", "world", "again"} {
+		err = stream.Send(&svc.UserHelpRequest{Request: r})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 进行中的流在/debug/rpcs中带有发送统计
+	_, body := ts.getDebug(t, "/debug/rpcs?format=json")
+	var rpcs []inFlightRPC
+	err = json.Unmarshal([]byte(body), &rpcs)
+	if err != nil || len(rpcs) != 1 || rpcs[0].Flow.Sends != 3 || rpcs[0].Flow.Bytes == 0 {
+		t.Fatalf("unexpected in-flight RPCs: %s", body)
+	}
+
+	stream.CloseSend()
+	_, err = stream.Recv()
+	if err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	ts.assertLogged(t, `Stream /Users/GetHelp: sent 3 messages \(\d+ bytes\), blocked [\d.]+ms \(max [\d.]+ms\)\n`)
+
+	code, body := ts.getDebug(t, "/debug/flow?format=json")
+	var f flowOverview
+	if code != http.StatusOK || json.Unmarshal([]byte(body), &f) != nil {
+		t.Fatalf("unexpected response %d: %s", code, body)
+	}
+	if len(f.Methods) != 1 || f.Methods[0].Method != "/Users/GetHelp" || f.Methods[0].Streams != 1 || f.Methods[0].Sends != 3 {
+		t.Fatalf("unexpected method totals: %s", body)
+	}
+	if len(f.Connections) == 0 || f.Connections[0].RemoteWindow <= 0 {
+		t.Fatalf("unexpected connections: %s", body)
+	}
+	_, body = ts.getDebug(t, "/debug/flow")
+	if !strings.Contains(body, "/Users/GetHelp") {
+		t.Fatalf("GetHelp missing from html page: %s", body)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthsvc "google.golang.org/grpc/health"
+	healthz "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bufconn监听器的缓冲区大小
+const testBufSize = 1 << 20
+
+// 等待日志出现的最长时间
+const testLogWait = time.Second
+
+// 测试服务端的ADMIN_TOKEN
+const testAdminToken = "test-admin-token"
+
+// testServer 在进程内通过bufconn启动完整的服务端：registerServices注册的所有服务、
+// 与main相同的拦截器链和健康检查，并返回可以直接使用的客户端，opts为客户端连接的额外选项
+type testServer struct {
+	Users  svc.UsersClient
+	Repo   svc.RepoClient
+	Health healthz.HealthClient
+	Admin  svc.AdminClient
+	conn   *grpc.ClientConn
+	server *grpc.Server
+
+	health  *healthsvc.Server
+	hm      *healthManager
+	drainer *drainer
+	trigger *shutdownTrigger
+	tracker *callTracker
+	faults  *faultInjector
+	cache   *responseCache
+	flights *coalescer
+	logs    *logBuffer
+}
+
+func startTestServer(t testing.TB, opts ...grpc.DialOption) *testServer {
+	t.Helper()
+	t.Setenv("STORAGE_DIR", t.TempDir())
+	logs := captureLogs(t)
+	resetRuntimeConfig(t)
+
+	faults := newFaultInjector()
+	tracker := newCallTracker()
+	cache, err := setupResponseCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := newValidator(protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flights := setupCoalescer()
+	s := newServer(nil, v, faults, cache, flights, grpc.StatsHandler(tracker))
+	h := healthsvc.NewServer()
+	d := newDrainer()
+	t.Cleanup(d.start) // 停止registerServices启动的会话清理
+	trigger := newShutdownTrigger()
+	rs, err := setupRepoStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hm, err := setupHealthManager(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerServices(s, h, d, rs, cache, newAdminService(testAdminToken, hm, tracker, trigger, cache))
+	hm.check()
+
+	lis := bufconn.Listen(testBufSize)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(
+		ctx,
+		"bufnet",
+		append([]grpc.DialOption{
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return lis.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithBlock(),
+		}, opts...)...,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &testServer{
+		Users:   svc.NewUsersClient(conn),
+		Repo:    svc.NewRepoClient(conn),
+		Health:  healthz.NewHealthClient(conn),
+		Admin:   svc.NewAdminClient(conn),
+		conn:    conn,
+		server:  s,
+		health:  h,
+		hm:      hm,
+		drainer: d,
+		trigger: trigger,
+		tracker: tracker,
+		faults:  faults,
+		cache:   cache,
+		flights: flights,
+		logs:    logs,
+	}
+}
+
+// 设置服务的健康状态，service为""时设置服务端的整体状态
+func (ts *testServer) setHealth(service string, status healthz.HealthCheckResponse_ServingStatus) {
+	updateServiceHealth(ts.health, service, status)
+}
+
+// 与main相同，按HEALTH_CHECK_INTERVAL定时执行健康检查探针，测试结束时停止
+func (ts *testServer) runHealthManager(t *testing.T) {
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	go ts.hm.run(stop)
+}
+
+// 设置故障注入规则，与服务端FAULT_CONFIG配置文件中的规则相同，在拦截器链的最内层生效，会经过超时和panic处理
+func (ts *testServer) injectFaults(rules ...faultRule) {
+	ts.faults.set(rules)
+}
+
+// 返回携带Admin服务token的context
+func adminContext() context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+testAdminToken)
+}
+
+// 断言拦截器等输出的日志中出现匹配pattern的行，日志在RPC返回客户端后才可能写入，因此会等待一段时间
+func (ts *testServer) assertLogged(t *testing.T, pattern string) {
+	t.Helper()
+	re := regexp.MustCompile(pattern)
+	deadline := time.Now().Add(testLogWait)
+	for {
+		if re.MatchString(ts.logs.String()) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no log line matches %q, logs:\n%s", pattern, ts.logs.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 等待cond成立，最多等待testLogWait
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testLogWait)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// logBuffer 收集测试期间log包输出的日志
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// 日志级别和拦截器开关是全局的，可以通过Admin服务修改，测试结束后恢复默认值
+func resetRuntimeConfig(t testing.TB) {
+	t.Cleanup(func() {
+		setLogLevel("debug")
+		flows.reset()
+		for _, s := range interceptors.list() {
+			interceptors.set(s.name, true)
+		}
+	})
+}
+
+// 将log包的输出重定向到logBuffer，测试结束后恢复，因此使用该harness的测试不能并行执行
+func captureLogs(t testing.TB) *logBuffer {
+	b := &logBuffer{}
+	log.SetOutput(b)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		if t.Failed() {
+			t.Logf("server logs:\n%s", b.String())
+		}
+	})
+	return b
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	healthsvc "google.golang.org/grpc/health"
+	healthz "google.golang.org/grpc/health/grpc_health_v1"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 健康检查探针的默认执行间隔，可通过环境变量HEALTH_CHECK_INTERVAL修改
+const DefaultHealthCheckInterval = time.Second * 5
+
+// 单次探针执行的超时时间
+const ProbeTimeout = time.Second * 2
+
+// 连续失败多少次后置为NOT_SERVING，连续成功多少次后恢复SERVING，避免状态来回抖动
+const (
+	FailureThreshold = 3
+	SuccessThreshold = 2
+)
+
+// 探针函数，返回非nil错误表示依赖不可用
+type probe func(ctx context.Context) error
+
+// healthManager 定时执行每个服务注册的探针，根据结果更新healthsvc.Server中的服务状态，
+// 空字符串表示的整体服务只有在所有服务都为SERVING时才为SERVING。
+// 通过Admin.SetHealth手动设置的状态优先于探针的结果，直到被清除
+type healthManager struct {
+	h            *healthsvc.Server
+	interval     time.Duration
+	probeTimeout time.Duration
+
+	checkMu   sync.Mutex // 保证各次检查按顺序更新状态
+	mu        sync.Mutex // 保护services和overrides，执行探针时不持有
+	services  map[string]*serviceHealth
+	overrides map[string]healthz.HealthCheckResponse_ServingStatus
+}
+
+type serviceHealth struct {
+	probes    map[string]*registeredProbe
+	failures  int // 连续失败次数
+	successes int // 连续成功次数
+	serving   bool
+	checked   bool // 是否已执行过探针，第一次检查的结果直接生效
+}
+
+type registeredProbe struct {
+	name    string
+	run     probe
+	running int32 // 上一次执行是否还未返回
+}
+
+func newHealthManager(h *healthsvc.Server, interval time.Duration) *healthManager {
+	return &healthManager{
+		h:            h,
+		interval:     interval,
+		probeTimeout: ProbeTimeout,
+		services:     make(map[string]*serviceHealth),
+		overrides:    make(map[string]healthz.HealthCheckResponse_ServingStatus),
+	}
+}
+
+// 为服务注册一个探针，同名探针会被替换
+func (m *healthManager) register(service, name string, p probe) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sh, ok := m.services[service]
+	if !ok {
+		sh = &serviceHealth{probes: make(map[string]*registeredProbe)}
+		m.services[service] = sh
+	}
+	sh.probes[name] = &registeredProbe{name: name, run: p}
+}
+
+// 定时执行所有探针，直到stop被关闭
+func (m *healthManager) run(stop <-chan struct{}) {
+	t := time.NewTicker(m.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			m.check()
+		}
+	}
+}
+
+// 并发执行一次所有探针并更新服务状态，最多等待probeTimeout
+func (m *healthManager) check() {
+	m.checkMu.Lock()
+	defer m.checkMu.Unlock()
+
+	m.mu.Lock()
+	probes := make(map[string][]*registeredProbe, len(m.services))
+	for name, sh := range m.services {
+		for _, p := range sh.probes {
+			probes[name] = append(probes[name], p)
+		}
+	}
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.probeTimeout)
+	defer cancel()
+	errs := runProbes(ctx, probes)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name := range probes {
+		sh := m.services[name]
+		err := errs[name]
+		if sh.update(err) {
+			log.Printf("Health of %s changed to %s: %v", name, servingStatus(sh.serving), err)
+		}
+	}
+	m.publish()
+}
+
+// 手动设置服务的状态，service为""时设置整体状态，之后探针的结果不再生效，直到调用clearOverride
+func (m *healthManager) override(service string, status healthz.HealthCheckResponse_ServingStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.overrides[service] = status
+	m.publish()
+}
+
+// 清除手动设置的状态，恢复由探针决定
+func (m *healthManager) clearOverride(service string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.overrides, service)
+	m.publish()
+}
+
+// 将各服务的状态写入healthsvc.Server，手动设置的状态优先，整体状态由各服务的最终状态决定。调用方持有mu
+func (m *healthManager) publish() {
+	statuses := make(map[string]healthz.HealthCheckResponse_ServingStatus)
+	for name, sh := range m.services {
+		if sh.checked { // 还未执行过探针的服务没有状态
+			statuses[name] = servingStatus(sh.serving)
+		}
+	}
+	for name, status := range m.overrides {
+		if len(name) != 0 {
+			statuses[name] = status
+		}
+	}
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	overall := true
+	for _, name := range names {
+		status := statuses[name]
+		updateServiceHealth(m.h, name, status)
+		overall = overall && status == healthz.HealthCheckResponse_SERVING
+	}
+	status, ok := m.overrides[""]
+	if !ok {
+		status = servingStatus(overall)
+	}
+	updateServiceHealth(m.h, "", status)
+}
+
+// 每个探针在单独的goroutine中执行，返回每个服务按名称排序的第一个失败的探针的错误
+func runProbes(ctx context.Context, probes map[string][]*registeredProbe) map[string]error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := make(map[string]map[string]error)
+	for service, ps := range probes {
+		for _, p := range ps {
+			wg.Add(1)
+			go func(service string, p *registeredProbe) {
+				defer wg.Done()
+				err := p.call(ctx)
+				if err == nil {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if failed[service] == nil {
+					failed[service] = make(map[string]error)
+				}
+				failed[service][p.name] = err
+			}(service, p)
+		}
+	}
+	wg.Wait()
+
+	errs := make(map[string]error, len(failed))
+	for service, probeErrs := range failed {
+		names := make([]string, 0, len(probeErrs))
+		for name := range probeErrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		errs[service] = fmt.Errorf("probe %s failed: %v", names[0], probeErrs[names[0]])
+	}
+	return errs
+}
+
+// 执行探针，超过ctx的截止时间后不再等待。不检查ctx的探针（例如卡住的NFS上的文件操作）会继续在后台执行，
+// 上一次执行还未返回时不再启动新的执行，直接视为失败，避免goroutine堆积
+func (p *registeredProbe) call(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&p.running, 0, 1) {
+		return errors.New("previous run has not returned yet")
+	}
+	ch := make(chan error, 1)
+	go func() {
+		defer atomic.StoreInt32(&p.running, 0)
+		ch <- p.run(ctx)
+	}()
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 记录一次探针结果，连续失败或成功达到阈值后才切换状态，返回状态是否发生变化
+func (sh *serviceHealth) update(err error) bool {
+	ok := err == nil
+	if !sh.checked {
+		sh.checked = true
+		sh.serving = ok
+		return true
+	}
+
+	if ok {
+		sh.successes++
+		sh.failures = 0
+	} else {
+		sh.failures++
+		sh.successes = 0
+	}
+
+	switch {
+	case sh.serving && sh.failures >= FailureThreshold:
+		sh.serving = false
+		return true
+	case !sh.serving && sh.successes >= SuccessThreshold:
+		sh.serving = true
+		return true
+	}
+	return false
+}
+
+func servingStatus(serving bool) healthz.HealthCheckResponse_ServingStatus {
+	if serving {
+		return healthz.HealthCheckResponse_SERVING
+	}
+	return healthz.HealthCheckResponse_NOT_SERVING
+}
+
+// 存储目录可写探针：在目录中创建并删除一个临时文件
+func storageProbe(dir string) probe {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".health-*")
+		if err != nil {
+			return err
+		}
+		name := f.Name()
+		err = f.Close()
+		removeErr := os.Remove(name)
+		if err != nil {
+			return err
+		}
+		return removeErr
+	}
+}
+
+// 磁盘剩余空间探针：dir所在文件系统的可用空间小于minFree字节时失败
+func diskSpaceProbe(dir string, minFree uint64) probe {
+	return func(ctx context.Context) error {
+		free, err := diskFree(dir)
+		if err != nil {
+			return err
+		}
+		if free < minFree {
+			return fmt.Errorf("only %d bytes free on %s, want at least %d", free, dir, minFree)
+		}
+		return nil
+	}
+}
+
+// goroutine数量探针：数量超过max时失败，通常意味着存在泄漏或请求堆积
+func goroutineProbe(max int) probe {
+	return func(ctx context.Context) error {
+		n := runtime.NumGoroutine()
+		if n > max {
+			return fmt.Errorf("%d goroutines running, want at most %d", n, max)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	healthsvc "google.golang.org/grpc/health"
+	healthz "google.golang.org/grpc/health/grpc_health_v1"
+	"testing"
+	"time"
+)
+
+// 查询healthsvc.Server中服务的状态
+func servingStatusOf(t *testing.T, h *healthsvc.Server, service string) healthz.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := h.Check(context.Background(), &healthz.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Status
+}
+
+// 卡住的探针不影响其他服务的状态，也不阻塞register
+func TestHealthManagerHangingProbe(t *testing.T) {
+	h := healthsvc.NewServer()
+	hm := newHealthManager(h, time.Hour)
+	hm.probeTimeout = 50 * time.Millisecond
+	hang := make(chan struct{})
+	defer close(hang)
+	hm.register("Repo", "storage", func(ctx context.Context) error {
+		<-hang // 不检查ctx，例如卡住的NFS
+		return nil
+	})
+	hm.register("Users", "ok", func(ctx context.Context) error { return nil })
+
+	done := make(chan struct{})
+	go func() {
+		hm.check()
+		close(done)
+	}()
+	hm.register("Users", "other", func(ctx context.Context) error { return nil })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("check blocked by a hanging probe")
+	}
+	if s := servingStatusOf(t, h, "Repo"); s != healthz.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("Repo: got %v, want NOT_SERVING", s)
+	}
+	if s := servingStatusOf(t, h, "Users"); s != healthz.HealthCheckResponse_SERVING {
+		t.Fatalf("Users: got %v, want SERVING", s)
+	}
+	if s := servingStatusOf(t, h, ""); s != healthz.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("overall: got %v, want NOT_SERVING", s)
+	}
+
+	// 上一次执行还未返回时不再启动新的执行
+	errs := runProbes(context.Background(), map[string][]*registeredProbe{"Repo": {hm.services["Repo"].probes["storage"]}})
+	if err := errs["Repo"]; err == nil || err.Error() != "probe storage failed: previous run has not returned yet" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
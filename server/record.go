@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	svc "github.com/calmw/grpc-service"
+	"github.com/calmw/grpc-service/record"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 单个录制文件的默认最大字节数，超过后切换到新文件
+const DefaultRecordMaxSize = 64 << 20
+
+// 默认保留的录制文件数量，更早的文件会被删除
+const DefaultRecordMaxFiles = 10
+
+// recorder 将RPC调用的请求、响应、元数据、状态和耗时以长度前缀的protobuf格式（svc.CallRecord）写入滚动的录制文件，
+// 供grpc-replay重放，为nil时不录制
+type recorder struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+	redact   *record.Redactor
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// 设置了环境变量RECORD_DIR时启用录制，RECORD_MAX_SIZE和RECORD_MAX_FILES控制文件滚动，
+// RECORD_REDACT指定需要脱敏的字段，例如 RECORD_REDACT=UserGetRequest.email,metadata.x-tenant，
+// authorization、cookie等认证元数据总是脱敏，RECORD_REDACT_AUTH=false时才原样录制
+func setupRecorder() (*recorder, error) {
+	dir, ok := os.LookupEnv("RECORD_DIR")
+	if !ok {
+		return nil, nil
+	}
+	maxSize, err := intFromEnv("RECORD_MAX_SIZE", DefaultRecordMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	maxFiles, err := intFromEnv("RECORD_MAX_FILES", DefaultRecordMaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	redact, err := record.NewRedactor(os.Getenv("RECORD_REDACT"))
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := os.LookupEnv("RECORD_REDACT_AUTH"); ok {
+		redactAuth, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RECORD_REDACT_AUTH: %s", v)
+		}
+		redact.KeepAuth = !redactAuth
+	}
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{dir: dir, maxSize: int64(maxSize), maxFiles: maxFiles, redact: redact}, nil
+}
+
+func intFromEnv(key string, def int) (int, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s: %s", key, v)
+	}
+	return n, nil
+}
+
+// 服务端，一元RPC方法调用的录制拦截器
+func (r *recorder) unaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if r == nil || !interceptors.isEnabled("recording") {
+		return handler(ctx, req)
+	}
+	call := r.newCall(ctx, info.FullMethod)
+	call.addRequest(req)
+	resp, err := handler(ctx, req)
+	if err == nil {
+		call.addResponse(resp)
+	}
+	r.write(call.finish(err))
+	return resp, err
+}
+
+// 服务端，流RPC方法调用的录制拦截器，通过wrappedServerStream录制每一条收发的消息
+func (r *recorder) streamInterceptor(
+	srv interface{},
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if r == nil || !interceptors.isEnabled("recording") {
+		return handler(srv, stream)
+	}
+	call := r.newCall(stream.Context(), info.FullMethod)
+	serverStream := wrappedServerStream{ServerStream: stream, RecvMsgTimeout: RecvMsgTimeout, call: call}
+	err := handler(srv, serverStream)
+	r.write(call.finish(err))
+	return err
+}
+
+func (r *recorder) newCall(ctx context.Context, method string) *callRecording {
+	start := time.Now()
+	rec := &svc.CallRecord{
+		Method:    method,
+		StartTime: timestamppb.New(start),
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		values := md[k]
+		if r.redact.Metadata(k) {
+			values = []string{record.RedactedValue}
+		}
+		rec.Metadata = append(rec.Metadata, &svc.MetadataEntry{Key: k, Values: values})
+	}
+	return &callRecording{rec: rec, start: start, redact: r.redact}
+}
+
+// 写入一条记录，当前文件超过maxSize时切换到新文件，写入失败只记录日志，不影响RPC调用
+func (r *recorder) write(rec *svc.CallRecord) {
+	var buf bytes.Buffer
+	_, err := protodelim.MarshalTo(&buf, rec)
+	if err != nil {
+		log.Printf("Recording %s failed: %v", rec.Method, err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil || r.size+int64(buf.Len()) > r.maxSize {
+		err = r.rotate()
+		if err != nil {
+			log.Printf("Rotating record file failed: %v", err)
+			return
+		}
+	}
+	n, err := r.f.Write(buf.Bytes())
+	r.size += int64(n)
+	if err != nil {
+		log.Printf("Recording %s failed: %v", rec.Method, err)
+	}
+}
+
+// 关闭当前文件并创建新的录制文件，删除超过maxFiles的旧文件，调用时需持有r.mu
+func (r *recorder) rotate() error {
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+	name := filepath.Join(r.dir, fmt.Sprintf("calls-%s.rec", time.Now().UTC().Format("20060102T150405.000000000")))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	r.f, r.size = f, 0
+
+	files, err := filepath.Glob(filepath.Join(r.dir, "calls-*.rec"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files) // 文件名中的时间保证按字典序即按创建时间排序
+	for len(files) > r.maxFiles {
+		err = os.Remove(files[0])
+		if err != nil {
+			log.Printf("Removing old record file failed: %v", err)
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+func (r *recorder) close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+}
+
+// callRecording 一次调用的录制，流调用的消息在不同的goroutine中收发，需要加锁
+type callRecording struct {
+	start  time.Time
+	redact *record.Redactor
+
+	mu       sync.Mutex
+	rec      *svc.CallRecord
+	finished bool
+}
+
+func (c *callRecording) addRequest(m interface{}) {
+	c.add(m, func(rm *svc.RecordedMessage) {
+		c.rec.Requests = append(c.rec.Requests, rm)
+	})
+}
+
+func (c *callRecording) addResponse(m interface{}) {
+	c.add(m, func(rm *svc.RecordedMessage) {
+		c.rec.Responses = append(c.rec.Responses, rm)
+	})
+}
+
+func (c *callRecording) add(m interface{}, appendMsg func(*svc.RecordedMessage)) {
+	pm, ok := m.(proto.Message)
+	if !ok {
+		return
+	}
+	offset := time.Since(c.start)
+	pm = proto.Clone(pm) // 消息可能在发送后被处理函数复用，例如GetRepos
+	c.redact.Clear(pm)
+	a, err := anypb.New(pm)
+	if err != nil {
+		log.Printf("Recording message %T failed: %v", pm, err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.finished {
+		return
+	}
+	appendMsg(&svc.RecordedMessage{Message: a, Offset: durationpb.New(offset)})
+}
+
+// 记录调用结果，返回完整的记录
+func (c *callRecording) finish(err error) *svc.CallRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.finished = true
+	s := status.Convert(err)
+	c.rec.StatusCode = int32(s.Code())
+	c.rec.StatusMessage = s.Message()
+	c.rec.Duration = durationpb.New(time.Since(c.start))
+	return c.rec
+}
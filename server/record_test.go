@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"github.com/calmw/grpc-service/record"
+	"google.golang.org/grpc/metadata"
+	"testing"
+)
+
+// 录制时认证元数据默认脱敏，RECORD_REDACT_AUTH=false时原样录制
+func TestRecorderRedactsAuthMetadata(t *testing.T) {
+	t.Setenv("RECORD_DIR", t.TempDir())
+	t.Setenv("RECORD_REDACT", "metadata.x-tenant")
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"authorization", "Bearer secret",
+		"cookie", "session=1",
+		"x-auth-token-bin", "\x01\x02",
+		"x-tenant", "acme",
+		"x-request-id", "42",
+	))
+	recorded := func() map[string]string {
+		r, err := setupRecorder()
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := make(map[string]string)
+		for _, e := range r.newCall(ctx, "/Users/GetUser").rec.Metadata {
+			values[e.Key] = e.Values[0]
+		}
+		return values
+	}
+
+	md := recorded()
+	for _, k := range []string{"authorization", "cookie", "x-auth-token-bin", "x-tenant"} {
+		if md[k] != record.RedactedValue {
+			t.Fatalf("%s recorded as %q", k, md[k])
+		}
+	}
+	if md["x-request-id"] != "42" {
+		t.Fatalf("x-request-id recorded as %q", md["x-request-id"])
+	}
+
+	t.Setenv("RECORD_REDACT_AUTH", "false")
+	md = recorded()
+	if md["authorization"] != "Bearer secret" || md["x-tenant"] != record.RedactedValue {
+		t.Fatalf("unexpected metadata: %v", md)
+	}
+	t.Setenv("RECORD_REDACT_AUTH", "no way")
+	if _, err := setupRecorder(); err == nil {
+		t.Fatal("invalid RECORD_REDACT_AUTH accepted")
+	}
+}
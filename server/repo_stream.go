@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"hash"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// CreateRepo流的状态：恰好一条开头的context消息，之后是任意条data消息，最后是可选的trailer消息
+type repoStreamState int
+
+const (
+	awaitingContext repoStreamState = iota
+	receivingData
+	trailerReceived
+)
+
+// 仓库数据的默认最大字节数，可通过环境变量REPO_MAX_SIZE修改
+const DefaultRepoMaxSize = 1 << 30
+
+// repoStorage 仓库数据保存在存储目录的repos子目录下，路径为 repos/<creator_id>/<name>
+type repoStorage struct {
+	dir     string
+	maxSize int64
+}
+
+// 存储目录为环境变量STORAGE_DIR，默认为系统临时目录
+func storageDir() string {
+	dir, ok := os.LookupEnv("STORAGE_DIR")
+	if !ok {
+		dir = os.TempDir()
+	}
+	return dir
+}
+
+func setupRepoStorage() (*repoStorage, error) {
+	maxSize, err := intFromEnv("REPO_MAX_SIZE", DefaultRepoMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(storageDir(), "repos")
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &repoStorage{dir: dir, maxSize: int64(maxSize)}, nil
+}
+
+// 仓库数据的保存路径，校验拦截器关闭时也不允许creator_id和name逃出存储目录
+func (rs *repoStorage) path(repoContext *svc.RepoContext) (string, error) {
+	for _, name := range []string{repoContext.CreatorId, repoContext.Name} {
+		if len(name) == 0 || name == "." || name == ".." || filepath.Base(name) != name {
+			return "", status.Errorf(codes.InvalidArgument, "Invalid repository path component %q", name)
+		}
+	}
+	return filepath.Join(rs.dir, repoContext.CreatorId, repoContext.Name), nil
+}
+
+// repoUpload 按CreateRepo的流协议处理收到的消息，违反协议时返回FailedPrecondition，
+// trailer中的校验和与收到的数据不一致时返回DataLoss。数据边收边写入目标目录下的临时文件，
+// 超过大小限制时返回ResourceExhausted，成功时fsync后原子地重命名为目标文件，出错或客户端取消时由abort删除临时文件
+type repoUpload struct {
+	storage *repoStorage
+	state   repoStreamState
+	context *svc.RepoContext
+	path    string
+	f       *os.File
+	size    int64
+	hash    hash.Hash
+	trailer *svc.RepoTrailer
+}
+
+func newRepoUpload(storage *repoStorage) *repoUpload {
+	return &repoUpload{storage: storage, hash: sha256.New()}
+}
+
+func (u *repoUpload) handle(ctx context.Context, r *svc.RepoCreateRequest) error {
+	switch body := r.Body.(type) {
+	case nil: // 校验拦截器被关闭时才会出现
+		return invalidFieldError(ctx, "Message doesn't contain context, data or trailer", "body", "EMPTY_MESSAGE", map[string]string{
+			"en-US": "Each message must contain either context, data or trailer",
+			"zh-CN": "每条消息必须包含context、data或trailer",
+		})
+	case *svc.RepoCreateRequest_Context:
+		if u.state != awaitingContext {
+			return framingError("Repository context must be sent exactly once, as the first message")
+		}
+		err := u.create(body.Context)
+		if err != nil {
+			return err
+		}
+		u.context = body.Context
+		u.state = receivingData
+	case *svc.RepoCreateRequest_Data:
+		switch u.state {
+		case awaitingContext:
+			return framingError("First message must be the repository context, got data")
+		case trailerReceived:
+			return framingError("Unexpected data after the trailer")
+		}
+		if u.size+int64(len(body.Data)) > u.storage.maxSize {
+			return status.Errorf(codes.ResourceExhausted, "Repository exceeds the upload limit of %d bytes", u.storage.maxSize)
+		}
+		_, err := u.f.Write(body.Data)
+		if err != nil {
+			log.Printf("Writing repository data failed: %v", err)
+			return status.Error(codes.Internal, "Storing repository data failed")
+		}
+		u.hash.Write(body.Data)
+		u.size += int64(len(body.Data))
+	case *svc.RepoCreateRequest_Trailer:
+		switch u.state {
+		case awaitingContext:
+			return framingError("First message must be the repository context, got trailer")
+		case trailerReceived:
+			return framingError("Trailer must be sent at most once")
+		}
+		u.trailer = body.Trailer
+		u.state = trailerReceived
+	default:
+		return framingError(fmt.Sprintf("Unexpected message type: %T", body))
+	}
+	return nil
+}
+
+// 在目标文件所在目录创建临时文件，重命名时不会跨文件系统
+func (u *repoUpload) create(repoContext *svc.RepoContext) error {
+	path, err := u.storage.path(repoContext)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err == nil {
+		u.f, err = os.CreateTemp(filepath.Dir(path), ".upload-*")
+	}
+	if err != nil {
+		log.Printf("Creating upload file failed: %v", err)
+		return status.Error(codes.Internal, "Storing repository data failed")
+	}
+	u.path = path
+	return nil
+}
+
+// 将临时文件fsync后重命名为目标文件，并fsync所在目录使重命名持久化
+func (u *repoUpload) commit() error {
+	err := u.f.Sync()
+	if err == nil {
+		err = u.f.Close()
+	}
+	if err == nil {
+		err = os.Rename(u.f.Name(), u.path)
+	}
+	if err == nil {
+		var dir *os.File
+		dir, err = os.Open(filepath.Dir(u.path))
+		if err == nil {
+			err = dir.Sync()
+			dir.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("Committing upload to %s failed: %v", u.path, err)
+		return status.Error(codes.Internal, "Storing repository data failed")
+	}
+	u.f = nil
+	return nil
+}
+
+// 未成功提交时关闭并删除临时文件，提交后调用不做任何事
+func (u *repoUpload) abort() {
+	if u.f == nil {
+		return
+	}
+	u.f.Close()
+	err := os.Remove(u.f.Name())
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Removing partial upload %s failed: %v", u.f.Name(), err)
+	}
+	u.f = nil
+}
+
+// 客户端结束发送后调用，检查流是否完整以及校验和，然后提交数据
+func (u *repoUpload) finish() (*svc.RepoCreateReply, error) {
+	if u.state == awaitingContext {
+		return nil, framingError("Stream ended before the repository context was received")
+	}
+	checksum := hex.EncodeToString(u.hash.Sum(nil))
+	if u.trailer != nil {
+		if len(u.trailer.Sha256) != 0 && u.trailer.Sha256 != checksum {
+			return nil, status.Errorf(codes.DataLoss, "Checksum mismatch: trailer has %s, received data has %s", u.trailer.Sha256, checksum)
+		}
+		if len(u.trailer.Metadata) != 0 {
+			log.Printf("Repository %s metadata: %v", u.context.Name, u.trailer.Metadata)
+		}
+	}
+	err := u.commit()
+	if err != nil {
+		return nil, err
+	}
+	return &svc.RepoCreateReply{
+		Repo: &svc.Repository{
+			Id:   u.context.CreatorId,
+			Name: u.context.Name,
+			Url:  fmt.Sprintf("https://git.example.com/%s/%s", u.context.CreatorId, u.context.Name),
+		},
+		Size:   u.size,
+		Sha256: checksum,
+	}, nil
+}
+
+// 违反流协议的错误，附带PreconditionFailure详情
+func framingError(msg string) error {
+	return withDetails(
+		status.New(codes.FailedPrecondition, msg),
+		&errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{
+			{Type: "STREAM_FRAMING", Subject: "RepoCreateRequest", Description: msg},
+		}},
+	)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/grpc/codes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateRepoFromReader(t *testing.T) {
+	ts := startTestServer(t)
+	data := bytes.Repeat([]byte("0123456789"), 10000) // 多个RepoChunkSize的分块
+	stream, err := ts.Repo.CreateRepo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoContext := &svc.RepoContext{CreatorId: "user-123", Name: "test-repo"}
+	reply, err := svc.CreateRepoFromReader(stream, repoContext, bytes.NewReader(data), map[string]string{"branch": "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if reply.Size != int64(len(data)) || reply.Sha256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected reply: size %d, sha256 %s", reply.Size, reply.Sha256)
+	}
+	ts.assertLogged(t, `Repository test-repo metadata: map\[branch:main\]`)
+	stored, err := os.ReadFile(filepath.Join(os.Getenv("STORAGE_DIR"), "repos", "user-123", "test-repo"))
+	if err != nil || !bytes.Equal(stored, data) {
+		t.Fatalf("unexpected stored repository: %d bytes, err %v", len(stored), err)
+	}
+	assertNoUploads(t, filepath.Join(os.Getenv("STORAGE_DIR"), "repos", "user-123"))
+}
+
+func TestCreateRepoSizeLimit(t *testing.T) {
+	t.Setenv("REPO_MAX_SIZE", "100000")
+	ts := startTestServer(t)
+	stream, err := ts.Repo.CreateRepo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoContext := &svc.RepoContext{CreatorId: "user-123", Name: "test-repo"}
+	_, err = svc.CreateRepoFromReader(stream, repoContext, bytes.NewReader(make([]byte, 100001)), nil)
+	assertStatus(t, err, codes.ResourceExhausted, "Repository exceeds the upload limit of 100000 bytes")
+	dir := filepath.Join(os.Getenv("STORAGE_DIR"), "repos", "user-123")
+	if _, err := os.Stat(filepath.Join(dir, "test-repo")); !os.IsNotExist(err) {
+		t.Fatalf("repository stored despite exceeding the limit: %v", err)
+	}
+	assertNoUploads(t, dir)
+}
+
+func TestCreateRepoCancel(t *testing.T) {
+	ts := startTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := ts.Repo.CreateRepo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&svc.RepoCreateRequest{Body: &svc.RepoCreateRequest_Context{Context: &svc.RepoContext{CreatorId: "user-123", Name: "test-repo"}}})
+	if err == nil {
+		err = stream.Send(&svc.RepoCreateRequest{Body: &svc.RepoCreateRequest_Data{Data: []byte("hello")}})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(os.Getenv("STORAGE_DIR"), "repos", "user-123")
+	waitFor(t, func() bool { // 服务端收到context后创建临时文件
+		entries, _ := os.ReadDir(dir)
+		return len(entries) == 1
+	})
+	cancel()
+	waitFor(t, func() bool {
+		entries, _ := os.ReadDir(dir)
+		return len(entries) == 0
+	})
+}
+
+// 目录中不应残留上传的临时文件
+func assertNoUploads(t *testing.T, dir string) {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, ".upload-*"))
+	if err != nil || len(matches) != 0 {
+		t.Fatalf("partial uploads left behind: %v, err %v", matches, err)
+	}
+}
+
+func TestCreateRepoFraming(t *testing.T) {
+	repoContext := &svc.RepoCreateRequest{Body: &svc.RepoCreateRequest_Context{Context: &svc.RepoContext{CreatorId: "user-123", Name: "test-repo"}}}
+	data := &svc.RepoCreateRequest{Body: &svc.RepoCreateRequest_Data{Data: []byte("hello")}}
+	trailer := func(sha string) *svc.RepoCreateRequest {
+		return &svc.RepoCreateRequest{Body: &svc.RepoCreateRequest_Trailer{Trailer: &svc.RepoTrailer{Sha256: sha}}}
+	}
+	sum := sha256.Sum256([]byte("hello"))
+
+	tests := []struct {
+		name     string
+		requests []*svc.RepoCreateRequest
+		code     codes.Code
+		msg      string
+	}{
+		{"empty stream", nil, codes.FailedPrecondition, "Stream ended before the repository context was received"},
+		{"data first", []*svc.RepoCreateRequest{data, repoContext}, codes.FailedPrecondition, "First message must be the repository context, got data"},
+		{"context twice", []*svc.RepoCreateRequest{repoContext, data, repoContext}, codes.FailedPrecondition, "Repository context must be sent exactly once, as the first message"},
+		{"data after trailer", []*svc.RepoCreateRequest{repoContext, trailer(""), data}, codes.FailedPrecondition, "Unexpected data after the trailer"},
+		{"checksum mismatch", []*svc.RepoCreateRequest{repoContext, data, trailer(hex.EncodeToString(make([]byte, 32)))}, codes.DataLoss,
+			"Checksum mismatch: trailer has " + hex.EncodeToString(make([]byte, 32)) + ", received data has " + hex.EncodeToString(sum[:])},
+		{"no trailer", []*svc.RepoCreateRequest{repoContext, data}, codes.OK, ""},
+		{"trailer", []*svc.RepoCreateRequest{repoContext, data, trailer(hex.EncodeToString(sum[:]))}, codes.OK, ""},
+	}
+	ts := startTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := ts.Repo.CreateRepo(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range tt.requests {
+				if stream.Send(r) != nil { // 服务端已结束调用，错误由CloseAndRecv返回
+					break
+				}
+			}
+			_, err = stream.CloseAndRecv()
+			assertStatus(t, err, tt.code, tt.msg)
+		})
+	}
+}
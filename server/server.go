@@ -4,7 +4,10 @@ import (
 	"context"
 	"fmt"
 	svc "github.com/calmw/grpc-service"
+	_ "github.com/calmw/grpc-service/codec" // 注册JSON和vtproto编解码器，按请求的content-type（例如 application/grpc+json）选择
+	"github.com/calmw/grpc-service/compress"
 	"google.golang.org/grpc"
+	channelzservice "google.golang.org/grpc/channelz/service"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	healthsvc "google.golang.org/grpc/health"
@@ -12,16 +15,28 @@ import (
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoregistry"
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"runtime"
 	"strings"
 	"time"
 )
 
 const RecvMsgTimeout = time.Millisecond * 500
 
+// 一元RPC方法执行时间的上限，超过后由超时拦截器终止
+const UnaryTimeout = time.Millisecond * 300
+
+// 运行中的goroutine超过该数量时服务置为NOT_SERVING
+const MaxGoroutines = 10000
+
+// 存储目录所在磁盘可用空间低于该值时Repo服务置为NOT_SERVING
+const MinFreeDiskSpace = 100 << 20
+
 func main() {
 	listenAddr := os.Getenv("LISTEN_ADDR")
 	if len(listenAddr) == 0 {
@@ -49,47 +64,196 @@ func main() {
 		log.Fatal(err)
 	}
 	credsOption := grpc.Creds(creds)
-	s := grpc.NewServer(
-		credsOption,
+	rec, err := setupRecorder() // 设置了RECORD_DIR时录制RPC调用，供grpc-replay重放
+	if err != nil {
+		log.Fatal(err)
+	}
+	fi, err := setupFaultInjector() // 设置了FAULT_CONFIG时按配置文件注入故障
+	if err != nil {
+		log.Fatal(err)
+	}
+	tc, err := setupTransportConfig() // 流控窗口、缓冲区、消息大小和压缩器，未设置的使用grpc的默认值
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = compress.Register(tc.Compressors...) // 注册的压缩器可以解压请求，并以相同的压缩器压缩回复
+	if err != nil {
+		log.Fatal(err)
+	}
+	rc, err := setupResponseCache() // 按CACHE_TTL、CACHE_MAX_ENTRIES和CACHE_METHODS缓存回复，CACHE_TTL为0时不缓存
+	if err != nil {
+		log.Fatal(err)
+	}
+	v, err := newValidator(protoregistry.GlobalFiles) // 启动时编译所有校验规则中的正则，有无效的正则时拒绝启动
+	if err != nil {
+		log.Fatal(err)
+	}
+	sf := setupCoalescer()      // 合并SINGLEFLIGHT_METHODS中的方法相同的并发请求
+	tracker := newCallTracker() // 跟踪活动的连接和调用，供Admin服务查看和强制结束
+	s := newServer(rec, v, fi, rc, sf, append(tc.serverOptions(), credsOption, grpc.StatsHandler(tracker))...)
+
+	delay, timeout, err := shutdownDurations()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	h := healthsvc.NewServer()
+	d := newDrainer()
+	trigger := newShutdownTrigger()
+	rs, err := setupRepoStorage() // 仓库数据保存在STORAGE_DIR/repos下，REPO_MAX_SIZE限制上传的大小
+	if err != nil {
+		log.Fatal(err)
+	}
+	hm, err := setupHealthManager(h)
+	if err != nil {
+		log.Fatal(err)
+	}
+	registerServices(s, h, d, rs, rc, newAdminService(os.Getenv("ADMIN_TOKEN"), hm, tracker, trigger, rc))
+	hm.check() // 开始服务前先确定各服务的健康状态
+	go hm.run(d.draining())
+	go fi.watch(d.draining())
+	stopped := stopOnSignal(s, h, d, trigger, delay, timeout)
+
+	// 设置了WEB_LISTEN_ADDR时，同时在该地址上以gRPC-Web和Connect协议提供服务，供浏览器调用
+	var ws *http.Server
+	if webAddr := os.Getenv("WEB_LISTEN_ADDR"); len(webAddr) != 0 {
+		webLis, err := net.Listen("tcp", webAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ws = startWebServer(s, webLis, tlsCertFile, tlsKeyFile)
+	}
+
+	// 设置了DEBUG_LISTEN_ADDR时，在该地址上提供pprof、channelz、进行中的RPC和拦截器链配置等调试页面
+	var ds *http.Server
+	if debugAddr := os.Getenv("DEBUG_LISTEN_ADDR"); len(debugAddr) != 0 {
+		debugLis, err := net.Listen("tcp", debugAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ds = startDebugServer(newDebugServer(tracker, rec, fi, rc, sf, tc), debugLis)
+	}
+
+	err = startServer(s, lis) // GracefulStop或Stop被调用后返回nil
+	if err != nil {
+		log.Fatal(err)
+	}
+	<-stopped
+	if ws != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		ws.Shutdown(ctx)
+	}
+	if ds != nil {
+		ds.Close()
+	}
+	rec.close()
+	log.Println("Server stopped")
+}
+
+type userService struct {
+	svc.UnimplementedUsersServer // 对于grpc中任何服务实现都是强制性的
+	sessions                     *helpSessions
+	drainer                      *drainer
+	users                        *userStore
+	cache                        *responseCache // 修改用户后删除缓存的GetUser回复，为nil时未启用缓存
+}
+
+type repoService struct {
+	svc.UnimplementedRepoServer
+	storage *repoStorage
+}
+
+// 拦截器链由外到内的顺序，与newServer一致，供调试页面显示
+var interceptorChain = []string{"flow-metrics", "logging", "recording", "timeout", "panic", "validation", "fault-injection", "cache", "singleflight"}
+
+// 创建grpc.Server并注册拦截器链，opts中的拦截器排在拦截器链的最后，即最内层
+func newServer(rec *recorder, v *validator, fi *faultInjector, rc *responseCache, sf *coalescer, opts ...grpc.ServerOption) *grpc.Server {
+	chain := []grpc.ServerOption{
 		grpc.ChainUnaryInterceptor( // 用于注册多个服务端一元拦截器，最内层的拦截器首先执行
 			loggingUnaryInterceptor,
+			rec.unaryInterceptor, // 在超时和panic处理之外，以录制最终返回的状态
 			timeoutUnaryInterceptor,
 			panicUnaryInterceptor, //
+			v.unaryInterceptor,    // 按proto中声明的规则校验请求
+			fi.unaryInterceptor,
+			// 缓存GetUser等方法的回复，在故障注入之内，注入的故障对缓存的回复同样生效
+			rc.unaryInterceptor,
+			sf.unaryInterceptor, // 缓存未命中时合并相同的并发请求
 			// ... 其他拦截器
 		),
 		grpc.ChainStreamInterceptor( // 用于注册多个服务端流拦截器，最内层的拦截器首先执行
+			flowStreamInterceptor, // 最外层，测量SendMsg在传输层阻塞的时间
 			loggingStreamInterceptor,
+			rec.streamInterceptor,
 			timeoutStreamInterceptor,
 			panicStreamInterceptor,
+			v.streamInterceptor,
+			fi.streamInterceptor,
 			// ... 其他拦截器
 		),
-	)
-
-	h := healthsvc.NewServer()
-	registerServices(s, h)
-	updateServiceHealth(h, svc.Users_ServiceDesc.ServiceName, healthz.HealthCheckResponse_SERVING)
-	log.Fatal(startServer(s, lis))
+	}
+	return grpc.NewServer(append(chain, opts...)...)
 }
 
-type userService struct {
-	svc.UnimplementedUsersServer // 对于grpc中任何服务实现都是强制性的
+func registerServices(s *grpc.Server, h *healthsvc.Server, d *drainer, rs *repoStorage, rc *responseCache, a *adminService) {
+	sessions := newHelpSessions()
+	go sessions.run(d.draining()) // 开始排空后不再清理，进程随后退出
+	svc.RegisterUsersServer(s, &userService{sessions: sessions, drainer: d, users: newUserStore(), cache: rc})
+	svc.RegisterAdminServer(s, a)
+	svc.RegisterRepoServer(s, &repoService{storage: rs})
+	healthz.RegisterHealthServer(s, drainingHealthServer{Server: h, drainer: d})
+	reflection.Register(s)
+	channelzservice.RegisterChannelzServiceToServer(s)
 }
 
-func registerServices(s *grpc.Server, h *healthsvc.Server) {
-	svc.RegisterUsersServer(s, &userService{})
-	healthz.RegisterHealthServer(s, h)
-	reflection.Register(s)
+// 为Users和Repo服务注册健康检查探针，Repo依赖存储目录（环境变量STORAGE_DIR，默认为系统临时目录）
+func setupHealthManager(h *healthsvc.Server) (*healthManager, error) {
+	interval, err := durationFromEnv("HEALTH_CHECK_INTERVAL", DefaultHealthCheckInterval)
+	if err != nil {
+		return nil, err
+	}
+	dir := storageDir()
+
+	hm := newHealthManager(h, interval)
+	hm.register(svc.Users_ServiceDesc.ServiceName, "goroutines", goroutineProbe(MaxGoroutines))
+	hm.register(svc.Repo_ServiceDesc.ServiceName, "goroutines", goroutineProbe(MaxGoroutines))
+	hm.register(svc.Repo_ServiceDesc.ServiceName, "storage", storageProbe(dir))
+	if diskFreeSupported {
+		hm.register(svc.Repo_ServiceDesc.ServiceName, "disk-space", diskSpaceProbe(dir, MinFreeDiskSpace))
+	} else {
+		// 不支持的平台上探针总是失败，会让Repo一直处于NOT_SERVING，因此不注册
+		log.Printf("disk space probe is not supported on %s, skipping", runtime.GOOS)
+	}
+
+	return hm, nil
 }
 
 func startServer(s *grpc.Server, l net.Listener) error {
 	return s.Serve(l)
 }
 
-func stopServer(s *grpc.Server, h *healthsvc.Server, d time.Duration) {
-	updateServiceHealth(h, svc.Users_ServiceDesc.ServiceName, healthz.HealthCheckResponse_NOT_SERVING)
-	time.Sleep(d)
-	s.Stop()
-	s.GracefulStop()
+// 优雅关闭服务端：先将所有服务的健康状态置为NOT_SERVING，等待delay让状态传播到客户端和负载均衡器，
+// 然后通知GetHelp和健康检查的Watch等长时间运行的流结束（见drainingHealthServer），调用GracefulStop等待进行中的RPC完成，
+// 超过timeout后强制Stop
+func stopServer(s *grpc.Server, h *healthsvc.Server, d *drainer, delay, timeout time.Duration) {
+	h.Shutdown() // 所有服务置为NOT_SERVING，并忽略之后的状态更新
+	time.Sleep(delay)
+	d.start()
+
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-done:
+	case <-t.C:
+		log.Println("Graceful stop timed out, forcing stop")
+		s.Stop()
+	}
 }
 
 func updateServiceHealth(
@@ -104,6 +268,16 @@ func (s *userService) GetHelp(stream svc.Users_GetHelpServer) error {
 	log.Println("Client connected")
 
 	for {
+		select {
+		case <-s.drainer.draining(): // 服务端正在关闭，通知客户端结束流，客户端重建流时会连接到其他后端
+			return withDetails(
+				status.New(codes.Unavailable, "Server is shutting down"),
+				errorInfo("SERVER_DRAINING", nil),
+				retryInfo(RetryDelay),
+			)
+		default:
+		}
+
 		request, err := stream.Recv()
 		if err == io.EOF {
 			break
@@ -111,15 +285,24 @@ func (s *userService) GetHelp(stream svc.Users_GetHelpServer) error {
 		if err != nil {
 			return err
 		}
-		fmt.Printf("Request received: %s \n", request.Request)
 
-		if request.Request == "panic" {
-			panic("I was asked to panic")
+		// 未携带会话ID的旧客户端，不做去重
+		if len(request.SessionId) == 0 {
+			err = stream.Send(handleHelpRequest(request))
+			if err != nil {
+				return err
+			}
+			continue
 		}
 
-		response := svc.UserHelpReply{Response: request.Request}
-
-		err = stream.Send(&response)
+		response, err := s.sessions.get(request.SessionId).process(request, handleHelpRequest)
+		if err != nil {
+			return err
+		}
+		if response == nil { // 重复的消息，且客户端已确认收到回复
+			continue
+		}
+		err = stream.Send(response)
 		if err != nil {
 			return err
 		}
@@ -129,19 +312,38 @@ func (s *userService) GetHelp(stream svc.Users_GetHelpServer) error {
 	return nil
 }
 
+func handleHelpRequest(request *svc.UserHelpRequest) *svc.UserHelpReply {
+	fmt.Printf("Request received: %s \n", request.Request)
+
+	if request.Request == "panic" {
+		panic("I was asked to panic")
+	}
+
+	return &svc.UserHelpReply{Response: request.Request}
+}
+
 func (s *userService) GetUser(ctx context.Context, in *svc.UserGetRequest) (*svc.UserGetReply, error) {
 	log.Printf(
 		"Received request for user with Email: %s Id:%s\n",
 		in.Email,
 		in.Id,
 	)
+	// 邮箱地址的格式由校验拦截器按users.proto中声明的规则检查，这里的检查在校验拦截器被关闭时生效
 	components := strings.Split(in.Email, "@")
 	if len(components) != 2 {
-		return nil, status.Error(codes.InvalidArgument, "Invalid email address specified") // status.Error函数创建错误，可以错误码和错误信息一块创建
+		// 除了错误码和错误信息，还附加字段错误、错误原因和本地化的消息，客户端通过status.Convert(err).Details()获取
+		return nil, invalidFieldError(ctx, "Invalid email address specified", "email", "INVALID_EMAIL", map[string]string{
+			"en-US": "Email must be in the form name@domain",
+			"zh-CN": "邮箱地址的格式应为 名称@域名",
+		})
 	}
 	if components[0] == "panic" {
 		panic("I was asked to panic")
 	}
+	// 通过UpdateUser修改过的用户返回修改后的数据
+	if u := s.users.get(in.Id); u != nil {
+		return &svc.UserGetReply{User: u}, nil
+	}
 	u := svc.User{
 		Id:        in.Id,
 		FirstName: components[0],
@@ -152,6 +354,86 @@ func (s *userService) GetUser(ctx context.Context, in *svc.UserGetRequest) (*svc
 	return &svc.UserGetReply{User: &u}, nil
 }
 
+func (s *userService) UpdateUser(ctx context.Context, in *svc.UserUpdateRequest) (*svc.UserUpdateReply, error) {
+	log.Printf("Received update for user with Id:%s\n", in.User.GetId())
+	if len(in.User.GetId()) == 0 {
+		return nil, invalidFieldError(ctx, "User id is required", "user.id", "MISSING_ID", map[string]string{
+			"en-US": "User id must be set",
+			"zh-CN": "必须指定用户ID",
+		})
+	}
+	s.users.put(in.User)
+	// 删除该用户所有缓存的GetUser回复（不论请求中的邮箱），同时正在执行的GetUser的回复也不会被缓存
+	n := s.cache.invalidate(userCacheTags(in.User.Id, "")...)
+	if n != 0 {
+		log.Printf("Invalidated %d cached replies for user %s", n, in.User.Id)
+	}
+	return &svc.UserUpdateReply{User: in.User}, nil
+}
+
+func (s *repoService) GetRepos(in *svc.RepoGetRequest, stream svc.Repo_GetReposServer) error {
+	log.Printf("Received request for repo with CreatorId: %s Id:%s\n", in.CreatorId, in.Id)
+	repo := svc.Repository{
+		Id:   in.Id,
+		Name: "test repo",
+		Url:  "https://git.example.com/test/repo",
+		Owner: &svc.User{
+			Id:        in.CreatorId,
+			FirstName: "Jane",
+			LastName:  "han",
+			Age:       36,
+		},
+	}
+
+	cnt := 1
+	for {
+		repo.Name = fmt.Sprintf("repo-%d", cnt)
+		repo.Url = fmt.Sprintf("https://git.example.com/test/%s", repo.Name)
+		r := svc.RepoGetReply{
+			Repo: &repo,
+		}
+		err := stream.Send(&r)
+		if err != nil {
+			return err
+		}
+
+		if cnt >= 5 {
+			break
+		}
+		time.Sleep(time.Second * 2)
+		cnt++
+	}
+
+	return nil
+}
+
+func (s *repoService) CreateRepo(stream svc.Repo_CreateRepoServer) error {
+	log.Println("Client connected")
+
+	u := newRepoUpload(s.storage) // 按流协议检查消息的顺序，数据边收边写入临时文件
+	defer u.abort()               // 出错或客户端取消时删除临时文件
+	for {
+		r, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil { // 非读完数据流的其他错误
+			return err
+		}
+		err = u.handle(stream.Context(), r)
+		if err != nil {
+			return err
+		}
+	}
+	reply, err := u.finish()
+	if err != nil {
+		return err
+	}
+
+	log.Println("Client disconnected")
+	return stream.SendAndClose(reply)
+}
+
 // 服务端，一元RPC方法调用的日志拦截器
 func loggingUnaryInterceptor(
 	ctx context.Context,
@@ -159,6 +441,9 @@ func loggingUnaryInterceptor(
 	info *grpc.UnaryServerInfo,
 	handler grpc.UnaryHandler,
 ) (interface{}, error) {
+	if !interceptors.isEnabled("logging") {
+		return handler(ctx, req)
+	}
 	start := time.Now()
 	resp, err := handler(ctx, req)
 	logMessage(ctx, info.FullMethod, time.Since(start), err)
@@ -196,30 +481,36 @@ func timeoutUnaryInterceptor(
 	info *grpc.UnaryServerInfo,
 	handler grpc.UnaryHandler,
 ) (interface{}, error) {
-	var resp interface{}
-	var err error
+	if !interceptors.isEnabled("timeout") {
+		return handler(ctx, req)
+	}
+	type result struct {
+		resp interface{}
+		err  error
+	}
 
-	ctxWithTimeout, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, UnaryTimeout)
 	defer cancel()
 
-	ch := make(chan error)
+	// 超时返回后处理函数可能仍在执行，结果通过带缓冲的channel传递，避免与返回值竞争以及goroutine泄漏
+	ch := make(chan result, 1)
 	go func() {
-		resp, err = handler(ctxWithTimeout, req)
-		ch <- err
+		resp, err := handler(ctxWithTimeout, req)
+		ch <- result{resp, err}
 	}()
 
 	select {
 	case <-ctxWithTimeout.Done():
 		cancel()
-		err = status.Error(
-			codes.DeadlineExceeded,
-			fmt.Sprintf("%s: DeadlineExceeded", info.FullMethod),
+		err := withDetails(
+			status.New(codes.DeadlineExceeded, fmt.Sprintf("%s: DeadlineExceeded", info.FullMethod)),
+			errorInfo("TIMEOUT", map[string]string{"method": info.FullMethod, "timeout": UnaryTimeout.String()}),
+			retryInfo(RetryDelay),
 		)
-		return resp, err
-	case <-ch:
+		return nil, err
+	case r := <-ch:
+		return r.resp, r.err
 	}
-
-	return resp, err
 }
 
 // 服务端，流RPC调用的日志拦截器
@@ -229,6 +520,9 @@ func loggingStreamInterceptor(
 	info *grpc.StreamServerInfo,
 	handler grpc.StreamHandler,
 ) error {
+	if !interceptors.isEnabled("logging") {
+		return handler(srv, stream)
+	}
 	start := time.Now()
 	serverStream := wrappedServerStream{ServerStream: stream, RecvMsgTimeout: RecvMsgTimeout}
 	err := handler(srv, serverStream)
@@ -283,13 +577,16 @@ func timeoutStreamInterceptor(
 	return
 }
 
-//记录RPC方法调用的详细信息
+// 记录RPC方法调用的详细信息
 func logMessage(
 	ctx context.Context,
 	method string,
 	latency time.Duration,
 	err error,
 ) {
+	if err == nil && !logEnabled(logInfo) {
+		return
+	}
 	var requestId string
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
@@ -312,27 +609,55 @@ func logMessage(
 type wrappedServerStream struct {
 	RecvMsgTimeout time.Duration // 流超时时间
 	grpc.ServerStream
+	call      *callRecording // 不为nil时录制收发的消息
+	abort     *streamAbort   // 不为nil时收发若干条消息后中断流
+	validator *validator     // 不为nil时校验收到的每一条消息
 }
 
 func (s wrappedServerStream) SendMsg(m interface{}) error {
-	log.Printf("Send msg called: %T", m)
-	return s.ServerStream.SendMsg(m)
+	debugf("Send msg called: %T", m)
+	if s.abort != nil {
+		if err := s.abort.next(); err != nil {
+			return err
+		}
+	}
+	err := s.ServerStream.SendMsg(m)
+	if err == nil && s.call != nil {
+		s.call.addResponse(m)
+	}
+	return err
 }
 
 func (s wrappedServerStream) RecvMsg(m interface{}) error {
+	if s.abort != nil {
+		if err := s.abort.next(); err != nil {
+			return err
+		}
+	}
+	var timeout <-chan time.Time // 超时拦截器通过Admin服务关闭时为nil，RecvMsg不再有超时
+	if interceptors.isEnabled("timeout") {
+		t := time.NewTimer(s.RecvMsgTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
 	ch := make(chan error)
-	t := time.NewTimer(s.RecvMsgTimeout)
 	go func() {
-		log.Printf("Waiting to receive a msg: %T", m)
+		debugf("Waiting to receive a msg: %T", m)
 		ch <- s.ServerStream.RecvMsg(m)
 	}()
 	select {
-	case <-t.C:
+	case <-timeout:
 		return status.Error(
 			codes.DeadlineExceeded,
 			"Deadline exceeded",
 		)
 	case err := <-ch:
+		if err == nil && s.call != nil {
+			s.call.addRequest(m)
+		}
+		if err == nil && s.validator != nil {
+			err = s.validator.validate(m)
+		}
 		return err
 	}
 }
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	healthz "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"testing"
+	"time"
+)
+
+// 断言err的状态码和消息
+func assertStatus(t *testing.T, err error, code codes.Code, msg string) {
+	t.Helper()
+	s := status.Convert(err)
+	if s.Code() != code || s.Message() != msg {
+		t.Fatalf("got status %s %q, want %s %q", s.Code(), s.Message(), code, msg)
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	ts := startTestServer(t)
+	resp, err := ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane@doe.com", Id: "foo-bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := resp.User
+	if u.Id != "foo-bar" || u.FirstName != "jane" || u.LastName != "doe.com" {
+		t.Fatalf("unexpected user: %v", u)
+	}
+	ts.assertLogged(t, `Method: /Users/GetUser, Latency: \S+, Error: <nil>`)
+}
+
+func TestGetUserInvalidEmail(t *testing.T) {
+	ts := startTestServer(t)
+	_, err := ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane"})
+	assertStatus(t, err, codes.InvalidArgument, "Invalid UserGetRequest: email: value must be a valid email address")
+	ts.assertLogged(t, `Method: /Users/GetUser, Latency: \S+, Error: rpc error: code = InvalidArgument`)
+}
+
+// 校验拦截器关闭时，由GetUser自己检查邮箱地址并返回错误详情
+func TestGetUserErrorDetails(t *testing.T) {
+	ts := startTestServer(t)
+	interceptors.set("validation", false)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "accept-language", "zh-CN,zh;q=0.9,en;q=0.8")
+	_, err := ts.Users.GetUser(ctx, &svc.UserGetRequest{Email: "jane"})
+	details := status.Convert(err).Details()
+	if len(details) != 3 {
+		t.Fatalf("got %d details, want 3: %v", len(details), details)
+	}
+	br, ok := details[0].(*errdetails.BadRequest)
+	if !ok || len(br.FieldViolations) != 1 || br.FieldViolations[0].Field != "email" {
+		t.Fatalf("unexpected BadRequest: %v", details[0])
+	}
+	info, ok := details[1].(*errdetails.ErrorInfo)
+	if !ok || info.Reason != "INVALID_EMAIL" || info.Domain != ErrorDomain {
+		t.Fatalf("unexpected ErrorInfo: %v", details[1])
+	}
+	lm, ok := details[2].(*errdetails.LocalizedMessage)
+	if !ok || lm.Locale != "zh-CN" {
+		t.Fatalf("unexpected LocalizedMessage: %v", details[2])
+	}
+
+	// 未指定语言时使用DefaultLocale
+	_, err = ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane"})
+	details = status.Convert(err).Details()
+	if lm, ok := details[2].(*errdetails.LocalizedMessage); !ok || lm.Locale != DefaultLocale {
+		t.Fatalf("unexpected LocalizedMessage: %v", details[2])
+	}
+}
+
+func TestGetUserPanicRecovered(t *testing.T) {
+	ts := startTestServer(t)
+	_, err := ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "panic@doe.com"})
+	assertStatus(t, err, codes.Internal, "Unexpected error happened")
+	ts.assertLogged(t, `Panic recovered: I was asked to panic`)
+
+	// panic被恢复后服务端继续正常处理请求
+	_, err = ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane@doe.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnaryTimeout(t *testing.T) {
+	ts := startTestServer(t)
+	ts.injectFaults(faultRule{Method: "/Users/GetUser", Delay: faultDelay(500 * time.Millisecond)})
+	start := time.Now()
+	_, err := ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane@doe.com"})
+	assertStatus(t, err, codes.DeadlineExceeded, "/Users/GetUser: DeadlineExceeded")
+	details := status.Convert(err).Details()
+	if len(details) != 2 {
+		t.Fatalf("got %d details, want ErrorInfo and RetryInfo: %v", len(details), details)
+	}
+	if ri, ok := details[1].(*errdetails.RetryInfo); !ok || ri.RetryDelay.AsDuration() != RetryDelay {
+		t.Fatalf("unexpected RetryInfo: %v", details[1])
+	}
+	if d := time.Since(start); d >= 500*time.Millisecond {
+		t.Fatalf("call took %v, want it to be terminated after 300ms", d)
+	}
+}
+
+func TestInjectedFaults(t *testing.T) {
+	ts := startTestServer(t)
+	ts.injectFaults(faultRule{Method: "/Users/GetUser", Code: codes.Unavailable, Message: "injected"})
+	_, err := ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane@doe.com"})
+	assertStatus(t, err, codes.Unavailable, "injected")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repos, err := ts.Repo.GetRepos(ctx, &svc.RepoGetRequest{})
+	if err == nil {
+		_, err = repos.Recv()
+	}
+	if err != nil { // 其他方法不受影响
+		t.Fatal(err)
+	}
+
+	ts.injectFaults(faultRule{Method: "/Users/*", Panic: true})
+	stream, err := ts.Users.GetHelp(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	assertStatus(t, err, codes.Internal, "Unexpected error happened")
+	ts.assertLogged(t, `Panic recovered: Injected fault`)
+}
+
+func TestFaultTargetedByMetadata(t *testing.T) {
+	ts := startTestServer(t)
+	ts.injectFaults(faultRule{Metadata: map[string]string{"x-chaos": "on"}, Code: codes.ResourceExhausted})
+	_, err := ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane@doe.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-chaos", "on")
+	_, err = ts.Users.GetUser(ctx, &svc.UserGetRequest{Email: "jane@doe.com"})
+	assertStatus(t, err, codes.ResourceExhausted, "Injected fault")
+}
+
+// 按概率未生效的规则不影响后面的规则
+func TestFaultProbabilityFallsThrough(t *testing.T) {
+	ts := startTestServer(t)
+	ts.injectFaults(
+		faultRule{Method: "/Users/GetUser", Probability: 1e-12, Code: codes.ResourceExhausted},
+		faultRule{Method: "/Users/*", Code: codes.Unavailable, Message: "try again"},
+	)
+	_, err := ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "jane@doe.com"})
+	assertStatus(t, err, codes.Unavailable, "try again")
+}
+
+func TestFaultAbortsStream(t *testing.T) {
+	ts := startTestServer(t)
+	// 收到和发送各一条消息后中断流
+	ts.injectFaults(faultRule{Method: "/Users/GetHelp", AbortAfter: 2})
+	stream, err := ts.Users.GetHelp(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"hello", "world"} {
+		err = stream.Send(&svc.UserHelpRequest{Request: msg})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Response != "hello" {
+		t.Fatalf("got response %q, want hello", resp.Response)
+	}
+	_, err = stream.Recv()
+	assertStatus(t, err, codes.Aborted, "Injected fault")
+}
+
+func TestGetHelp(t *testing.T) {
+	ts := startTestServer(t)
+	stream, err := ts.Users.GetHelp(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"hello", "world"} {
+		err = stream.Send(&svc.UserHelpRequest{Request: msg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Response != msg {
+			t.Fatalf("got response %q, want %q", resp.Response, msg)
+		}
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	if err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+	ts.assertLogged(t, `Method: /Users/GetHelp, Latency: \S+, Error: <nil>`)
+}
+
+func TestGetHelpPanicRecovered(t *testing.T) {
+	ts := startTestServer(t)
+	stream, err := ts.Users.GetHelp(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&svc.UserHelpRequest{Request: "panic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	assertStatus(t, err, codes.Internal, "Unexpected error happened")
+	ts.assertLogged(t, `Panic recovered: I was asked to panic`)
+}
+
+func TestGetHelpRecvTimeout(t *testing.T) {
+	ts := startTestServer(t)
+	stream, err := ts.Users.GetHelp(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 不发送消息，服务端在RecvMsgTimeout后结束流
+	_, err = stream.Recv()
+	assertStatus(t, err, codes.DeadlineExceeded, "Deadline exceeded")
+}
+
+func TestGetHelpSessionDedup(t *testing.T) {
+	ts := startTestServer(t)
+	stream, err := ts.Users.GetHelp(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(seq uint64, msg string) {
+		t.Helper()
+		err := stream.Send(&svc.UserHelpRequest{SessionId: "session", Seq: seq, Request: msg})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(1, "hello")
+	send(1, "hello") // 重放的消息不会被再次处理，返回缓存的回复
+	for i := 0; i < 2; i++ {
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Response != "hello" || resp.Ack != 1 {
+			t.Fatalf("unexpected reply: %v", resp)
+		}
+	}
+
+	send(3, "gap")
+	_, err = stream.Recv()
+	assertStatus(t, err, codes.FailedPrecondition, "Unexpected sequence number 3, expected 2")
+	assertReason(t, err, "SEQUENCE_GAP")
+}
+
+// 断言错误附带的ErrorInfo的原因
+func assertReason(t *testing.T, err error, reason string) {
+	t.Helper()
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.Reason == reason {
+			return
+		}
+	}
+	t.Fatalf("error %v has no ErrorInfo with reason %s", err, reason)
+}
+
+// 服务端重启或会话过期后没有会话的状态，客户端重放的消息返回UNKNOWN_SESSION，客户端以新的会话重新发送
+func TestGetHelpUnknownSession(t *testing.T) {
+	ts := startTestServer(t)
+	stream, err := ts.Users.GetHelp(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&svc.UserHelpRequest{SessionId: "lost", Seq: 4, Ack: 3, Request: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	assertStatus(t, err, codes.FailedPrecondition, "Unexpected sequence number 4, expected 1")
+	assertReason(t, err, "UNKNOWN_SESSION")
+}
+
+func TestHelpSessionsSweep(t *testing.T) {
+	s := newHelpSessions()
+	idle := s.get("idle")
+	s.get("active")
+	now := time.Now()
+	idle.lastSeen = now.Add(-HelpSessionTTL - time.Second)
+
+	if n := s.sweep(now); n != 1 {
+		t.Fatalf("swept %d sessions, want 1", n)
+	}
+	if _, ok := s.sessions["idle"]; ok {
+		t.Fatal("idle session was not swept")
+	}
+	if _, ok := s.sessions["active"]; !ok {
+		t.Fatal("active session was swept")
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.run(stop)
+		close(done)
+	}()
+	close(stop)
+	<-done
+}
+
+func TestGetHelpSessionMissingSeq(t *testing.T) {
+	ts := startTestServer(t)
+	stream, err := ts.Users.GetHelp(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&svc.UserHelpRequest{SessionId: "session", Request: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	assertStatus(t, err, codes.InvalidArgument, "Sequence number must be set when session_id is set")
+}
+
+func TestGetHelpDraining(t *testing.T) {
+	ts := startTestServer(t)
+	ts.drainer.start()
+	stream, err := ts.Users.GetHelp(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	assertStatus(t, err, codes.Unavailable, "Server is shutting down")
+}
+
+// 排空时结束健康检查的Watch流，GracefulStop不需要等待客户端健康检查
+func TestHealthWatchDraining(t *testing.T) {
+	ts := startTestServer(t)
+	stream, err := ts.Health.Watch(context.Background(), &healthz.HealthCheckRequest{Service: svc.Users_ServiceDesc.ServiceName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil || resp.Status != healthz.HealthCheckResponse_SERVING {
+		t.Fatalf("unexpected status %v: %v", resp, err)
+	}
+	ts.drainer.start()
+	_, err = stream.Recv()
+	assertStatus(t, err, codes.Unavailable, "Server is shutting down")
+}
+
+func TestGetRepos(t *testing.T) {
+	ts := startTestServer(t)
+	// GetRepos每2秒发送一个仓库，只检查第一个
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := ts.Repo.GetRepos(ctx, &svc.RepoGetRequest{CreatorId: "user-123", Id: "repo-123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Repo.Id != "repo-123" || resp.Repo.Name != "repo-1" || resp.Repo.Owner.Id != "user-123" {
+		t.Fatalf("unexpected repo: %v", resp.Repo)
+	}
+}
+
+func TestCreateRepo(t *testing.T) {
+	ts := startTestServer(t)
+	stream, err := ts.Repo.CreateRepo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := []*svc.RepoCreateRequest{
+		{Body: &svc.RepoCreateRequest_Context{Context: &svc.RepoContext{CreatorId: "user-123", Name: "test-repo"}}},
+		{Body: &svc.RepoCreateRequest_Data{Data: []byte("hello ")}},
+		{Body: &svc.RepoCreateRequest_Data{Data: []byte("world")}},
+	}
+	for _, r := range requests {
+		err = stream.Send(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Size != int64(len("hello world")) || resp.Repo.Name != "test-repo" {
+		t.Fatalf("unexpected reply: %v", resp)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	ts := startTestServer(t)
+	check := func(service string, want healthz.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		resp, err := ts.Health.Check(context.Background(), &healthz.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != want {
+			t.Fatalf("%q is %v, want %v", service, resp.Status, want)
+		}
+	}
+	check("", healthz.HealthCheckResponse_SERVING)
+	check("Users", healthz.HealthCheckResponse_SERVING)
+	check("Repo", healthz.HealthCheckResponse_SERVING)
+
+	ts.setHealth("Users", healthz.HealthCheckResponse_NOT_SERVING)
+	check("Users", healthz.HealthCheckResponse_NOT_SERVING)
+	check("Repo", healthz.HealthCheckResponse_SERVING)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+// 会话空闲超过该时间后被清理
+const HelpSessionTTL = time.Minute * 10
+
+// 检查并清理空闲会话的时间间隔
+const HelpSessionSweepInterval = time.Minute
+
+// helpSessions 保存GetHelp的会话状态，按会话ID索引。
+// 客户端流重建后会重放未被确认的消息，服务端根据序号去重，保证每条消息只被处理一次。
+// 会话只保存在当前进程的内存中，服务端重启、流被路由到其他后端或会话过期后，重放的消息返回FailedPrecondition，
+// 客户端以新的会话重新发送
+type helpSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*helpSession
+}
+
+type helpSession struct {
+	mu       sync.Mutex
+	lastSeq  uint64                        // 已处理的最大消息序号
+	replies  map[uint64]*svc.UserHelpReply // 客户端尚未确认收到的回复，重放时直接返回
+	lastSeen time.Time
+}
+
+func newHelpSessions() *helpSessions {
+	return &helpSessions{sessions: make(map[string]*helpSession)}
+}
+
+// 获取会话，不存在则创建
+func (s *helpSessions) get(id string) *helpSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hs, ok := s.sessions[id]
+	if !ok {
+		hs = &helpSession{replies: make(map[uint64]*svc.UserHelpReply)}
+		s.sessions[id] = hs
+	}
+	hs.mu.Lock()
+	hs.lastSeen = time.Now()
+	hs.mu.Unlock()
+
+	return hs
+}
+
+// 每隔HelpSessionSweepInterval清理一次空闲的会话，直到stop被关闭
+func (s *helpSessions) run(stop <-chan struct{}) {
+	t := time.NewTicker(HelpSessionSweepInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			s.sweep(now)
+		}
+	}
+}
+
+// 删除空闲超过HelpSessionTTL的会话，返回删除的数量
+func (s *helpSessions) sweep(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, hs := range s.sessions {
+		hs.mu.Lock()
+		idle := now.Sub(hs.lastSeen)
+		hs.mu.Unlock()
+		if idle > HelpSessionTTL {
+			delete(s.sessions, id)
+			n++
+		}
+	}
+	return n
+}
+
+// 按序号处理请求：已处理过的序号返回缓存的回复（客户端已确认收到的回复返回nil，无需再发送），
+// 下一个序号调用handle处理，出现序号空洞时返回FailedPrecondition，会话中还没有处理过消息时原因为UNKNOWN_SESSION，
+// 通常是服务端没有该会话的状态，否则为SEQUENCE_GAP。序号从1开始，为0时返回InvalidArgument
+func (hs *helpSession) process(
+	request *svc.UserHelpRequest,
+	handle func(*svc.UserHelpRequest) *svc.UserHelpReply,
+) (*svc.UserHelpReply, error) {
+	if request.Seq == 0 { // 否则会被当作已处理过的消息，返回不存在的回复，客户端一直等待
+		return nil, status.Error(codes.InvalidArgument, "Sequence number must be set when session_id is set")
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	for seq := range hs.replies {
+		if seq <= request.Ack {
+			delete(hs.replies, seq)
+		}
+	}
+
+	if request.Seq <= hs.lastSeq {
+		return hs.replies[request.Seq], nil
+	}
+	if request.Seq != hs.lastSeq+1 {
+		reason := "SEQUENCE_GAP"
+		if hs.lastSeq == 0 {
+			reason = "UNKNOWN_SESSION"
+		}
+		return nil, withDetails(
+			status.Newf(codes.FailedPrecondition, "Unexpected sequence number %d, expected %d", request.Seq, hs.lastSeq+1),
+			errorInfo(reason, map[string]string{"session_id": request.SessionId}),
+		)
+	}
+
+	reply := handle(request)
+	reply.Ack = request.Seq
+	hs.lastSeq = request.Seq
+	hs.replies[request.Seq] = reply
+
+	return reply, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthsvc "google.golang.org/grpc/health"
+	healthz "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 健康状态置为NOT_SERVING后，等待状态传播到客户端和负载均衡器的默认时间
+const DefaultShutdownDelay = time.Second * 5
+
+// GracefulStop等待进行中的RPC完成的默认最长时间，超时后强制Stop
+const DefaultShutdownTimeout = time.Second * 10
+
+// drainer 在服务端开始排空时通知GetHelp等长时间运行的流结束
+type drainer struct {
+	ch   chan struct{}
+	once sync.Once
+}
+
+func newDrainer() *drainer {
+	return &drainer{ch: make(chan struct{})}
+}
+
+func (d *drainer) start() {
+	d.once.Do(func() {
+		close(d.ch)
+	})
+}
+
+func (d *drainer) draining() <-chan struct{} {
+	return d.ch
+}
+
+// drainingHealthServer 服务端开始排空时结束health的Watch流。客户端健康检查在每个连接上都保持一个Watch流，
+// 不结束的话GracefulStop总是要等到SHUTDOWN_TIMEOUT后强制Stop
+type drainingHealthServer struct {
+	*healthsvc.Server
+	drainer *drainer
+}
+
+func (s drainingHealthServer) Watch(in *healthz.HealthCheckRequest, stream healthz.Health_WatchServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.drainer.draining():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	err := s.Server.Watch(in, drainingWatchStream{Health_WatchServer: stream, ctx: ctx})
+	select {
+	case <-s.drainer.draining(): // 客户端收到UNAVAILABLE后重建Watch流，连接到其他后端
+		return status.Error(codes.Unavailable, "Server is shutting down")
+	default:
+		return err
+	}
+}
+
+type drainingWatchStream struct {
+	healthz.Health_WatchServer
+	ctx context.Context
+}
+
+func (s drainingWatchStream) Context() context.Context {
+	return s.ctx
+}
+
+// shutdownTrigger 收到信号或调用Admin.Drain时触发优雅关闭，只有第一次触发生效
+type shutdownTrigger struct {
+	ch   chan string
+	once sync.Once
+}
+
+func newShutdownTrigger() *shutdownTrigger {
+	return &shutdownTrigger{ch: make(chan string, 1)}
+}
+
+// 返回是否是第一次触发
+func (t *shutdownTrigger) fire(reason string) bool {
+	fired := false
+	t.once.Do(func() {
+		t.ch <- reason
+		fired = true
+	})
+	return fired
+}
+
+// 从环境变量SHUTDOWN_DELAY和SHUTDOWN_TIMEOUT读取优雅关闭的等待时间，例如 SHUTDOWN_DELAY=2s
+func shutdownDurations() (time.Duration, time.Duration, error) {
+	delay, err := durationFromEnv("SHUTDOWN_DELAY", DefaultShutdownDelay)
+	if err != nil {
+		return 0, 0, err
+	}
+	timeout, err := durationFromEnv("SHUTDOWN_TIMEOUT", DefaultShutdownTimeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	return delay, timeout, nil
+}
+
+func durationFromEnv(key string, def time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return d, nil
+}
+
+// 收到SIGINT或SIGTERM，或者通过trigger触发后优雅关闭服务端，关闭完成后关闭返回的channel
+func stopOnSignal(
+	s *grpc.Server,
+	h *healthsvc.Server,
+	d *drainer,
+	trigger *shutdownTrigger,
+	delay, timeout time.Duration,
+) <-chan struct{} {
+	stopped := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		var reason string
+		select {
+		case sig := <-sigs:
+			reason = sig.String()
+			trigger.fire(reason) // 之后的Admin.Drain返回正在关闭
+		case reason = <-trigger.ch:
+		}
+		signal.Stop(sigs)
+		log.Printf("Received %s, shutting down", reason)
+		stopServer(s, h, d, delay, timeout)
+		close(stopped)
+	}()
+	return stopped
+}
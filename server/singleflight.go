@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认合并并发请求的方法
+const DefaultSingleflightMethods = "/Users/GetUser"
+
+// coalescer 合并进行中的相同一元请求（方法名和确定性序列化的请求相同），处理函数只执行一次，所有调用方得到相同的回复。
+// 处理函数在单独的goroutine中以脱离调用方的context执行，第一个调用方（leader）取消后其余调用方仍能得到结果，
+// 所有调用方都离开后才取消处理函数的context。处理函数使用leader的元数据，错误可能与leader相关（例如按accept-language
+// 本地化的消息），因此只共享成功的回复，失败时其余调用方各自以自己的context重新调用处理函数
+type coalescer struct {
+	methods map[string]bool
+
+	mu      sync.Mutex
+	flights map[string]*flight
+	stats   map[string]*methodFlightStats
+}
+
+type flight struct {
+	done     chan struct{}
+	resp     interface{}
+	err      error
+	panicked interface{} // 处理函数panic的值，在每个调用方中重新panic，由panic处理拦截器恢复
+	waiters  int         // 仍在等待结果的调用方，由coalescer.mu保护
+	cancel   context.CancelFunc
+}
+
+type methodFlightStats struct {
+	Method    string `json:"method"`
+	Executed  int64  `json:"executed"`  // 处理函数执行的次数
+	Shared    int64  `json:"shared"`    // 得到其他调用方的回复的调用次数
+	Abandoned int64  `json:"abandoned"` // 结果返回前调用方取消或超时的次数
+}
+
+// SINGLEFLIGHT_METHODS为逗号分隔的完整方法名，只合并这些方法的请求，设置为空时不合并
+func setupCoalescer() *coalescer {
+	methods, ok := os.LookupEnv("SINGLEFLIGHT_METHODS")
+	if !ok {
+		methods = DefaultSingleflightMethods
+	}
+	c := newCoalescer(strings.Split(methods, ",")...)
+	if len(c.methods) == 0 {
+		return nil
+	}
+	return c
+}
+
+func newCoalescer(methods ...string) *coalescer {
+	c := &coalescer{
+		methods: make(map[string]bool),
+		flights: make(map[string]*flight),
+		stats:   make(map[string]*methodFlightStats),
+	}
+	for _, m := range methods {
+		m = strings.TrimSpace(m)
+		if len(m) != 0 {
+			c.methods[m] = true
+			c.stats[m] = &methodFlightStats{Method: m}
+		}
+	}
+	return c
+}
+
+// detachedContext 保留调用方context中的值（元数据、对端地址等），但不会被调用方取消，也没有截止时间
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// 加入进行中的相同请求，没有时以ctx中的值开始执行handler，返回的shared表示是否加入了其他调用方的请求
+func (c *coalescer) join(ctx context.Context, key, method string, req interface{}, handler grpc.UnaryHandler) (f *flight, shared bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.flights[key]; ok {
+		f.waiters++
+		c.stats[method].Shared++
+		return f, true
+	}
+	hctx, cancel := context.WithCancel(detachedContext{ctx})
+	f = &flight{done: make(chan struct{}), waiters: 1, cancel: cancel}
+	c.flights[key] = f
+	c.stats[method].Executed++
+	go c.run(hctx, f, key, req, handler)
+	return f, false
+}
+
+func (c *coalescer) run(ctx context.Context, f *flight, key string, req interface{}, handler grpc.UnaryHandler) {
+	defer func() {
+		f.panicked = recover()
+		c.mu.Lock()
+		if c.flights[key] == f {
+			delete(c.flights, key)
+		}
+		c.mu.Unlock()
+		f.cancel()
+		close(f.done)
+	}()
+	f.resp, f.err = handler(ctx, req)
+}
+
+// 调用方在结果返回前离开，最后一个调用方离开时取消处理函数，之后相同的请求重新执行
+func (c *coalescer) leave(f *flight, key, method string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats[method].Abandoned++
+	f.waiters--
+	if f.waiters > 0 {
+		return
+	}
+	if c.flights[key] == f {
+		delete(c.flights, key)
+	}
+	f.cancel()
+}
+
+func (c *coalescer) list() []methodFlightStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make([]methodFlightStats, 0, len(c.stats))
+	for _, s := range c.stats {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Method < stats[j].Method })
+	return stats
+}
+
+func (c *coalescer) String() string {
+	if c == nil {
+		return "SINGLEFLIGHT_METHODS not set"
+	}
+	var methods []string
+	for _, s := range c.list() {
+		methods = append(methods, fmt.Sprintf("%s(executed=%d shared=%d abandoned=%d)", s.Method, s.Executed, s.Shared, s.Abandoned))
+	}
+	return "methods=" + strings.Join(methods, ",")
+}
+
+// 服务端，合并相同的并发一元请求的拦截器，位于缓存拦截器之内，缓存未命中时同时到达的相同请求只调用一次处理函数。
+// 每个调用方按自己的context等待，取消或超时时返回CANCELED或DEADLINE_EXCEEDED，不影响其他调用方
+func (c *coalescer) unaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if c == nil || !c.methods[info.FullMethod] || !interceptors.isEnabled("singleflight") {
+		return handler(ctx, req)
+	}
+	key, ok := cacheKey(info.FullMethod, req)
+	if !ok {
+		return handler(ctx, req)
+	}
+	f, shared := c.join(ctx, key, info.FullMethod, req, handler)
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		c.leave(f, key, info.FullMethod)
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	if f.panicked != nil {
+		panic(f.panicked)
+	}
+	if !shared {
+		return f.resp, f.err
+	}
+	if f.err != nil {
+		c.unshare(info.FullMethod)
+		debugf("In-flight call of %s failed, calling the handler again: %v", info.FullMethod, f.err)
+		return handler(ctx, req)
+	}
+	debugf("Shared in-flight result of %s", info.FullMethod)
+	if m, ok := f.resp.(proto.Message); ok { // 调用方可能修改回复，其他调用方得到副本
+		return proto.Clone(m), nil
+	}
+	return f.resp, nil
+}
+
+// 加入的请求失败，调用方自己执行处理函数
+func (c *coalescer) unshare(method string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats[method].Shared--
+	c.stats[method].Executed++
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+var getUserInfo = &grpc.UnaryServerInfo{FullMethod: "/Users/GetUser"}
+
+// 阻塞到release被关闭的处理函数，记录调用次数和最后一次调用的context
+type blockingHandler struct {
+	calls   int32
+	release chan struct{}
+	mu      sync.Mutex
+	ctx     context.Context
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{release: make(chan struct{})}
+}
+
+func (h *blockingHandler) handle(ctx context.Context, req interface{}) (interface{}, error) {
+	atomic.AddInt32(&h.calls, 1)
+	h.mu.Lock()
+	h.ctx = ctx
+	h.mu.Unlock()
+	<-h.release
+	in := req.(*svc.UserGetRequest)
+	return &svc.UserGetReply{User: &svc.User{Id: in.Id}}, nil
+}
+
+func (h *blockingHandler) handlerContext() context.Context {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ctx
+}
+
+type flightResult struct {
+	resp interface{}
+	err  error
+}
+
+// 在goroutine中调用拦截器，结果写入返回的channel
+func (c *coalescer) call(ctx context.Context, in *svc.UserGetRequest, h *blockingHandler) <-chan flightResult {
+	ch := make(chan flightResult, 1)
+	go func() {
+		resp, err := c.unaryInterceptor(ctx, in, getUserInfo, h.handle)
+		ch <- flightResult{resp, err}
+	}()
+	return ch
+}
+
+// 等待n个调用加入进行中的请求
+func waitShared(t *testing.T, c *coalescer, n int64) {
+	t.Helper()
+	waitFor(t, func() bool { return c.list()[0].Shared == n })
+}
+
+func TestSingleflight(t *testing.T) {
+	resetRuntimeConfig(t)
+	c := newCoalescer("/Users/GetUser")
+	h := newBlockingHandler()
+	in := &svc.UserGetRequest{Email: "jane@doe.com", Id: "1"}
+	var results []<-chan flightResult
+	for i := 0; i < 5; i++ {
+		results = append(results, c.call(context.Background(), in, h))
+		if i == 0 {
+			waitFor(t, func() bool { return atomic.LoadInt32(&h.calls) == 1 })
+		}
+	}
+	waitShared(t, c, 4)
+	close(h.release)
+
+	replies := make(map[*svc.UserGetReply]bool)
+	for _, ch := range results {
+		r := <-ch
+		if r.err != nil || r.resp.(*svc.UserGetReply).User.Id != "1" {
+			t.Fatalf("unexpected result %v: %v", r.resp, r.err)
+		}
+		replies[r.resp.(*svc.UserGetReply)] = true
+	}
+	if n := atomic.LoadInt32(&h.calls); n != 1 {
+		t.Fatalf("handler called %d times, want 1", n)
+	}
+	if len(replies) != 5 {
+		t.Fatalf("got %d distinct replies, want a copy for each caller", len(replies))
+	}
+	if s := c.list()[0]; s.Executed != 1 || s.Shared != 4 || s.Abandoned != 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+
+	// 结束后相同的请求重新执行，其他请求和未开启的方法不合并
+	r := <-c.call(context.Background(), in, h)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	r = <-c.call(context.Background(), &svc.UserGetRequest{Email: "jane@doe.com", Id: "2"}, h)
+	if r.err != nil || r.resp.(*svc.UserGetReply).User.Id != "2" {
+		t.Fatalf("unexpected result %v: %v", r.resp, r.err)
+	}
+	_, err := c.unaryInterceptor(context.Background(), in, &grpc.UnaryServerInfo{FullMethod: "/Users/Other"}, h.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interceptors.set("singleflight", false)
+	_, err = c.unaryInterceptor(context.Background(), in, getUserInfo, h.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&h.calls); n != 5 {
+		t.Fatalf("handler called %d times, want 5", n)
+	}
+}
+
+// leader取消后其余调用方仍得到结果，所有调用方都离开后处理函数的context才被取消
+func TestSingleflightLeaderCanceled(t *testing.T) {
+	resetRuntimeConfig(t)
+	c := newCoalescer("/Users/GetUser")
+	h := newBlockingHandler()
+	in := &svc.UserGetRequest{Email: "jane@doe.com", Id: "1"}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leader := c.call(leaderCtx, in, h)
+	waitFor(t, func() bool { return atomic.LoadInt32(&h.calls) == 1 })
+	followerCtx, cancelFollower := context.WithCancel(context.Background())
+	defer cancelFollower()
+	follower := c.call(followerCtx, in, h)
+	waitShared(t, c, 1)
+
+	cancelLeader()
+	r := <-leader
+	assertStatus(t, r.err, codes.Canceled, "context canceled")
+	if err := h.handlerContext().Err(); err != nil {
+		t.Fatalf("handler context done after leader left: %v", err)
+	}
+	close(h.release)
+	r = <-follower
+	if r.err != nil || r.resp.(*svc.UserGetReply).User.Id != "1" {
+		t.Fatalf("unexpected result %v: %v", r.resp, r.err)
+	}
+
+	h = newBlockingHandler()
+	ctx, cancel := context.WithCancel(context.Background())
+	first := c.call(ctx, in, h)
+	second := c.call(ctx, in, h)
+	waitShared(t, c, 2)
+	cancel()
+	<-first
+	<-second
+	waitFor(t, func() bool { return h.handlerContext().Err() != nil })
+	if s := c.list()[0]; s.Executed != 2 || s.Abandoned != 3 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	close(h.release)
+}
+
+// 处理函数panic时每个调用方都由panic处理拦截器恢复，返回INTERNAL
+func TestSingleflightPanic(t *testing.T) {
+	resetRuntimeConfig(t)
+	c := newCoalescer("/Users/GetUser")
+	release := make(chan struct{})
+	var calls int32
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		panic("I was asked to panic")
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = panicUnaryInterceptor(context.Background(), &svc.UserGetRequest{Id: "1"}, getUserInfo,
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					return c.unaryInterceptor(ctx, req, getUserInfo, handler)
+				})
+		}(i)
+	}
+	waitShared(t, c, 2)
+	close(release)
+	wg.Wait()
+	for _, err := range errs {
+		assertStatus(t, err, codes.Internal, "Unexpected error happened")
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("handler called %d times, want 1", n)
+	}
+}
+
+func TestSingleflightFromEnv(t *testing.T) {
+	t.Setenv("SINGLEFLIGHT_METHODS", "")
+	if c := setupCoalescer(); c != nil {
+		t.Fatalf("got %v, want singleflight disabled", c)
+	}
+	t.Setenv("SINGLEFLIGHT_METHODS", "/Users/GetUser, /Repo/GetRepos")
+	c := setupCoalescer()
+	if got := c.String(); got != "methods=/Repo/GetRepos(executed=0 shared=0 abandoned=0),/Users/GetUser(executed=0 shared=0 abandoned=0)" {
+		t.Fatalf("unexpected config: %s", got)
+	}
+}
+
+// 只共享成功的回复，leader失败时其余调用方以自己的元数据重新调用处理函数，得到各自语言的错误
+func TestSingleflightErrorNotShared(t *testing.T) {
+	resetRuntimeConfig(t)
+	c := newCoalescer("/Users/GetUser")
+	release := make(chan struct{})
+	var calls int32
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-release
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		return nil, status.Errorf(codes.NotFound, "user not found (%s)", strings.Join(md.Get("accept-language"), ","))
+	}
+	in := &svc.UserGetRequest{Email: "jane@doe.com", Id: "1"}
+	call := func(lang string) <-chan flightResult {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("accept-language", lang))
+		ch := make(chan flightResult, 1)
+		go func() {
+			resp, err := c.unaryInterceptor(ctx, in, getUserInfo, handler)
+			ch <- flightResult{resp, err}
+		}()
+		return ch
+	}
+	leader := call("en-US")
+	waitFor(t, func() bool { return atomic.LoadInt32(&calls) == 1 })
+	follower := call("zh-CN")
+	waitShared(t, c, 1)
+	close(release)
+
+	assertStatus(t, (<-leader).err, codes.NotFound, "user not found (en-US)")
+	assertStatus(t, (<-follower).err, codes.NotFound, "user not found (zh-CN)")
+	if s := c.list()[0]; s.Executed != 2 || s.Shared != 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"google.golang.org/grpc/stats"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// callTracker 通过grpc.StatsHandler跟踪活动的连接和进行中的调用，供Admin服务列出和强制结束调用
+type callTracker struct {
+	mu     sync.Mutex
+	nextID uint64
+	conns  map[uint64]*trackedConn
+	calls  map[uint64]*trackedCall
+}
+
+type trackedConn struct {
+	id         uint64
+	remoteAddr net.Addr
+	localAddr  net.Addr
+	start      time.Time
+}
+
+type trackedCall struct {
+	id           uint64
+	connID       uint64
+	method       string
+	clientStream bool
+	serverStream bool
+	start        time.Time
+	cancel       context.CancelFunc
+	flow         *flowStats // 流的发送统计，由flowStreamInterceptor更新
+}
+
+type connIDKey struct{}
+
+type callIDKey struct{}
+
+func newCallTracker() *callTracker {
+	return &callTracker{
+		conns: make(map[uint64]*trackedConn),
+		calls: make(map[uint64]*trackedCall),
+	}
+}
+
+func (t *callTracker) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	c := &trackedConn{id: t.nextID, remoteAddr: info.RemoteAddr, localAddr: info.LocalAddr}
+	t.conns[c.id] = c
+	return context.WithValue(ctx, connIDKey{}, c.id)
+}
+
+func (t *callTracker) HandleConn(ctx context.Context, s stats.ConnStats) {
+	id, _ := ctx.Value(connIDKey{}).(uint64)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch s.(type) {
+	case *stats.ConnBegin:
+		if c, ok := t.conns[id]; ok {
+			c.start = time.Now()
+		}
+	case *stats.ConnEnd:
+		delete(t.conns, id)
+	}
+}
+
+// 调用的context可以被取消，用于强制结束调用
+func (t *callTracker) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	connID, _ := ctx.Value(connIDKey{}).(uint64)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	c := &trackedCall{id: t.nextID, connID: connID, method: info.FullMethodName, start: time.Now(), cancel: cancel, flow: &flowStats{}}
+	t.calls[c.id] = c
+	ctx = context.WithValue(ctx, flowStatsKey{}, c.flow)
+	return context.WithValue(ctx, callIDKey{}, c.id)
+}
+
+func (t *callTracker) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	id, _ := ctx.Value(callIDKey{}).(uint64)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch s := s.(type) {
+	case *stats.Begin:
+		if c, ok := t.calls[id]; ok {
+			c.clientStream = s.IsClientStream
+			c.serverStream = s.IsServerStream
+		}
+	case *stats.End:
+		if c, ok := t.calls[id]; ok {
+			c.cancel()
+			delete(t.calls, id)
+		}
+	}
+}
+
+// 强制结束调用，服务端的处理函数从context或RecvMsg得到Canceled，返回调用是否存在
+func (t *callTracker) cancel(id uint64) bool {
+	t.mu.Lock()
+	c, ok := t.calls[id]
+	t.mu.Unlock()
+	if ok {
+		c.cancel()
+	}
+	return ok
+}
+
+// 返回按ID排序的连接和进行中的调用的快照，调用按连接ID分组
+func (t *callTracker) snapshot() ([]trackedConn, map[uint64][]trackedCall) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	conns := make([]trackedConn, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, *c)
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+	calls := make(map[uint64][]trackedCall)
+	for _, c := range t.calls {
+		calls[c.connID] = append(calls[c.connID], *c)
+	}
+	for _, cs := range calls {
+		sort.Slice(cs, func(i, j int) bool { return cs[i].id < cs[j].id })
+	}
+	return conns, calls
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/protobuf/proto"
+	"sync"
+)
+
+// userStore 保存通过UpdateUser修改过的用户，按用户ID索引。数据只保存在当前进程的内存中，重启后丢失
+type userStore struct {
+	mu    sync.Mutex
+	users map[string]*svc.User
+}
+
+func newUserStore() *userStore {
+	return &userStore{users: make(map[string]*svc.User)}
+}
+
+// 返回用户的副本，用户未被修改过时返回nil
+func (us *userStore) get(id string) *svc.User {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	u, ok := us.users[id]
+	if !ok {
+		return nil
+	}
+	return proto.Clone(u).(*svc.User)
+}
+
+// 保存用户的副本，替换之前的数据
+func (us *userStore) put(u *svc.User) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	us.users[u.Id] = proto.Clone(u).(*svc.User)
+}
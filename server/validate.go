@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// validator 按消息定义中的(constraints)和(oneof_constraints)选项（service/validate.proto）校验请求，
+// 校验失败时返回携带BadRequest字段错误的InvalidArgument错误
+type validator struct {
+	patterns map[string]*regexp.Regexp // 编译后的正则，按表达式索引，创建后只读
+}
+
+// 编译files中所有消息字段的(constraints).pattern，有无效的正则时返回错误，服务端拒绝启动
+func newValidator(files *protoregistry.Files) (*validator, error) {
+	v := &validator{patterns: make(map[string]*regexp.Regexp)}
+	var err error
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		err = v.compileMessages(fd.Messages())
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// 编译消息及其嵌套消息中字段的正则
+func (v *validator) compileMessages(messages protoreflect.MessageDescriptors) error {
+	for i := 0; i < messages.Len(); i++ {
+		md := messages.Get(i)
+		fields := md.Fields()
+		for j := 0; j < fields.Len(); j++ {
+			fd := fields.Get(j)
+			rules, _ := proto.GetExtension(fd.Options(), svc.E_Constraints).(*svc.FieldConstraints)
+			expr := rules.GetPattern()
+			if len(expr) == 0 || v.patterns[expr] != nil {
+				continue
+			}
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("invalid (constraints).pattern of %s: %v", fd.FullName(), err)
+			}
+			v.patterns[expr] = re
+		}
+		err := v.compileMessages(md.Messages())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 服务端，一元请求校验拦截器
+func (v *validator) unaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if !interceptors.isEnabled("validation") {
+		return handler(ctx, req)
+	}
+	err := v.validate(req)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// 服务端，流请求校验拦截器，通过wrappedServerStream校验收到的每一条消息
+func (v *validator) streamInterceptor(
+	srv interface{},
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if !interceptors.isEnabled("validation") {
+		return handler(srv, stream)
+	}
+	serverStream := wrappedServerStream{ServerStream: stream, RecvMsgTimeout: RecvMsgTimeout, validator: v}
+	return handler(srv, serverStream)
+}
+
+func (v *validator) validate(req interface{}) error {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return nil
+	}
+	var violations []*errdetails.BadRequest_FieldViolation
+	v.check(m.ProtoReflect(), "", &violations)
+	if len(violations) == 0 {
+		return nil
+	}
+	name := string(m.ProtoReflect().Descriptor().FullName())
+	var msgs []string
+	for _, fv := range violations {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", fv.Field, fv.Description))
+	}
+	return withDetails(
+		status.New(codes.InvalidArgument, fmt.Sprintf("Invalid %s: %s", name, strings.Join(msgs, "; "))),
+		&errdetails.BadRequest{FieldViolations: violations},
+		errorInfo("VALIDATION_FAILED", map[string]string{"message": name}),
+	)
+}
+
+// 校验消息的oneof和字段，prefix为嵌套消息的字段路径，例如 user.
+func (v *validator) check(m protoreflect.Message, prefix string, violations *[]*errdetails.BadRequest_FieldViolation) {
+	add := func(field, desc string) {
+		*violations = append(*violations, &errdetails.BadRequest_FieldViolation{Field: prefix + field, Description: desc})
+	}
+
+	oneofs := m.Descriptor().Oneofs()
+	for i := 0; i < oneofs.Len(); i++ {
+		od := oneofs.Get(i)
+		rules, _ := proto.GetExtension(od.Options(), svc.E_OneofConstraints).(*svc.OneofConstraints)
+		if rules.GetRequired() && m.WhichOneof(od) == nil {
+			add(string(od.Name()), "exactly one field is required")
+		}
+	}
+
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		rules, _ := proto.GetExtension(fd.Options(), svc.E_Constraints).(*svc.FieldConstraints)
+		name := string(fd.Name())
+		if !m.Has(fd) {
+			if rules.GetRequired() {
+				add(name, "value is required")
+			}
+			continue
+		}
+		switch {
+		case fd.IsMap():
+		case fd.IsList():
+			if fd.Message() != nil {
+				l := m.Get(fd).List()
+				for j := 0; j < l.Len(); j++ {
+					v.check(l.Get(j).Message(), fmt.Sprintf("%s%s[%d].", prefix, name, j), violations)
+				}
+			}
+		case fd.Message() != nil:
+			v.check(m.Get(fd).Message(), prefix+name+".", violations)
+		case rules != nil:
+			for _, desc := range v.checkValue(fd, m.Get(fd), rules) {
+				add(name, desc)
+			}
+		}
+	}
+}
+
+// 校验字符串、bytes和整数字段的值，返回不满足的规则的描述
+func (v *validator) checkValue(fd protoreflect.FieldDescriptor, value protoreflect.Value, rules *svc.FieldConstraints) []string {
+	var descs []string
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		s := value.String()
+		n := uint64(utf8.RuneCountInString(s))
+		if rules.MinLen > 0 && n < rules.MinLen {
+			descs = append(descs, fmt.Sprintf("value length must be at least %d characters", rules.MinLen))
+		}
+		if rules.MaxLen > 0 && n > rules.MaxLen {
+			descs = append(descs, fmt.Sprintf("value length must be at most %d characters", rules.MaxLen))
+		}
+		if re := v.patterns[rules.Pattern]; re != nil && !re.MatchString(s) {
+			descs = append(descs, fmt.Sprintf("value does not match regex pattern %q", rules.Pattern))
+		}
+		if rules.Email && !isEmail(s) {
+			descs = append(descs, "value must be a valid email address")
+		}
+	case protoreflect.BytesKind:
+		n := uint64(len(value.Bytes()))
+		if rules.MinLen > 0 && n < rules.MinLen {
+			descs = append(descs, fmt.Sprintf("value must be at least %d bytes", rules.MinLen))
+		}
+		if rules.MaxLen > 0 && n > rules.MaxLen {
+			descs = append(descs, fmt.Sprintf("value must be at most %d bytes", rules.MaxLen))
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n := value.Int()
+		if rules.Gte != nil && n < *rules.Gte {
+			descs = append(descs, fmt.Sprintf("value must be greater than or equal to %d", *rules.Gte))
+		}
+		if rules.Lte != nil && n > *rules.Lte {
+			descs = append(descs, fmt.Sprintf("value must be less than or equal to %d", *rules.Lte))
+		}
+	}
+	return descs
+}
+
+// 只接受不带显示名称的地址，例如 jane@doe.com
+func isEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"strings"
+	"testing"
+)
+
+// 返回错误中BadRequest的字段路径
+func violatedFields(t *testing.T, err error) []string {
+	t.Helper()
+	var fields []string
+	for _, d := range status.Convert(err).Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, fv := range br.FieldViolations {
+				fields = append(fields, fv.Field)
+			}
+		}
+	}
+	return fields
+}
+
+func TestValidateUnary(t *testing.T) {
+	ts := startTestServer(t)
+	_, err := ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Id: "not valid!"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("got %v, want InvalidArgument", err)
+	}
+	if got := strings.Join(violatedFields(t, err), ","); got != "email,id" {
+		t.Fatalf("got violations %s, want email,id", got)
+	}
+
+	_, err = ts.Users.GetUser(context.Background(), &svc.UserGetRequest{Email: "Jane <jane@doe.com>"})
+	assertStatus(t, err, codes.InvalidArgument, "Invalid UserGetRequest: email: value must be a valid email address")
+	// 服务端流方法的请求同样经过流校验拦截器
+	repos, err := ts.Repo.GetRepos(context.Background(), &svc.RepoGetRequest{CreatorId: strings.Repeat("a", 65)})
+	if err == nil {
+		_, err = repos.Recv()
+	}
+	assertStatus(t, err, codes.InvalidArgument, "Invalid RepoGetRequest: creator_id: value length must be at most 64 characters")
+}
+
+func TestValidateStream(t *testing.T) {
+	ts := startTestServer(t)
+	stream, err := ts.Users.GetHelp(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&svc.UserHelpRequest{Request: "hello", User: &svc.User{FirstName: "jane", Age: 200}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	assertStatus(t, err, codes.InvalidArgument, "Invalid UserHelpRequest: user.age: value must be less than or equal to 150")
+}
+
+func TestValidateOneofRequired(t *testing.T) {
+	ts := startTestServer(t)
+	stream, err := ts.Repo.CreateRepo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := []*svc.RepoCreateRequest{
+		{Body: &svc.RepoCreateRequest_Context{Context: &svc.RepoContext{CreatorId: "user-123", Name: "test-repo"}}},
+		{},
+	}
+	for _, r := range requests {
+		err = stream.Send(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	assertStatus(t, err, codes.InvalidArgument, "Invalid RepoCreateRequest: body: exactly one field is required")
+
+	stream, err = ts.Repo.CreateRepo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&svc.RepoCreateRequest{Body: &svc.RepoCreateRequest_Context{Context: &svc.RepoContext{Name: "bad name"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.CloseAndRecv()
+	if got := strings.Join(violatedFields(t, err), ","); got != "context.creator_id,context.name" {
+		t.Fatalf("got violations %s, want context.creator_id,context.name", got)
+	}
+}
+
+// 正则在创建validator时编译，嵌套消息中无效的正则同样导致创建失败
+func TestValidatorRejectsInvalidPattern(t *testing.T) {
+	v, err := newValidator(protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.patterns["^[A-Za-z0-9_-]*$"] == nil {
+		t.Fatalf("patterns not compiled: %v", v.patterns)
+	}
+
+	opts := &descriptorpb.FieldOptions{}
+	proto.SetExtension(opts, svc.E_Constraints, &svc.FieldConstraints{Pattern: "^(unclosed$"})
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("invalid_pattern.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Outer"),
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Inner"),
+				Field: []*descriptorpb.FieldDescriptorProto{{
+					Name:     proto.String("name"),
+					Number:   proto.Int32(1),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+					JsonName: proto.String("name"),
+					Options:  opts,
+				}},
+			}},
+		}},
+	}
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := &protoregistry.Files{}
+	err = files.RegisterFile(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newValidator(files)
+	if err == nil || !strings.Contains(err.Error(), "invalid (constraints).pattern of test.Outer.Inner.name") {
+		t.Fatalf("got %v, want an invalid pattern error", err)
+	}
+}
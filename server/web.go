@@ -0,0 +1,715 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// gRPC和gRPC-Web消息帧的标志位
+const (
+	frameCompressed = 0x01 // 消息经过压缩
+	frameEndStream  = 0x02 // Connect流结束消息
+	frameTrailer    = 0x80 // gRPC-Web trailer帧
+)
+
+// 浏览器可以读取的响应头
+var corsExposedHeaders = []string{
+	"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin", "Connect-Protocol-Version",
+}
+
+// webServer 在HTTP/1.1和HTTP/2上以gRPC-Web（二进制和文本）和Connect协议提供grpc.Server中注册的服务，
+// 请求被转换为gRPC请求交给grpc.Server.ServeHTTP处理，因此拦截器等配置与原生gRPC监听器完全一致
+type webServer struct {
+	s            *grpc.Server
+	allowOrigins []string // 允许跨域访问的来源，"*"表示允许所有来源
+}
+
+// 启动gRPC-Web和Connect协议的监听器，与原生gRPC监听器共享同一个grpc.Server
+// 环境变量WEB_CORS_ORIGINS指定逗号分隔的允许跨域来源，例如 http://localhost:3000，"*"表示允许所有来源
+func startWebServer(s *grpc.Server, l net.Listener, tlsCertFile, tlsKeyFile string) *http.Server {
+	ws := &webServer{s: s}
+	if origins, ok := os.LookupEnv("WEB_CORS_ORIGINS"); ok {
+		for _, o := range strings.Split(origins, ",") {
+			ws.allowOrigins = append(ws.allowOrigins, strings.TrimSpace(o))
+		}
+	}
+	hs := &http.Server{Handler: ws}
+	go func() {
+		log.Printf("gRPC-Web and Connect listening on %s\n", l.Addr())
+		err := hs.ServeTLS(l, tlsCertFile, tlsKeyFile) // TLS上通过ALPN同时支持HTTP/2和HTTP/1.1
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("gRPC-Web server failed: %v", err)
+		}
+	}()
+	return hs
+}
+
+func (ws *webServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !ws.cors(w, r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodOptions { // CORS预检请求
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	case r.ProtoMajor == 2 && (contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+")):
+		ws.s.ServeHTTP(w, r) // 原生gRPC
+	case contentType == "application/grpc-web" || contentType == "application/grpc-web+proto":
+		ws.serveGrpcWeb(w, r, false)
+	case contentType == "application/grpc-web-text" || contentType == "application/grpc-web-text+proto":
+		ws.serveGrpcWeb(w, r, true)
+	case contentType == "application/connect+proto" || contentType == "application/connect+json":
+		ws.serveConnectStream(w, r, contentType == "application/connect+json")
+	case contentType == "application/proto" || contentType == "application/json":
+		ws.serveConnectUnary(w, r, contentType == "application/json")
+	default:
+		http.Error(w, fmt.Sprintf("Unsupported content type %q", contentType), http.StatusUnsupportedMediaType)
+	}
+}
+
+// 设置CORS响应头，来源不被允许时返回false
+func (ws *webServer) cors(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true // 非浏览器跨域请求
+	}
+	allowed := false
+	for _, o := range ws.allowOrigins {
+		if o == "*" || o == origin {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return false
+	}
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+	if r.Method == http.MethodOptions {
+		h.Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		h.Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers")) // 允许请求的所有头，包括自定义元数据
+		h.Set("Access-Control-Max-Age", "7200")
+	}
+	return true
+}
+
+// 构造交给grpc.Server.ServeHTTP处理的gRPC请求
+// HTTP/1.x不能在写响应的同时读取请求体，因此先读取完整的请求体
+func grpcRequest(r *http.Request, body io.Reader) (*http.Request, error) {
+	if r.ProtoMajor < 2 {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	gr := r.Clone(r.Context())
+	gr.ProtoMajor, gr.ProtoMinor, gr.Proto = 2, 0, "HTTP/2.0"
+	gr.Body = io.NopCloser(body)
+	gr.ContentLength = -1
+	gr.Header.Del("Content-Length")
+	gr.Header.Set("Content-Type", "application/grpc+proto")
+	gr.Header.Set("Te", "trailers")
+	return gr, nil
+}
+
+func (ws *webServer) serveGrpcWeb(w http.ResponseWriter, r *http.Request, text bool) {
+	var body io.Reader = r.Body
+	if text {
+		body = &base64ChunkReader{r: bufio.NewReader(r.Body)}
+	}
+	gr, err := grpcRequest(r, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	gw := newGrpcWriter(&grpcWebProtocol{w: w, text: text, contentType: r.Header.Get("Content-Type")})
+	ws.s.ServeHTTP(gw, gr)
+	gw.finish()
+}
+
+// base64ChunkReader 解码grpc-web-text的请求体。客户端可以每条消息分别编码，请求体由多段各自带填充的base64组成，
+// base64.NewDecoder遇到中间的填充会失败，因此按4个字符一组分别解码
+type base64ChunkReader struct {
+	r   io.Reader
+	out []byte
+}
+
+func (b *base64ChunkReader) Read(p []byte) (int, error) {
+	for len(b.out) == 0 {
+		var in [4]byte
+		_, err := io.ReadFull(b.r, in[:])
+		if err != nil {
+			return 0, err // 长度不是4的倍数时为io.ErrUnexpectedEOF
+		}
+		var out [3]byte
+		n, err := base64.StdEncoding.Decode(out[:], in[:])
+		if err != nil {
+			return 0, err
+		}
+		b.out = out[:n]
+	}
+	n := copy(p, b.out)
+	b.out = b.out[n:]
+	return n, nil
+}
+
+func (ws *webServer) serveConnectUnary(w http.ResponseWriter, r *http.Request, json bool) {
+	method, err := lookupMethod(r.URL.Path)
+	if err != nil {
+		writeConnectError(w, status.Convert(err))
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeConnectError(w, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+	if json {
+		data, err = jsonToProto(method.Input(), data)
+		if err != nil {
+			writeConnectError(w, status.New(codes.InvalidArgument, err.Error()))
+			return
+		}
+	}
+	gr, err := grpcRequest(r, bytes.NewReader(frame(0, data)))
+	if err != nil {
+		writeConnectError(w, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+	setGrpcTimeout(gr)
+	gw := newGrpcWriter(&connectUnaryProtocol{w: w, json: json, output: method.Output()})
+	ws.s.ServeHTTP(gw, gr)
+	gw.finish()
+}
+
+func (ws *webServer) serveConnectStream(w http.ResponseWriter, r *http.Request, json bool) {
+	method, err := lookupMethod(r.URL.Path)
+	if err != nil {
+		writeConnectStreamError(w, json, status.Convert(err))
+		return
+	}
+	var body io.Reader = r.Body
+	if json { // 逐个将请求消息从JSON转换为proto
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(transcodeFrames(pw, r.Body, func(data []byte) ([]byte, error) {
+				return jsonToProto(method.Input(), data)
+			}))
+		}()
+		body = pr
+	}
+	gr, err := grpcRequest(r, body)
+	if err != nil {
+		writeConnectStreamError(w, json, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+	setGrpcTimeout(gr)
+	gw := newGrpcWriter(&connectStreamProtocol{w: w, json: json, output: method.Output()})
+	ws.s.ServeHTTP(gw, gr)
+	gw.finish()
+}
+
+// Connect协议的超时请求头Connect-Timeout-Ms转换为grpc-timeout
+func setGrpcTimeout(r *http.Request) {
+	ms := r.Header.Get("Connect-Timeout-Ms")
+	if len(ms) == 0 {
+		return
+	}
+	r.Header.Del("Connect-Timeout-Ms")
+	if _, err := strconv.ParseUint(ms, 10, 64); err == nil {
+		r.Header.Set("Grpc-Timeout", ms+"m")
+	}
+}
+
+// 根据请求路径 /服务名/方法名 查找方法描述，用于JSON和proto之间的转换
+func lookupMethod(path string) (protoreflect.MethodDescriptor, error) {
+	name := strings.Replace(strings.TrimPrefix(path, "/"), "/", ".", 1)
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, status.Errorf(codes.Unimplemented, "Unknown method %s", path)
+	}
+	method, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "Unknown method %s", path)
+	}
+	return method, nil
+}
+
+func newMessage(md protoreflect.MessageDescriptor) (proto.Message, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName())
+	if err != nil {
+		return nil, err
+	}
+	return mt.New().Interface(), nil
+}
+
+func jsonToProto(md protoreflect.MessageDescriptor, data []byte) ([]byte, error) {
+	m, err := newMessage(md)
+	if err != nil {
+		return nil, err
+	}
+	err = protojson.Unmarshal(data, m)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(m)
+}
+
+func protoToJSON(md protoreflect.MessageDescriptor, data []byte) ([]byte, error) {
+	m, err := newMessage(md)
+	if err != nil {
+		return nil, err
+	}
+	err = proto.Unmarshal(data, m)
+	if err != nil {
+		return nil, err
+	}
+	return protojson.Marshal(m)
+}
+
+// 生成一个消息帧：1字节标志位 + 4字节大端长度 + 消息
+func frame(flags byte, data []byte) []byte {
+	b := make([]byte, 5, 5+len(data))
+	b[0] = flags
+	binary.BigEndian.PutUint32(b[1:], uint32(len(data)))
+	return append(b, data...)
+}
+
+// 读取一个消息帧
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var hdr [5]byte
+	_, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(hdr[1:]))
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	return hdr[0], data, nil
+}
+
+// 逐帧读取r，用convert转换消息后写入w，直到r结束
+func transcodeFrames(w io.Writer, r io.Reader, convert func([]byte) ([]byte, error)) error {
+	for {
+		flags, data, err := readFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if flags&frameCompressed != 0 {
+			return status.Error(codes.Unimplemented, "Compressed messages are not supported")
+		}
+		data, err = convert(data)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		_, err = w.Write(frame(flags, data))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// webProtocol 将grpc.Server的gRPC响应转换为具体协议的响应
+type webProtocol interface {
+	header(h http.Header)      // gRPC响应头
+	message(data []byte) error // 一条响应消息
+	flush()
+	finish(st *status.Status, t http.Header) // RPC结束，t为trailer元数据
+}
+
+// grpcWriter 作为grpc.Server.ServeHTTP的ResponseWriter，解析gRPC响应头、消息帧和trailer，交给webProtocol转换后写出
+type grpcWriter struct {
+	protocol    webProtocol
+	h           http.Header
+	wroteHeader bool
+	buf         []byte // 尚不完整的消息帧
+	err         error
+}
+
+func newGrpcWriter(p webProtocol) *grpcWriter {
+	return &grpcWriter{protocol: p, h: make(http.Header)}
+}
+
+func (w *grpcWriter) Header() http.Header {
+	return w.h
+}
+
+func (w *grpcWriter) WriteHeader(int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.h.Clone()
+	for _, k := range []string{"Trailer", "Content-Type", "Date", "Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"} {
+		h.Del(k)
+	}
+	w.protocol.header(h)
+}
+
+func (w *grpcWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= 5 {
+		n := int(binary.BigEndian.Uint32(w.buf[1:5]))
+		if len(w.buf) < 5+n {
+			break
+		}
+		if w.buf[0]&frameCompressed != 0 {
+			w.err = status.Error(codes.Internal, "Compressed responses are not supported")
+			return 0, w.err
+		}
+		w.err = w.protocol.message(w.buf[5 : 5+n])
+		if w.err != nil {
+			return 0, w.err
+		}
+		w.buf = w.buf[5+n:]
+	}
+	return len(p), nil
+}
+
+func (w *grpcWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+	w.protocol.flush()
+}
+
+// ServeHTTP返回后调用，从响应头中取出状态和trailer
+func (w *grpcWriter) finish() {
+	w.WriteHeader(http.StatusOK)
+	st := status.New(codes.Unknown, "Missing grpc-status")
+	if code, err := strconv.Atoi(w.h.Get("Grpc-Status")); err == nil {
+		st = status.New(codes.Code(code), decodeGrpcMessage(w.h.Get("Grpc-Message")))
+		if bin := w.h.Get("Grpc-Status-Details-Bin"); len(bin) != 0 {
+			if data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(bin, "=")); err == nil {
+				p := &spb.Status{}
+				if proto.Unmarshal(data, p) == nil {
+					st = status.FromProto(p)
+				}
+			}
+		}
+	}
+	if w.err != nil {
+		st = status.Convert(w.err)
+	}
+
+	t := make(http.Header)
+	for k, vv := range w.h {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			t[textproto.CanonicalMIMEHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = vv
+		}
+	}
+	w.protocol.finish(st, t)
+}
+
+// 解码grpc-message中百分号编码的字符
+func decodeGrpcMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if msg[i] == '%' && i+2 < len(msg) {
+			if v, err := strconv.ParseUint(msg[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(msg[i])
+	}
+	return b.String()
+}
+
+// 百分号编码grpc-message，与grpc-go相同：空格到~之间除%以外的字符原样保留，其他字节（包括UTF-8和CR、LF）编码为%XX
+func encodeGrpcMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// gRPC-Web：消息帧与gRPC相同，trailer作为标志位0x80的帧写在响应体末尾，文本模式下响应体为base64编码
+type grpcWebProtocol struct {
+	w           http.ResponseWriter
+	text        bool
+	contentType string
+}
+
+func (p *grpcWebProtocol) header(h http.Header) {
+	for k, vv := range h {
+		p.w.Header()[k] = vv
+	}
+	p.w.Header().Set("Content-Type", p.contentType)
+	p.w.WriteHeader(http.StatusOK)
+}
+
+func (p *grpcWebProtocol) write(b []byte) error {
+	if p.text {
+		b = []byte(base64.StdEncoding.EncodeToString(b))
+	}
+	_, err := p.w.Write(b)
+	return err
+}
+
+func (p *grpcWebProtocol) message(data []byte) error {
+	return p.write(frame(0, data))
+}
+
+func (p *grpcWebProtocol) flush() {
+	if f, ok := p.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (p *grpcWebProtocol) finish(st *status.Status, t http.Header) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "grpc-status: %d\r\n", st.Code())
+	if len(st.Message()) != 0 {
+		fmt.Fprintf(&b, "grpc-message: %s\r\n", encodeGrpcMessage(st.Message()))
+	}
+	if len(st.Details()) != 0 {
+		data, err := proto.Marshal(st.Proto())
+		if err == nil {
+			fmt.Fprintf(&b, "grpc-status-details-bin: %s\r\n", base64.RawStdEncoding.EncodeToString(data))
+		}
+	}
+	for k, vv := range t {
+		for _, v := range vv {
+			fmt.Fprintf(&b, "%s: %s\r\n", strings.ToLower(k), v)
+		}
+	}
+	p.write(frame(frameTrailer, b.Bytes()))
+	p.flush()
+}
+
+// Connect一元调用：请求和响应体为单个未封装的消息，错误以JSON返回并使用对应的HTTP状态码，trailer以Trailer-前缀的响应头返回
+type connectUnaryProtocol struct {
+	w      http.ResponseWriter
+	json   bool
+	output protoreflect.MessageDescriptor
+	h      http.Header
+	data   []byte
+}
+
+func (p *connectUnaryProtocol) header(h http.Header) {
+	p.h = h
+}
+
+func (p *connectUnaryProtocol) message(data []byte) error {
+	if p.data != nil {
+		return status.Error(codes.Unimplemented, "Unary call returned more than one message")
+	}
+	p.data = append([]byte{}, data...)
+	return nil
+}
+
+func (p *connectUnaryProtocol) flush() {}
+
+func (p *connectUnaryProtocol) finish(st *status.Status, t http.Header) {
+	data := p.data
+	if st.Code() == codes.OK && p.json {
+		var err error
+		data, err = protoToJSON(p.output, data)
+		if err != nil {
+			st = status.New(codes.Internal, err.Error())
+		}
+	}
+	for k, vv := range p.h {
+		p.w.Header()[k] = vv
+	}
+	for k, vv := range t {
+		p.w.Header()["Trailer-"+k] = vv
+	}
+	if st.Code() != codes.OK {
+		writeConnectError(p.w, st)
+		return
+	}
+	if p.json {
+		p.w.Header().Set("Content-Type", "application/json")
+	} else {
+		p.w.Header().Set("Content-Type", "application/proto")
+	}
+	p.w.WriteHeader(http.StatusOK)
+	p.w.Write(data)
+}
+
+// Connect流式调用：消息以与gRPC相同的帧封装，最后一帧标志位为0x02，内容为包含错误和trailer的JSON
+type connectStreamProtocol struct {
+	w      http.ResponseWriter
+	json   bool
+	output protoreflect.MessageDescriptor
+}
+
+func (p *connectStreamProtocol) header(h http.Header) {
+	for k, vv := range h {
+		p.w.Header()[k] = vv
+	}
+	if p.json {
+		p.w.Header().Set("Content-Type", "application/connect+json")
+	} else {
+		p.w.Header().Set("Content-Type", "application/connect+proto")
+	}
+	p.w.WriteHeader(http.StatusOK)
+}
+
+func (p *connectStreamProtocol) message(data []byte) error {
+	if p.json {
+		var err error
+		data, err = protoToJSON(p.output, data)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	_, err := p.w.Write(frame(0, data))
+	return err
+}
+
+func (p *connectStreamProtocol) flush() {
+	if f, ok := p.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (p *connectStreamProtocol) finish(st *status.Status, t http.Header) {
+	end := connectEndStream{Metadata: t}
+	if st.Code() != codes.OK {
+		end.Error = newConnectError(st)
+	}
+	data, err := json.Marshal(end)
+	if err != nil {
+		log.Printf("Marshaling end of stream failed: %v", err)
+		return
+	}
+	p.w.Write(frame(frameEndStream, data))
+	p.flush()
+}
+
+type connectEndStream struct {
+	Error    *connectError `json:"error,omitempty"`
+	Metadata http.Header   `json:"metadata,omitempty"`
+}
+
+type connectError struct {
+	Code    string               `json:"code"`
+	Message string               `json:"message,omitempty"`
+	Details []connectErrorDetail `json:"details,omitempty"`
+}
+
+type connectErrorDetail struct {
+	Type  string `json:"type"`
+	Value string `json:"value"` // base64编码的消息
+}
+
+func newConnectError(st *status.Status) *connectError {
+	e := &connectError{Code: connectCode(st.Code()), Message: st.Message()}
+	for _, d := range st.Proto().GetDetails() {
+		e.Details = append(e.Details, connectErrorDetail{
+			Type:  strings.TrimPrefix(d.TypeUrl, "type.googleapis.com/"),
+			Value: base64.RawStdEncoding.EncodeToString(d.Value),
+		})
+	}
+	return e
+}
+
+func writeConnectError(w http.ResponseWriter, st *status.Status) {
+	data, err := json.Marshal(newConnectError(st))
+	if err != nil {
+		http.Error(w, st.Message(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatusFromCode(st.Code()))
+	w.Write(data)
+}
+
+func writeConnectStreamError(w http.ResponseWriter, json bool, st *status.Status) {
+	p := &connectStreamProtocol{w: w, json: json}
+	p.header(nil)
+	p.finish(st, nil)
+}
+
+// gRPC状态码转换为Connect协议的错误码，例如 InvalidArgument -> invalid_argument
+func connectCode(code codes.Code) string {
+	var b strings.Builder
+	for i, r := range code.String() {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// gRPC状态码到HTTP状态码的映射，与Connect协议的定义一致
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // Client Closed Request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError // Unknown、Internal、DataLoss
+}
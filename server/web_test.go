@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	svc "github.com/calmw/grpc-service"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// 以HTTP/1.1在httptest服务器上提供gRPC-Web和Connect协议，与测试服务端共享grpc.Server，返回服务器的URL
+func (ts *testServer) webURL(t *testing.T, origins ...string) string {
+	t.Helper()
+	srv := httptest.NewServer(&webServer{s: ts.server, allowOrigins: origins})
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func postWeb(t *testing.T, ctx context.Context, url, contentType string, body []byte, header ...string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Add(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func marshal(t *testing.T, m proto.Message) []byte {
+	t.Helper()
+	data, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// 读取gRPC-Web响应体中的下一条消息，遇到trailer帧时返回trailer
+func readGrpcWebFrame(t *testing.T, r io.Reader) ([]byte, textproto.MIMEHeader) {
+	t.Helper()
+	flags, data, err := readFrame(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags&frameTrailer == 0 {
+		return data, nil
+	}
+	trailer, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(data, "\r\n"...)))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("malformed trailer %q: %v", data, err)
+	}
+	return nil, trailer
+}
+
+// 读取一元调用的回复和trailer
+func readGrpcWebUnary(t *testing.T, r io.Reader, reply proto.Message) textproto.MIMEHeader {
+	t.Helper()
+	data, trailer := readGrpcWebFrame(t, r)
+	if trailer != nil {
+		return trailer
+	}
+	err := proto.Unmarshal(data, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, trailer = readGrpcWebFrame(t, r)
+	if trailer == nil {
+		t.Fatal("missing trailer frame")
+	}
+	return trailer
+}
+
+func TestGrpcWebUnary(t *testing.T) {
+	ts := startTestServer(t)
+	url := ts.webURL(t) + "/Users/GetUser"
+	body := frame(0, marshal(t, &svc.UserGetRequest{Email: "jane@doe.com", Id: "1"}))
+	resp := postWeb(t, context.Background(), url, "application/grpc-web+proto", body)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/grpc-web+proto" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	reply := &svc.UserGetReply{}
+	trailer := readGrpcWebUnary(t, resp.Body, reply)
+	if trailer.Get("Grpc-Status") != "0" || reply.User.GetId() != "1" {
+		t.Fatalf("unexpected reply %v, trailer %v", reply, trailer)
+	}
+}
+
+// 文本模式的请求体由多段各自带填充的base64组成，回复的每个帧也分别编码
+func TestGrpcWebText(t *testing.T) {
+	ts := startTestServer(t)
+	url := ts.webURL(t) + "/Users/GetUser"
+	f := frame(0, marshal(t, &svc.UserGetRequest{Email: "jane@doe.com", Id: "1"}))
+	body := base64.StdEncoding.EncodeToString(f[:5]) + base64.StdEncoding.EncodeToString(f[5:])
+	if !strings.Contains(strings.TrimRight(body, "="), "=") {
+		t.Fatalf("request body %q has no padding in the middle", body)
+	}
+	resp := postWeb(t, context.Background(), url, "application/grpc-web-text", []byte(body))
+	reply := &svc.UserGetReply{}
+	trailer := readGrpcWebUnary(t, &base64ChunkReader{r: resp.Body}, reply)
+	if trailer.Get("Grpc-Status") != "0" || reply.User.GetId() != "1" {
+		t.Fatalf("unexpected reply %v, trailer %v", reply, trailer)
+	}
+
+	resp = postWeb(t, context.Background(), url, "application/grpc-web-text", []byte(body[:len(body)-1]))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("truncated request got %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestGrpcWebServerStreaming(t *testing.T) {
+	ts := startTestServer(t)
+	url := ts.webURL(t) + "/Repo/GetRepos"
+	// GetRepos每2秒发送一个仓库，只检查第一个
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	body := frame(0, marshal(t, &svc.RepoGetRequest{CreatorId: "user-123", Id: "repo-123"}))
+	resp := postWeb(t, ctx, url, "application/grpc-web+proto", body)
+	data, trailer := readGrpcWebFrame(t, resp.Body)
+	if trailer != nil {
+		t.Fatalf("unexpected trailer: %v", trailer)
+	}
+	reply := &svc.RepoGetReply{}
+	err := proto.Unmarshal(data, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Repo.Name != "repo-1" || reply.Repo.Owner.GetId() != "user-123" {
+		t.Fatalf("unexpected repo: %v", reply.Repo)
+	}
+}
+
+// trailer中的grpc-message按百分号编码，错误详情以grpc-status-details-bin返回
+func TestGrpcWebErrorTrailers(t *testing.T) {
+	ts := startTestServer(t)
+	url := ts.webURL(t) + "/Users/GetUser"
+	body := frame(0, marshal(t, &svc.UserGetRequest{Email: "jane@doe.com", Id: "1"}))
+	ts.injectFaults(faultRule{Method: "/Users/GetUser", Code: codes.Unavailable, Message: "服务暂时不可用\r\n100% busy"})
+	resp := postWeb(t, context.Background(), url, "application/grpc-web+proto", body)
+	trailer := readGrpcWebUnary(t, resp.Body, &svc.UserGetReply{})
+	msg := trailer.Get("Grpc-Message")
+	if trailer.Get("Grpc-Status") != "14" || !strings.HasSuffix(msg, "%0D%0A100%25 busy") {
+		t.Fatalf("unexpected trailer: %v", trailer)
+	}
+	for _, c := range []byte(msg) {
+		if c < ' ' || c > '~' {
+			t.Fatalf("grpc-message %q is not percent-encoded", msg)
+		}
+	}
+	if got := decodeGrpcMessage(msg); got != "服务暂时不可用\r\n100% busy" {
+		t.Fatalf("grpc-message %q decodes to %q", msg, got)
+	}
+
+	ts.injectFaults()
+	interceptors.set("validation", false)
+	body = frame(0, marshal(t, &svc.UserGetRequest{Email: "jane"}))
+	resp = postWeb(t, context.Background(), url, "application/grpc-web+proto", body, "Accept-Language", "zh-CN")
+	trailer = readGrpcWebUnary(t, resp.Body, &svc.UserGetReply{})
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(trailer.Get("Grpc-Status-Details-Bin"), "="))
+	if err != nil {
+		t.Fatalf("invalid grpc-status-details-bin: %v", trailer)
+	}
+	p := &spb.Status{}
+	err = proto.Unmarshal(data, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := status.FromProto(p)
+	assertStatus(t, st.Err(), codes.InvalidArgument, "Invalid email address specified")
+	var localized *errdetails.LocalizedMessage
+	for _, d := range st.Details() {
+		if m, ok := d.(*errdetails.LocalizedMessage); ok {
+			localized = m
+		}
+	}
+	if localized == nil || localized.Locale != "zh-CN" {
+		t.Fatalf("unexpected details: %v", st.Details())
+	}
+}
+
+func TestConnectUnary(t *testing.T) {
+	ts := startTestServer(t)
+	url := ts.webURL(t) + "/Users/GetUser"
+	resp := postWeb(t, context.Background(), url, "application/json", []byte(`{"email":"jane@doe.com","id":"1"}`))
+	var reply struct {
+		User struct {
+			ID        string `json:"id"`
+			FirstName string `json:"firstName"`
+		} `json:"user"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&reply)
+	if err != nil || resp.StatusCode != http.StatusOK || reply.User.ID != "1" || reply.User.FirstName != "jane" {
+		t.Fatalf("unexpected response %d %+v: %v", resp.StatusCode, reply, err)
+	}
+
+	resp = postWeb(t, context.Background(), url, "application/json", []byte(`{"email":"jane"}`))
+	var e connectError
+	err = json.NewDecoder(resp.Body).Decode(&e)
+	if err != nil || resp.StatusCode != http.StatusBadRequest || e.Code != "invalid_argument" || len(e.Details) == 0 {
+		t.Fatalf("unexpected error response %d %+v: %v", resp.StatusCode, e, err)
+	}
+}
+
+// Connect流式调用，客户端流CreateRepo，最后一帧为结束流消息
+func TestConnectStreaming(t *testing.T) {
+	ts := startTestServer(t)
+	url := ts.webURL(t) + "/Repo/CreateRepo"
+	var body []byte
+	for _, msg := range []string{
+		`{"context":{"creatorId":"user-123","name":"web-repo"}}`,
+		`{"data":"aGVsbG8="}`,
+	} {
+		body = append(body, frame(0, []byte(msg))...)
+	}
+	resp := postWeb(t, context.Background(), url, "application/connect+json", body)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/connect+json" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	flags, data, err := readFrame(resp.Body)
+	if err != nil || flags != 0 {
+		t.Fatalf("unexpected frame %x %s: %v", flags, data, err)
+	}
+	var reply struct {
+		Size string `json:"size"` // int64以字符串编码
+	}
+	if json.Unmarshal(data, &reply) != nil || reply.Size != "5" {
+		t.Fatalf("unexpected reply: %s", data)
+	}
+	flags, data, err = readFrame(resp.Body)
+	if err != nil || flags != frameEndStream {
+		t.Fatalf("unexpected frame %x %s: %v", flags, data, err)
+	}
+	var end connectEndStream
+	if json.Unmarshal(data, &end) != nil || end.Error != nil {
+		t.Fatalf("unexpected end of stream: %s", data)
+	}
+
+	// 缺少context时在结束流消息中返回错误
+	resp = postWeb(t, context.Background(), url, "application/connect+json", frame(0, []byte(`{"data":"aGVsbG8="}`)))
+	flags, data, err = readFrame(resp.Body)
+	if err != nil || flags != frameEndStream || json.Unmarshal(data, &end) != nil || end.Error == nil || end.Error.Code != "failed_precondition" {
+		t.Fatalf("unexpected frame %x %s: %v", flags, data, err)
+	}
+}
+
+func TestWebCORS(t *testing.T) {
+	ts := startTestServer(t)
+	url := ts.webURL(t, "http://localhost:3000") + "/Users/GetUser"
+	preflight := func(origin string) *http.Response {
+		req, err := http.NewRequest(http.MethodOptions, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web,accept-language")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := preflight("http://localhost:3000")
+	h := resp.Header
+	if resp.StatusCode != http.StatusNoContent ||
+		h.Get("Access-Control-Allow-Origin") != "http://localhost:3000" ||
+		h.Get("Access-Control-Allow-Methods") != "POST, OPTIONS" ||
+		h.Get("Access-Control-Allow-Headers") != "content-type,x-grpc-web,accept-language" ||
+		!strings.Contains(h.Get("Access-Control-Expose-Headers"), "Grpc-Status-Details-Bin") {
+		t.Fatalf("unexpected preflight response %d: %v", resp.StatusCode, h)
+	}
+	if resp := preflight("http://evil.example.com"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("preflight from a disallowed origin got %d", resp.StatusCode)
+	}
+}
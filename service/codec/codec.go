@@ -0,0 +1,90 @@
+// Package codec 提供gRPC的JSON编解码器和使用vtprotobuf生成代码（*_vtproto.pb.go）的快速protobuf编解码器，
+// 导入该包时注册，客户端通过grpc.CallContentSubtype按调用选择，服务端按请求的content-type使用相同的编解码器回复。
+// 服务端未注册某个编解码器时会按默认的proto编解码器处理请求，因此服务端也必须导入该包
+package codec
+
+import (
+	"fmt"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// 编解码器的名称，即content-type中的子类型，例如 application/grpc+json
+const (
+	JSON = "json"
+	Fast = "vtproto"
+)
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+	encoding.RegisterCodec(fastCodec{})
+}
+
+// RawJSON 已编码的JSON消息，JSON编解码器原样发送和接收，不需要生成的消息类型，供命令行工具使用，例如
+// conn.Invoke(ctx, "/Users/GetUser", codec.RawJSON(`{"email":"jane@doe.com"}`), &reply, grpc.CallContentSubtype(codec.JSON))，
+// 其中reply为codec.RawJSON
+type RawJSON []byte
+
+// jsonCodec 使用protojson编解码消息，解码时忽略未知的字段，与protobuf的二进制格式一样可以向前兼容
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return JSON
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case RawJSON:
+		return m, nil
+	case *RawJSON:
+		return *m, nil
+	case protobuf.Message:
+		return protojson.Marshal(m)
+	}
+	return nil, fmt.Errorf("json codec: cannot marshal type %T", v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case *RawJSON:
+		*m = append((*m)[:0], data...)
+		return nil
+	case protobuf.Message:
+		return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, m)
+	}
+	return fmt.Errorf("json codec: cannot unmarshal into type %T", v)
+}
+
+// vtMessage 由protoc-gen-go-vtproto生成的方法，不经过反射直接编解码
+type vtMessage interface {
+	MarshalVT() ([]byte, error)
+	UnmarshalVT([]byte) error
+}
+
+// fastCodec 与默认的proto编解码器的线上格式相同，Users和Repo服务的消息使用生成的方法，
+// 其他消息（例如健康检查和错误详情）使用默认的proto编解码器
+type fastCodec struct{}
+
+func (fastCodec) Name() string {
+	return Fast
+}
+
+func (fastCodec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(vtMessage); ok {
+		return m.MarshalVT()
+	}
+	return encoding.GetCodec(proto.Name).Marshal(v)
+}
+
+// UnmarshalVT会合并到已有的字段，与proto.Unmarshal一样先清空消息
+func (fastCodec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(vtMessage); ok {
+		if pm, ok := v.(protobuf.Message); ok {
+			protobuf.Reset(pm)
+		}
+		return m.UnmarshalVT(data)
+	}
+	return encoding.GetCodec(proto.Name).Unmarshal(data, v)
+}
@@ -0,0 +1,187 @@
+// Package compress 提供gRPC消息的gzip和zstd压缩器，以及客户端按方法选择压缩器的策略。
+// 压缩器通过encoding.RegisterCompressor全局注册，不是并发安全的，必须在创建grpc.Server或建立连接之前调用Register。
+// 服务端只能解压已注册的压缩器压缩的请求，并使用与请求相同的压缩器压缩回复
+package compress
+
+import (
+	"fmt"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 压缩器的名称，即请求头grpc-encoding的值
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+var compressors = map[string]func() encoding.Compressor{
+	Gzip: func() encoding.Compressor { return &gzipCompressor{} },
+	Zstd: func() encoding.Compressor { return &zstdCompressor{} },
+}
+
+// Register 注册names中的压缩器，同一个压缩器重复注册时后注册的生效
+func Register(names ...string) error {
+	for _, name := range names {
+		if _, ok := compressors[name]; !ok {
+			return unknownCompressor(name)
+		}
+	}
+	for _, name := range names {
+		encoding.RegisterCompressor(compressors[name]())
+	}
+	return nil
+}
+
+// ParseList 解析逗号分隔的压缩器列表，例如 gzip,zstd，空字符串返回nil
+func ParseList(s string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		if _, ok := compressors[name]; !ok {
+			return nil, unknownCompressor(name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func unknownCompressor(name string) error {
+	var known []string
+	for k := range compressors {
+		known = append(known, k)
+	}
+	sort.Strings(known)
+	return fmt.Errorf("unknown compressor %q, want one of %s", name, strings.Join(known, ", "))
+}
+
+// gzipCompressor 使用klauspost/compress的gzip实现，比标准库快，压缩和解压的对象都被复用
+type gzipCompressor struct {
+	writers sync.Pool
+	readers sync.Pool
+}
+
+type gzipWriter struct {
+	*gzip.Writer
+	pool *sync.Pool
+}
+
+type gzipReader struct {
+	*gzip.Reader
+	pool *sync.Pool
+}
+
+func (c *gzipCompressor) Name() string {
+	return Gzip
+}
+
+func (c *gzipCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	z, ok := c.writers.Get().(*gzipWriter)
+	if !ok {
+		return &gzipWriter{Writer: gzip.NewWriter(w), pool: &c.writers}, nil
+	}
+	z.Reset(w)
+	return z, nil
+}
+
+func (z *gzipWriter) Close() error {
+	defer z.pool.Put(z)
+	return z.Writer.Close()
+}
+
+func (c *gzipCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	z, ok := c.readers.Get().(*gzipReader)
+	if !ok {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &gzipReader{Reader: gr, pool: &c.readers}, nil
+	}
+	err := z.Reset(r)
+	if err != nil {
+		c.readers.Put(z)
+		return nil, err
+	}
+	return z, nil
+}
+
+// grpc读到io.EOF后不再使用reader，此时放回池中
+func (z *gzipReader) Read(p []byte) (int, error) {
+	n, err := z.Reader.Read(p)
+	if err == io.EOF {
+		z.pool.Put(z)
+	}
+	return n, err
+}
+
+// zstdCompressor 每个编码器和解码器只使用一个goroutine，不在后台启动额外的goroutine
+type zstdCompressor struct {
+	writers sync.Pool
+	readers sync.Pool
+}
+
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+type zstdReader struct {
+	*zstd.Decoder
+	pool *sync.Pool
+}
+
+func (c *zstdCompressor) Name() string {
+	return Zstd
+}
+
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	z, ok := c.writers.Get().(*zstdWriter)
+	if !ok {
+		e, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &zstdWriter{Encoder: e, pool: &c.writers}, nil
+	}
+	z.Reset(w)
+	return z, nil
+}
+
+func (z *zstdWriter) Close() error {
+	defer z.pool.Put(z)
+	return z.Encoder.Close()
+}
+
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	z, ok := c.readers.Get().(*zstdReader)
+	if !ok {
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &zstdReader{Decoder: d, pool: &c.readers}, nil
+	}
+	err := z.Reset(r)
+	if err != nil {
+		c.readers.Put(z)
+		return nil, err
+	}
+	return z, nil
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	n, err := z.Decoder.Read(p)
+	if err == io.EOF {
+		z.pool.Put(z)
+	}
+	return n, err
+}
@@ -0,0 +1,146 @@
+package compress
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/proto"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 一元请求小于该字节数时不压缩，可通过环境变量GRPC_COMPRESS_MIN_SIZE修改。只作用于请求，服务端没有按回复大小的阈值
+const DefaultMinSize = 1024
+
+// Policy 客户端按方法选择压缩器：Methods中的方法（完整方法名，例如 /Repo/CreateRepo，或者 /Users/* 表示服务的所有方法）
+// 使用对应的压缩器，其他方法使用Default，压缩器为空或identity时不压缩。
+// MinSize只检查请求的大小，不是回复的阈值：一元请求序列化后小于MinSize字节时不压缩。客户端发送请求前无法知道回复的大小，
+// 而服务端使用与请求相同的压缩器压缩回复（grpc 1.53没有grpc.SetSendCompressor，服务端无法按回复大小另选压缩器），
+// 因此小请求的回复即使很大也不会被压缩，反之大请求的小回复也会被压缩。
+// 回复大的方法（例如请求很小的查询）应通过Methods指定压缩器并将MinSize设为0，或者调用时指定压缩器。
+// 流在开始时还不知道消息的大小，总是按策略压缩。调用时通过grpc.UseCompressor指定的压缩器优先于策略
+type Policy struct {
+	Default string
+	Methods map[string]string
+	MinSize int
+}
+
+// PolicyFromEnv 从环境变量读取策略：GRPC_COMPRESSION为默认的压缩器，
+// GRPC_COMPRESSION_METHODS为逗号分隔的 方法=压缩器 列表，例如 /Repo/CreateRepo=zstd,/Users/*=gzip
+func PolicyFromEnv() (*Policy, error) {
+	p := &Policy{Default: os.Getenv("GRPC_COMPRESSION"), Methods: make(map[string]string), MinSize: DefaultMinSize}
+	if v, ok := os.LookupEnv("GRPC_COMPRESS_MIN_SIZE"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid GRPC_COMPRESS_MIN_SIZE: %s", v)
+		}
+		p.MinSize = n
+	}
+	methods, err := ParseMethods(os.Getenv("GRPC_COMPRESSION_METHODS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GRPC_COMPRESSION_METHODS: %v", err)
+	}
+	p.Methods = methods
+	err = p.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ParseMethods 解析逗号分隔的 方法=压缩器 列表，例如 /Repo/CreateRepo=zstd,/Users/*=gzip
+func ParseMethods(list string) (map[string]string, error) {
+	methods := make(map[string]string)
+	for _, item := range strings.Split(list, ",") {
+		if len(strings.TrimSpace(item)) == 0 {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(strings.TrimSpace(kv[0]), "/") {
+			return nil, fmt.Errorf("entry %q, want /Service/Method=compressor", item)
+		}
+		methods[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return methods, nil
+}
+
+// Validate 检查策略使用的压缩器是否都受支持
+func (p *Policy) Validate() error {
+	for _, name := range p.Names() {
+		_, err := ParseList(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Names 返回策略使用的压缩器，需要在建立连接之前通过Register注册
+func (p *Policy) Names() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range append([]string{p.Default}, mapValues(p.Methods)...) {
+		if len(name) != 0 && name != encoding.Identity && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func mapValues(m map[string]string) []string {
+	var values []string
+	for _, v := range m {
+		values = append(values, v)
+	}
+	return values
+}
+
+// 方法使用的压缩器，精确的方法名优先于服务的通配符
+func (p *Policy) compressor(method string) string {
+	if name, ok := p.Methods[method]; ok {
+		return name
+	}
+	if i := strings.LastIndex(method, "/"); i > 0 {
+		if name, ok := p.Methods[method[:i]+"/*"]; ok {
+			return name
+		}
+	}
+	return p.Default
+}
+
+// UnaryClientInterceptor 按策略和请求的大小为一元调用选择压缩器
+func (p *Policy) UnaryClientInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	name := p.compressor(method)
+	if len(name) != 0 && name != encoding.Identity {
+		if m, ok := req.(proto.Message); !ok || proto.Size(m) >= p.MinSize {
+			opts = append([]grpc.CallOption{grpc.UseCompressor(name)}, opts...)
+		}
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// StreamClientInterceptor 按策略为流选择压缩器
+func (p *Policy) StreamClientInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	name := p.compressor(method)
+	if len(name) != 0 && name != encoding.Identity {
+		opts = append([]grpc.CallOption{grpc.UseCompressor(name)}, opts...)
+	}
+	return streamer(ctx, desc, cc, method, opts...)
+}
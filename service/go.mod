@@ -3,6 +3,7 @@ module github.com/calmw/grpc-service
 go 1.18
 
 require (
+	github.com/klauspost/compress v1.17.2
 	google.golang.org/grpc v1.53.0
 	google.golang.org/protobuf v1.29.0
 )
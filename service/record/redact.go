@@ -0,0 +1,113 @@
+// Package record 处理录制的RPC调用（CallRecord）中的敏感字段，服务端录制时用于脱敏，grpc-replay比较响应前用于清除忽略的字段，
+// 两者使用相同的路径格式
+package record
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"strings"
+)
+
+// 被脱敏的元数据值替换为该值
+const RedactedValue = "REDACTED"
+
+// 名称中包含这些字符串的元数据视为认证信息，例如 x-auth-token、session-token-bin
+var authMetadataParts = []string{"authorization", "auth-", "-auth", "token", "cookie", "secret", "password", "credential", "api-key"}
+
+// IsAuthMetadata 元数据是否携带认证信息，例如authorization、cookie以及名称中包含token、auth-等的二进制（-bin）元数据，key为小写
+func IsAuthMetadata(key string) bool {
+	for _, part := range authMetadataParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redactor 按路径清除消息中的字段，路径为 消息名.字段[.字段...]，例如 UserHelpRequest.user.first_name，
+// metadata.<key> 表示元数据。认证元数据（见IsAuthMetadata）总是脱敏，除非设置了KeepAuth
+type Redactor struct {
+	KeepAuth bool
+
+	fields   map[protoreflect.FullName][][]protoreflect.Name
+	metadata map[string]bool
+}
+
+// NewRedactor 解析逗号分隔的路径列表，路径中的消息或字段不存在时返回错误
+func NewRedactor(paths string) (*Redactor, error) {
+	r := &Redactor{
+		fields:   make(map[protoreflect.FullName][][]protoreflect.Name),
+		metadata: make(map[string]bool),
+	}
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimSpace(path)
+		if len(path) == 0 {
+			continue
+		}
+		if strings.HasPrefix(path, "metadata.") {
+			r.metadata[strings.ToLower(strings.TrimPrefix(path, "metadata."))] = true
+			continue
+		}
+		parts := strings.Split(path, ".")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid field path %s, want message.field", path)
+		}
+		d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid field path %s: %v", path, err)
+		}
+		md, ok := d.(protoreflect.MessageDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("invalid field path %s: %s is not a message", path, parts[0])
+		}
+		var names []protoreflect.Name
+		for _, p := range parts[1:] {
+			if md == nil {
+				return nil, fmt.Errorf("invalid field path %s: %s is not a message field", path, names[len(names)-1])
+			}
+			fd := md.Fields().ByName(protoreflect.Name(p))
+			if fd == nil {
+				return nil, fmt.Errorf("invalid field path %s: %s has no field %s", path, md.FullName(), p)
+			}
+			names = append(names, fd.Name())
+			md = fd.Message()
+		}
+		r.fields[protoreflect.FullName(parts[0])] = append(r.fields[protoreflect.FullName(parts[0])], names)
+	}
+	return r, nil
+}
+
+// Clear 清除消息中路径指定的字段
+func (r *Redactor) Clear(m proto.Message) {
+	pm := m.ProtoReflect()
+	for _, path := range r.fields[pm.Descriptor().FullName()] {
+		clearPath(pm, path)
+	}
+}
+
+// Metadata 元数据的值是否需要脱敏，key为小写
+func (r *Redactor) Metadata(key string) bool {
+	return r.metadata[key] || !r.KeepAuth && IsAuthMetadata(key)
+}
+
+// 清除路径上的字段，路径经过repeated字段时清除每个元素中的字段
+func clearPath(m protoreflect.Message, path []protoreflect.Name) {
+	fd := m.Descriptor().Fields().ByName(path[0])
+	if len(path) == 1 {
+		m.Clear(fd)
+		return
+	}
+	if !m.Has(fd) || fd.IsMap() {
+		return
+	}
+	if fd.IsList() {
+		l := m.Mutable(fd).List()
+		for i := 0; i < l.Len(); i++ {
+			clearPath(l.Get(i).Message(), path[1:])
+		}
+		return
+	}
+	clearPath(m.Mutable(fd).Message(), path[1:])
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+)
+
+// CreateRepoFromReader发送的每条data消息的最大字节数
+const RepoChunkSize = 32 << 10
+
+// CreateRepoFromReader 按CreateRepo的流协议发送仓库：先发送context消息，然后将r中的数据按RepoChunkSize分块作为data消息发送，
+// 最后发送携带数据SHA-256校验和与metadata的trailer消息，并检查服务端回复中的校验和。
+// 数据不会整体读入内存。读取r失败时返回读取的错误（不是gRPC状态），调用方应取消stream的context以中止调用
+func CreateRepoFromReader(
+	stream Repo_CreateRepoClient,
+	repoContext *RepoContext,
+	r io.Reader,
+	metadata map[string]string,
+) (*RepoCreateReply, error) {
+	err := stream.Send(&RepoCreateRequest{Body: &RepoCreateRequest_Context{Context: repoContext}})
+	if err == io.EOF { // 服务端已结束调用，真正的错误由CloseAndRecv返回
+		_, err = stream.CloseAndRecv()
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	buf := make([]byte, RepoChunkSize)
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			err = stream.Send(&RepoCreateRequest{Body: &RepoCreateRequest_Data{Data: buf[:n]}})
+			if err == io.EOF {
+				_, err = stream.CloseAndRecv()
+				return nil, err
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if readErr == io.EOF || errors.Is(readErr, io.ErrUnexpectedEOF) {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+
+	checksum := hex.EncodeToString(h.Sum(nil))
+	err = stream.Send(&RepoCreateRequest{Body: &RepoCreateRequest_Trailer{Trailer: &RepoTrailer{Sha256: checksum, Metadata: metadata}}})
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	reply, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, err
+	}
+	if reply.Sha256 != checksum {
+		return nil, status.Errorf(codes.DataLoss, "Checksum mismatch: sent %s, server received %s", checksum, reply.Sha256)
+	}
+	return reply, nil
+}
@@ -18,12 +18,13 @@ import (
 // Requires gRPC-Go v1.32.0 or later.
 const _ = grpc.SupportPackageIsVersion7
 
-// UsersClient is the health-check-client API for Users service.
+// UsersClient is the client API for Users service.
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type UsersClient interface {
 	GetUser(ctx context.Context, in *UserGetRequest, opts ...grpc.CallOption) (*UserGetReply, error)
 	GetHelp(ctx context.Context, opts ...grpc.CallOption) (Users_GetHelpClient, error)
+	UpdateUser(ctx context.Context, in *UserUpdateRequest, opts ...grpc.CallOption) (*UserUpdateReply, error)
 }
 
 type usersClient struct {
@@ -74,12 +75,22 @@ func (x *usersGetHelpClient) Recv() (*UserHelpReply, error) {
 	return m, nil
 }
 
+func (c *usersClient) UpdateUser(ctx context.Context, in *UserUpdateRequest, opts ...grpc.CallOption) (*UserUpdateReply, error) {
+	out := new(UserUpdateReply)
+	err := c.cc.Invoke(ctx, "/Users/UpdateUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // UsersServer is the server API for Users service.
 // All implementations must embed UnimplementedUsersServer
 // for forward compatibility
 type UsersServer interface {
 	GetUser(context.Context, *UserGetRequest) (*UserGetReply, error)
 	GetHelp(Users_GetHelpServer) error
+	UpdateUser(context.Context, *UserUpdateRequest) (*UserUpdateReply, error)
 	mustEmbedUnimplementedUsersServer()
 }
 
@@ -93,6 +104,9 @@ func (UnimplementedUsersServer) GetUser(context.Context, *UserGetRequest) (*User
 func (UnimplementedUsersServer) GetHelp(Users_GetHelpServer) error {
 	return status.Errorf(codes.Unimplemented, "method GetHelp not implemented")
 }
+func (UnimplementedUsersServer) UpdateUser(context.Context, *UserUpdateRequest) (*UserUpdateReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateUser not implemented")
+}
 func (UnimplementedUsersServer) mustEmbedUnimplementedUsersServer() {}
 
 // UnsafeUsersServer may be embedded to opt out of forward compatibility for this service.
@@ -150,6 +164,24 @@ func (x *usersGetHelpServer) Recv() (*UserHelpRequest, error) {
 	return m, nil
 }
 
+func _Users_UpdateUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UserUpdateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).UpdateUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Users/UpdateUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).UpdateUser(ctx, req.(*UserUpdateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Users_ServiceDesc is the grpc.ServiceDesc for Users service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -161,6 +193,10 @@ var Users_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetUser",
 			Handler:    _Users_GetUser_Handler,
 		},
+		{
+			MethodName: "UpdateUser",
+			Handler:    _Users_UpdateUser_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{